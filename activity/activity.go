@@ -0,0 +1,435 @@
+// Package activity signals short-lived user activities, e.g. typing or viewing a document, through presence state.
+//
+// A Publisher sets the key "<prefix><kind>" of its presence state on Signal, and removes it after Idle
+// without another Signal. A Receiver derives ActivityEvents of other users from a presence.Roster.
+package activity
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+	"github.com/tomasliu-agora/rtm2/presence"
+	"go.uber.org/zap"
+)
+
+type Options struct {
+	// Idle is the period without Signal after which an activity is removed, 5 seconds by default.
+	Idle time.Duration
+	// Coalesce is the minimal delay between state updates of an activity, 500 milliseconds by default.
+	// Signals in between only keep the latest value.
+	Coalesce time.Duration
+	// Prefix of presence state keys, "activity." by default. Publishers and receivers must use the same one.
+	Prefix string
+	Logger *zap.Logger
+}
+
+type Option func(*Options)
+
+func WithIdle(idle time.Duration) Option {
+	return func(o *Options) {
+		o.Idle = idle
+	}
+}
+
+func WithCoalesce(interval time.Duration) Option {
+	return func(o *Options) {
+		o.Coalesce = interval
+	}
+}
+
+func WithPrefix(prefix string) Option {
+	return func(o *Options) {
+		o.Prefix = prefix
+	}
+}
+
+func WithLogger(lg *zap.Logger) Option {
+	return func(o *Options) {
+		o.Logger = lg
+	}
+}
+
+func newOptions(opts []Option) *Options {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Idle <= 0 {
+		o.Idle = 5 * time.Second
+	}
+	if o.Coalesce <= 0 {
+		o.Coalesce = 500 * time.Millisecond
+	}
+	if o.Prefix == "" {
+		o.Prefix = "activity."
+	}
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+	return o
+}
+
+type signal struct {
+	value string
+	// active is false once idle or cleared, until the key is removed
+	active    bool
+	sent      bool
+	sentValue string
+	lastSent  time.Time
+	// busy tells a SetState or RemoveState in flight, which syncs the latest signal again once done
+	busy    bool
+	flush   *time.Timer
+	idle    *time.Timer
+	retry   *time.Timer
+	backoff time.Duration
+	// gen tells the idle timer of the latest Signal
+	gen int
+}
+
+func (s *signal) stopTimers() {
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	if s.flush != nil {
+		s.flush.Stop()
+		s.flush = nil
+	}
+	if s.retry != nil {
+		s.retry.Stop()
+		s.retry = nil
+	}
+}
+
+// Publisher signals activities of the local user in a channel.
+type Publisher struct {
+	presence    rtm2.Presence
+	channel     string
+	channelType rtm2.ChannelType
+	o           *Options
+	lg          *zap.Logger
+
+	mu      sync.Mutex
+	signals map[string]*signal
+	closed  bool
+}
+
+func NewPublisher(presence rtm2.Presence, channel string, channelType rtm2.ChannelType, opts ...Option) *Publisher {
+	o := newOptions(opts)
+	return &Publisher{
+		presence:    presence,
+		channel:     channel,
+		channelType: channelType,
+		o:           o,
+		lg:          o.Logger.With(zap.String("channel", channel)),
+		signals:     make(map[string]*signal),
+	}
+}
+
+// Signal marks the activity of kind with value, e.g. Signal("typing", "") or Signal("viewing", "doc-1"),
+// and restarts its idle period. Repeated signals of the same value are not sent again, and value changes are sent
+// at most once per Coalesce. Errors of delayed updates are logged, and failed removals are retried with backoff.
+func (p *Publisher) Signal(kind, value string) error {
+	key := p.o.Prefix + kind
+	switch {
+	case kind == "":
+		return rtm2.ERR_PRESENCE_STATE_INVALID_KEY
+	case len(key) > rtm2.PresenceStateKeyMaxSize:
+		return rtm2.ERR_PRESENCE_STATE_KEY_SIZE_OVERFLOW
+	case len(value) > rtm2.PresenceStateValueMaxSize:
+		return rtm2.ERR_PRESENCE_STATE_VALUE_SIZE_OVERFLOW
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	s, ok := p.signals[kind]
+	if !ok {
+		s = &signal{}
+		p.signals[kind] = s
+	}
+	s.value = value
+	s.active = true
+	if s.retry != nil {
+		s.retry.Stop()
+		s.retry = nil
+	}
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.gen++
+	gen := s.gen
+	s.idle = time.AfterFunc(p.o.Idle, func() { p.expire(kind, s, gen) })
+	if s.flush != nil {
+		return nil
+	}
+	return p.sync(kind, s)
+}
+
+// sync sends the latest value of an active signal, or removes the key of an inactive one, until the state is
+// up to date. It must be called with p.mu locked, which is released during calls of the rtm service,
+// so that a single call per kind is in flight and later signals are not blocked.
+func (p *Publisher) sync(kind string, s *signal) error {
+	if s.busy {
+		return nil
+	}
+	s.busy = true
+	defer func() { s.busy = false }()
+	key := p.o.Prefix + kind
+	for {
+		switch {
+		case s.active && !(s.sent && s.sentValue == s.value):
+			if wait := p.o.Coalesce - time.Since(s.lastSent); wait > 0 {
+				if s.flush == nil {
+					s.flush = time.AfterFunc(wait, func() { p.flush(kind, s) })
+				}
+				return nil
+			}
+			value := s.value
+			s.lastSent = time.Now()
+			p.mu.Unlock()
+			err := p.presence.SetState(p.channel, p.channelType, map[string]string{key: value})
+			p.mu.Lock()
+			if err != nil {
+				return err
+			}
+			s.sent = true
+			s.sentValue = value
+		case !s.active && s.sent:
+			p.mu.Unlock()
+			err := p.presence.RemoveState(p.channel, p.channelType, []string{key})
+			p.mu.Lock()
+			if err != nil {
+				p.retryLater(kind, s)
+				return err
+			}
+			s.sent = false
+			s.backoff = 0
+		default:
+			if !s.active && p.signals[kind] == s {
+				delete(p.signals, kind)
+			}
+			return nil
+		}
+	}
+}
+
+func (p *Publisher) flush(kind string, s *signal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.signals[kind] != s {
+		return
+	}
+	s.flush = nil
+	if err := p.sync(kind, s); err != nil {
+		p.lg.Warn("set activity failed", zap.String("kind", kind), zap.Error(err))
+	}
+}
+
+func (p *Publisher) expire(kind string, s *signal, gen int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.signals[kind] != s || s.gen != gen || !s.active {
+		return
+	}
+	if err := p.clear(kind, s); err != nil {
+		p.lg.Warn("remove idle activity failed", zap.String("kind", kind), zap.Error(err))
+	}
+}
+
+// clear must be called with p.mu locked. It deactivates the signal and removes its key, retried on failure.
+func (p *Publisher) clear(kind string, s *signal) error {
+	s.active = false
+	s.stopTimers()
+	return p.sync(kind, s)
+}
+
+// retryLater schedules the removal of an inactive signal again, with backoff doubled from Coalesce up to Idle.
+// It must be called with p.mu locked.
+func (p *Publisher) retryLater(kind string, s *signal) {
+	if s.active || s.retry != nil || p.signals[kind] != s {
+		return
+	}
+	s.backoff = min(max(s.backoff*2, p.o.Coalesce), p.o.Idle)
+	s.retry = time.AfterFunc(s.backoff, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		s.retry = nil
+		if p.signals[kind] != s || s.active {
+			return
+		}
+		if err := p.sync(kind, s); err != nil {
+			p.lg.Warn("remove activity failed", zap.String("kind", kind), zap.Error(err), zap.Duration("backoff", s.backoff))
+		}
+	})
+}
+
+// Clear removes the activity of kind immediately, e.g. when a message is sent after typing.
+func (p *Publisher) Clear(kind string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.signals[kind]
+	if !ok || !s.active {
+		return nil
+	}
+	return p.clear(kind, s)
+}
+
+// Close clears all activities, and ignores later signals.
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	var kinds, keys []string
+	for kind, s := range p.signals {
+		s.active = false
+		s.stopTimers()
+		switch {
+		case s.busy:
+			// the call in flight removes the key once done
+		case !s.sent:
+			delete(p.signals, kind)
+		default:
+			s.busy = true
+			kinds = append(kinds, kind)
+			keys = append(keys, p.o.Prefix+kind)
+		}
+	}
+	p.mu.Unlock()
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	err := p.presence.RemoveState(p.channel, p.channelType, keys)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, kind := range kinds {
+		s := p.signals[kind]
+		s.busy = false
+		if err != nil {
+			p.retryLater(kind, s)
+			continue
+		}
+		s.sent = false
+		delete(p.signals, kind)
+	}
+	return err
+}
+
+// ActivityEvent reports an activity of a user started, changed its value, or stopped when Active is false.
+type ActivityEvent struct {
+	UserId string
+	Kind   string
+	Value  string
+	Active bool
+}
+
+// Receiver derives ActivityEvents from the presence states of a Roster, which merges StateChange and interval events.
+// Events are deduplicated: one is sent only when the activity of a user starts, changes or stops.
+// Users leaving or timing out stop all their activities.
+type Receiver struct {
+	prefix string
+	cancel func()
+	events chan ActivityEvent
+	box    *outbox.Outbox
+
+	mu     sync.Mutex
+	active map[string]map[string]string
+}
+
+func NewReceiver(roster *presence.Roster, opts ...Option) *Receiver {
+	o := newOptions(opts)
+	r := &Receiver{
+		prefix: o.Prefix,
+		events: make(chan ActivityEvent),
+		box:    outbox.New(),
+		active: make(map[string]map[string]string),
+	}
+	r.cancel = roster.OnChange(func(c *presence.Change) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.update(c.UserId, c.State)
+	})
+	// changes applied meanwhile wait for the current states, then are deduplicated
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, userId := range roster.Users() {
+		if state, ok := roster.State(userId); ok {
+			r.update(userId, state)
+		}
+	}
+	return r
+}
+
+// update compares the activities in state with the known ones of userId. A nil state stops all of them.
+// update must be called with r.mu locked.
+func (r *Receiver) update(userId string, state map[string]string) {
+	next := make(map[string]string)
+	for k, v := range state {
+		if kind, ok := strings.CutPrefix(k, r.prefix); ok && kind != "" {
+			next[kind] = v
+		}
+	}
+	prev := r.active[userId]
+	for _, kind := range sortedKeys(prev) {
+		if _, ok := next[kind]; !ok {
+			r.send(ActivityEvent{UserId: userId, Kind: kind, Value: prev[kind]})
+		}
+	}
+	for _, kind := range sortedKeys(next) {
+		if old, ok := prev[kind]; !ok || old != next[kind] {
+			r.send(ActivityEvent{UserId: userId, Kind: kind, Value: next[kind], Active: true})
+		}
+	}
+	if len(next) == 0 {
+		delete(r.active, userId)
+	} else {
+		r.active[userId] = next
+	}
+}
+
+// send must be called with r.mu locked to keep the order.
+func (r *Receiver) send(e ActivityEvent) {
+	r.box.Push(func(done <-chan struct{}) {
+		select {
+		case r.events <- e:
+		case <-done:
+		}
+	})
+}
+
+// Events returns the golang chan receiving ActivityEvents, which never blocks the Roster.
+func (r *Receiver) Events() <-chan ActivityEvent {
+	return r.events
+}
+
+// Active returns the values of the activity of kind by user id.
+func (r *Receiver) Active(kind string) map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := make(map[string]string)
+	for userId, kinds := range r.active {
+		if v, ok := kinds[kind]; ok {
+			res[userId] = v
+		}
+	}
+	return res
+}
+
+// Close stops deriving events. The golang chan of Events is left open, same as rtm sdk.
+func (r *Receiver) Close() {
+	r.cancel()
+	r.box.Close()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,41 @@
+// Command rtm2-localserver runs a local stand-in of the rtm service for multi-process integration tests.
+// Connect with localrtm.CreateRTMClient, see localrtm/PROTOCOL.md for the wire protocol.
+package main
+
+import (
+	"flag"
+	"net"
+
+	"github.com/tomasliu-agora/rtm2/localrtm"
+	"github.com/tomasliu-agora/rtm2/memrtm"
+	"go.uber.org/zap"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:9527", "listen address")
+	interval := flag.Duration("presence-interval", 0, "presence interval, 0 to disable interval mode")
+	threshold := flag.Int("presence-threshold", 50, "count of users in a channel to switch into presence interval mode")
+	pageSize := flag.Int("page-size", 100, "page size of WhoNow")
+	debug := flag.Bool("debug", false, "enable debug log")
+	flag.Parse()
+
+	cfg := zap.NewProductionConfig()
+	if *debug {
+		cfg.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	}
+	lg, err := cfg.Build()
+	if err != nil {
+		panic(err)
+	}
+	defer lg.Sync()
+
+	hub := memrtm.NewHub(memrtm.WithPresenceInterval(*interval, *threshold), memrtm.WithWhoNowPageSize(*pageSize))
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		lg.Fatal("listen failed", zap.String("addr", *addr), zap.Error(err))
+	}
+	lg.Info("rtm2 local server started", zap.String("addr", l.Addr().String()))
+	if err := localrtm.NewServer(hub, lg).Serve(l); err != nil {
+		lg.Fatal("serve failed", zap.Error(err))
+	}
+}
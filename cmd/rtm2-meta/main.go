@@ -0,0 +1,182 @@
+// Command rtm2-meta exports, diffs and imports channel and user metadata, e.g. to back up room configuration
+// or to migrate it between app ids. It connects to the rtm service through localrtm, see cmd/rtm2-localserver.
+//
+//	rtm2-meta -appid A export -channels room1,room2 -users alice -o rooms.yaml
+//	rtm2-meta diff old.json new.yaml
+//	rtm2-meta -appid B import -policy skip -dry-run rooms.yaml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/localrtm"
+	"github.com/tomasliu-agora/rtm2/metadata"
+)
+
+func main() {
+	fs := flag.NewFlagSet("rtm2-meta", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:9527", "address of the rtm service")
+	appid := fs.String("appid", "", "app id")
+	userId := fs.String("user", "rtm2-meta", "user id to login")
+	token := fs.String("token", "", "token to login")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: rtm2-meta [flags] export|diff|import [args]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	login := func() rtm2.RTMClient {
+		client := localrtm.CreateRTMClient(*addr, &rtm2.RTMConfig{Appid: *appid, UserId: *userId})
+		if _, _, err := client.Login(*token); err != nil {
+			fatal("login failed: %v", err)
+		}
+		return client
+	}
+
+	var err error
+	switch cmd, args := fs.Arg(0), fs.Args()[1:]; cmd {
+	case "export":
+		err = export(login, args)
+	case "diff":
+		err = diff(args)
+	case "import":
+		err = importSnapshot(login, args)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+}
+
+func export(login func() rtm2.RTMClient, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	channels := fs.String("channels", "", "comma separated Message Channels")
+	streams := fs.String("streams", "", "comma separated Stream Channels")
+	users := fs.String("users", "", "comma separated user ids")
+	output := fs.String("o", "", "output file, stdout if empty")
+	format := fs.String("format", "", "json or yaml, by the extension of output file by default")
+	fs.Parse(args)
+
+	var infos []*rtm2.ChannelInfo
+	for _, name := range split(*channels) {
+		infos = append(infos, &rtm2.ChannelInfo{Channel: name, Type: rtm2.ChannelTypeMessage})
+	}
+	for _, name := range split(*streams) {
+		infos = append(infos, &rtm2.ChannelInfo{Channel: name, Type: rtm2.ChannelTypeStream})
+	}
+	client := login()
+	defer client.Logout()
+	snapshot, err := metadata.Export(client.Storage(), infos, split(*users))
+	if err != nil {
+		return err
+	}
+	f := metadata.Format(*format)
+	if f == "" {
+		f = metadata.FormatOf(*output)
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return snapshot.Encode(w, f)
+}
+
+func diff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: rtm2-meta diff <old> <new>")
+	}
+	a, err := load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := load(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	changes, err := metadata.Diff(a, b)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func importSnapshot(login func() rtm2.RTMClient, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print changes without writing")
+	policy := fs.String("policy", "overwrite", "for existing items with another value: overwrite, skip or fail")
+	batchItems := fs.Int("batch-items", 64, "max items of a single write")
+	batchBytes := fs.Int("batch-bytes", 16*1024, "max sum of keys and values of a single write")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: rtm2-meta import [flags] <file>")
+	}
+	p, err := metadata.ParseConflictPolicy(*policy)
+	if err != nil {
+		return err
+	}
+	snapshot, err := load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	client := login()
+	defer client.Logout()
+	res, err := metadata.Import(client.Storage(), snapshot,
+		metadata.WithDryRun(*dryRun), metadata.WithConflictPolicy(p), metadata.WithBatchSize(*batchItems, *batchBytes))
+	if res != nil {
+		for _, c := range res.Applied {
+			fmt.Println(c)
+		}
+		for _, c := range res.Skipped {
+			fmt.Println("skipped", c)
+		}
+		fmt.Printf("%d applied, %d skipped in %d batches\n", len(res.Applied), len(res.Skipped), res.Batches)
+	}
+	return err
+}
+
+func load(path string) (*metadata.Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return metadata.DecodeSnapshot(f, metadata.FormatOf(path))
+}
+
+func split(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "rtm2-meta: "+format+"\n", args...)
+	os.Exit(1)
+}
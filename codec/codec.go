@@ -0,0 +1,85 @@
+// Package codec encodes typed payloads into rtm2 messages.
+//
+// Every payload is wrapped in a 2-byte envelope header: envelopeMagic followed by the ID of the Codec.
+// Receivers pick the codec by the header, so that clients publishing with different codecs can interoperate.
+// Payloads without the header are decoded by the codec given to the subscriber.
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+const envelopeMagic byte = 0x1e
+
+type Codec interface {
+	// ID identifies the codec in the envelope header.
+	ID() byte
+	// Name for logs and errors.
+	Name() string
+	// MessageType is set on every published message.
+	MessageType() rtm2.MessageType
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	ErrUnknownCodec = errors.New("unknown codec")
+	ErrDuplicateID  = errors.New("duplicate codec id")
+)
+
+var (
+	mu     sync.RWMutex
+	codecs = map[byte]Codec{
+		JSON.ID():     JSON,
+		Protobuf.ID(): Protobuf,
+		MsgPack.ID():  MsgPack,
+	}
+)
+
+// Register adds a custom codec, so that its envelopes can be decoded.
+func Register(c Codec) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := codecs[c.ID()]; ok {
+		return ErrDuplicateID
+	}
+	codecs[c.ID()] = c
+	return nil
+}
+
+// Lookup returns the registered codec by ID.
+func Lookup(id byte) (Codec, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := codecs[id]
+	return c, ok
+}
+
+// Encode marshals v by c, with the envelope header.
+func Encode(c Codec, v interface{}) ([]byte, error) {
+	payload, err := c.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s marshal: %w", c.Name(), err)
+	}
+	return append([]byte{envelopeMagic, c.ID()}, payload...), nil
+}
+
+// Decode unmarshals data into v by the codec in the envelope header, or by fallback if data has no header.
+func Decode(data []byte, fallback Codec, v interface{}) error {
+	c := fallback
+	if len(data) >= 2 && data[0] == envelopeMagic {
+		var ok bool
+		if c, ok = Lookup(data[1]); !ok {
+			return fmt.Errorf("%w: %#x", ErrUnknownCodec, data[1])
+		}
+		data = data[2:]
+	}
+	if err := c.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s unmarshal: %w", c.Name(), err)
+	}
+	return nil
+}
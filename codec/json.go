@@ -0,0 +1,24 @@
+package codec
+
+import (
+	"encoding/json"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+// JSON encodes by encoding/json, published as MessageTypeString.
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte                      { return 'j' }
+func (jsonCodec) Name() string                  { return "json" }
+func (jsonCodec) MessageType() rtm2.MessageType { return rtm2.MessageTypeString }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,23 @@
+package codec
+
+import (
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgPack encodes by MessagePack, published as MessageTypeBinary.
+var MsgPack Codec = msgpackCodec{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() byte                      { return 'm' }
+func (msgpackCodec) Name() string                  { return "msgpack" }
+func (msgpackCodec) MessageType() rtm2.MessageType { return rtm2.MessageTypeBinary }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
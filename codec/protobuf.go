@@ -0,0 +1,34 @@
+package codec
+
+import (
+	"fmt"
+
+	"github.com/tomasliu-agora/rtm2"
+	"google.golang.org/protobuf/proto"
+)
+
+// Protobuf encodes proto.Message values, published as MessageTypeBinary.
+// Typed helpers must be instantiated with the pointer type, e.g. SubscribeTyped[*pb.Chat].
+var Protobuf Codec = protobufCodec{}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ID() byte                      { return 'p' }
+func (protobufCodec) Name() string                  { return "protobuf" }
+func (protobufCodec) MessageType() rtm2.MessageType { return rtm2.MessageTypeBinary }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,137 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+)
+
+// TypedMessage is a decoded Message.
+type TypedMessage[T any] struct {
+	UserId string
+	Value  T
+}
+
+// DecodeError is reported on the error chan of typed subscriptions, the message is skipped.
+type DecodeError struct {
+	Message *rtm2.Message
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode message from %s: %s", e.Message.UserId, e.Err.Error())
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// PublishTyped publishes v encoded by c into certain Message Channel. MessageType is set by c.
+func PublishTyped[T any](client rtm2.RTMClient, c Codec, channel string, v T, opts ...rtm2.MessageOption) error {
+	data, err := Encode(c, v)
+	if err != nil {
+		return err
+	}
+	return client.Publish(channel, data, append(opts[:len(opts):len(opts)], rtm2.WithMessageType(c.MessageType()))...)
+}
+
+// SubscribeTyped subscribes certain Message Channel, and decodes messages into T.
+// Messages that fail to decode are reported on the DecodeError chan, which does not block the message chan if unread.
+// cancel unsubscribes the channel and stops decoding. The golang chans are left open, same as rtm sdk.
+func SubscribeTyped[T any](client rtm2.RTMClient, c Codec, channel string, opts ...rtm2.MessageOption) (<-chan TypedMessage[T], <-chan *DecodeError, func() error, error) {
+	messages, err := client.Subscribe(channel, opts...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	out, errs, stop := decodeMessages[T](messages, c)
+	return out, errs, func() error {
+		defer stop()
+		return client.Unsubscribe(channel)
+	}, nil
+}
+
+// PublishTopicTyped publishes v encoded by c to certain joined topic. MessageType is set by c.
+func PublishTopicTyped[T any](stream rtm2.StreamChannel, c Codec, topic string, v T, opts ...rtm2.StreamOption) error {
+	data, err := Encode(c, v)
+	if err != nil {
+		return err
+	}
+	return stream.PublishTopic(topic, data, append(opts[:len(opts):len(opts)], rtm2.WithStreamMessageType(c.MessageType()))...)
+}
+
+// SubscribeTopicTyped subscribes certain topic on certain users, and decodes messages into T.
+// rtm sdk delivers all messages of a topic to one golang chan, which is shared by every SubscribeTopic of the topic,
+// so call it once per topic and fan out the decoded messages; a second call on the same topic splits them.
+// cancel unsubscribes userIds of the topic and stops decoding. The golang chans are left open, same as rtm sdk.
+func SubscribeTopicTyped[T any](stream rtm2.StreamChannel, c Codec, topic string, userIds []string) (<-chan TypedMessage[T], <-chan *DecodeError, func() error, error) {
+	messages, err := stream.SubscribeTopic(topic, userIds)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	out, errs, stop := decodeMessages[T](messages, c)
+	return out, errs, func() error {
+		defer stop()
+		return stream.UnsubscribeTopic(topic, userIds)
+	}, nil
+}
+
+// DecodeTyped decodes a single message into T, by the codec in its envelope header or by c.
+func DecodeTyped[T any](m *rtm2.Message, c Codec) (T, error) {
+	var v T
+	if t := reflect.TypeOf(v); t != nil && t.Kind() == reflect.Ptr {
+		// decode into a new value, e.g. proto.Message must be a non-nil pointer
+		v = reflect.New(t.Elem()).Interface().(T)
+		return v, Decode(m.Message, c, v)
+	}
+	err := Decode(m.Message, c, &v)
+	return v, err
+}
+
+// decodeMessages decodes in until stop is called, which is safe to call more than once.
+func decodeMessages[T any](in <-chan *rtm2.Message, c Codec) (<-chan TypedMessage[T], <-chan *DecodeError, func()) {
+	out := make(chan TypedMessage[T])
+	errs := make(chan *DecodeError)
+	outBox, errBox := outbox.New(), outbox.New()
+	quit := make(chan struct{})
+	var once sync.Once
+	go func() {
+		for {
+			var m *rtm2.Message
+			select {
+			case msg, ok := <-in:
+				if !ok {
+					return
+				}
+				m = msg
+			case <-quit:
+				outBox.Close()
+				errBox.Close()
+				return
+			}
+			v, err := DecodeTyped[T](m, c)
+			if err != nil {
+				e := &DecodeError{Message: m, Err: err}
+				errBox.Push(func(done <-chan struct{}) {
+					select {
+					case errs <- e:
+					case <-done:
+					}
+				})
+				continue
+			}
+			tm := TypedMessage[T]{UserId: m.UserId, Value: v}
+			outBox.Push(func(done <-chan struct{}) {
+				select {
+				case out <- tm:
+				case <-done:
+				}
+			})
+		}
+	}()
+	return out, errs, func() {
+		once.Do(func() { close(quit) })
+	}
+}
@@ -52,3 +52,19 @@ const (
 	ConnectionChangedReasonStreamChannelNotAvaliabled = 22
 	ConnectionChangedReasonLoginSuccess               = 10001
 )
+
+const (
+	// Limits enforced by rtm service. Exceeding them returns the matching ERR_* in errors.go.
+
+	MetadataKeyMaxSize        = 64    // ERR_METADATA_KEY_SIZE_OVERFLOW
+	MetadataValueMaxSize      = 8192  // ERR_METADATA_VALUE_SIZE_OVERFLOW
+	MetadataItemMaxCount      = 256   // ERR_METADATA_ITEM_SIZE_OVERFLOW
+	MetadataMaxSize           = 32768 // ERR_METADATA_SIZE_OVERFLOW, sum of all keys and values
+	PresenceStateKeyMaxSize   = 64    // ERR_PRESENCE_STATE_KEY_SIZE_OVERFLOW
+	PresenceStateValueMaxSize = 256   // ERR_PRESENCE_STATE_VALUE_SIZE_OVERFLOW
+	PresenceStateMaxSize      = 1024  // ERR_PRESENCE_STATE_SIZE_OVERFLOW, sum of all keys and values
+	ChannelMaxCount           = 50    // ERR_EXCEED_CHANNEL_LIMITATION
+	TopicJoinMaxCount         = 8     // ERR_EXCEED_JOIN_TOPIC_LIMITATION
+	TopicSubscribeMaxCount    = 50    // ERR_EXCEED_SUBSCRIBE_TOPIC_LIMITATION
+	TopicUserMaxCount         = 64    // ERR_EXCEED_USER_LIMITATION
+)
@@ -0,0 +1,591 @@
+package rtm2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// ErrCanceled matches, by errors.Is, every error returned by Context* interfaces
+// once the context is done before the operation completes.
+// The error also matches context.Canceled or context.DeadlineExceeded.
+var ErrCanceled = errors.New("operation canceled")
+
+type canceledError struct {
+	op    string
+	cause error
+}
+
+func (e *canceledError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.op, ErrCanceled.Error(), e.cause.Error())
+}
+
+func (e *canceledError) Is(target error) bool {
+	return target == ErrCanceled
+}
+
+func (e *canceledError) Unwrap() error {
+	return e.cause
+}
+
+// await runs call in background and waits for it or ctx.
+// If ctx is done first, undo is invoked once the abandoned call succeeds, so that no subscription is left behind.
+func await(ctx context.Context, op string, call func() error, undo func()) error {
+	if err := ctx.Err(); err != nil {
+		return &canceledError{op: op, cause: err}
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if undo != nil {
+			go func() {
+				if err := <-done; err == nil {
+					undo()
+				}
+			}()
+		}
+		return &canceledError{op: op, cause: ctx.Err()}
+	}
+}
+
+// ContextClient is RTMClient with a context on every call.
+// Canceled Login, Subscribe, Join, JoinTopic, SubscribeTopic, SubscribeUserMetadata and Acquire are reverted once they complete.
+// Writes such as Publish or SetChannelMetadata can not be reverted, and may still take effect after cancellation.
+type ContextClient interface {
+	Login(ctx context.Context, token string) (<-chan *ConnectionEvent, <-chan string, error)
+	Logout(ctx context.Context) error
+	SetParameters(ctx context.Context, params map[string]interface{}) error
+	GetParameters(ctx context.Context) (map[string]interface{}, error)
+	RenewToken(ctx context.Context, token string) error
+
+	Storage() ContextStorage
+	Lock() ContextLock
+	Presence() ContextPresence
+
+	Publish(ctx context.Context, channel string, message []byte, opts ...MessageOption) error
+	Subscribe(ctx context.Context, channel string, opts ...MessageOption) (chan *Message, error)
+	Unsubscribe(ctx context.Context, channel string) error
+
+	StreamChannel(channel string) ContextStreamChannel
+
+	// Client returns the wrapped RTMClient.
+	Client() RTMClient
+}
+
+type ContextStreamChannel interface {
+	Join(ctx context.Context, opts ...StreamOption) (map[string][]string, <-chan *TopicEvent, <-chan string, error)
+	Leave(ctx context.Context) error
+	ChannelName() string
+	JoinTopic(ctx context.Context, topic string, opt ...StreamOption) error
+	PublishTopic(ctx context.Context, topic string, message []byte, opts ...StreamOption) error
+	LeaveTopic(ctx context.Context, topic string) error
+	// SubscribeTopic reverts a canceled subscription by UnsubscribeTopic with the same userIds.
+	SubscribeTopic(ctx context.Context, topic string, userIds []string) (<-chan *Message, error)
+	UnsubscribeTopic(ctx context.Context, topic string, userIds []string) error
+	GetSubscribedUsers(ctx context.Context, topic string) ([]string, error)
+	RenewToken(ctx context.Context, token string) error
+}
+
+type ContextStorage interface {
+	GetChannelMetadataChan(ctx context.Context, channel string, channelType ChannelType) (map[string]*MetadataItem, <-chan *StorageEvent, error)
+	SetChannelMetadata(ctx context.Context, channel string, channelType ChannelType, data map[string]*MetadataItem, opts ...StorageOption) error
+	UpdateChannelMetadata(ctx context.Context, channel string, channelType ChannelType, data map[string]*MetadataItem, opts ...StorageOption) error
+	RemoveChannelMetadata(ctx context.Context, channel string, channelType ChannelType, data map[string]*MetadataItem, opts ...StorageOption) error
+	GetChannelMetadata(ctx context.Context, channel string, channelType ChannelType) (int64, map[string]*MetadataItem, error)
+	SetUserMetadata(ctx context.Context, userId string, data map[string]*MetadataItem, opts ...StorageOption) error
+	UpdateUserMetadata(ctx context.Context, userId string, data map[string]*MetadataItem, opts ...StorageOption) error
+	RemoveUserMetadata(ctx context.Context, userId string, data map[string]*MetadataItem, opts ...StorageOption) error
+	GetUserMetadata(ctx context.Context, userId string) (int64, map[string]*MetadataItem, error)
+	SubscribeUserMetadata(ctx context.Context, userId string) (map[string]*MetadataItem, <-chan *StorageEvent, error)
+	UnsubscribeUserMetadata(ctx context.Context, userId string) error
+}
+
+type ContextLock interface {
+	GetLockChan(ctx context.Context, channel string, channelType ChannelType) (map[string]*LockDetail, <-chan *LockEvent, error)
+	Set(ctx context.Context, channel string, channelType ChannelType, name string, ttl uint32) error
+	Get(ctx context.Context, channel string, channelType ChannelType) (map[string]*LockDetail, error)
+	Remove(ctx context.Context, channel string, channelType ChannelType, name string) error
+	// Acquire waits for the result of Lock.Acquire.
+	// If ctx is done first, a pending acquire is canceled by Release, and a lock acquired afterwards is released.
+	Acquire(ctx context.Context, channel string, channelType ChannelType, name string, retry bool) error
+	Release(ctx context.Context, channel string, channelType ChannelType, name string) error
+	Revoke(ctx context.Context, channel string, channelType ChannelType, name string, owner string) error
+}
+
+type ContextPresence interface {
+	GetPresenceChan(ctx context.Context, channel string, channelType ChannelType) (map[string]*UserState, <-chan *PresenceEvent, error)
+	WhoNow(ctx context.Context, channel string, channelType ChannelType, opts ...PresenceOption) (map[string]*UserState, string, error)
+	WhereNow(ctx context.Context, userId string) ([]*ChannelInfo, error)
+	SetState(ctx context.Context, channel string, channelType ChannelType, data map[string]string) error
+	RemoveState(ctx context.Context, channel string, channelType ChannelType, keys []string) error
+	GetState(ctx context.Context, channel string, channelType ChannelType, userId string) (map[string]string, error)
+}
+
+// WithContext wraps an RTMClient into a ContextClient.
+func WithContext(client RTMClient) ContextClient {
+	return &contextClient{client: client}
+}
+
+type contextClient struct {
+	client RTMClient
+}
+
+func (c *contextClient) Client() RTMClient {
+	return c.client
+}
+
+func (c *contextClient) Login(ctx context.Context, token string) (<-chan *ConnectionEvent, <-chan string, error) {
+	var events <-chan *ConnectionEvent
+	var tokens <-chan string
+	err := await(ctx, "Login", func() (err error) {
+		events, tokens, err = c.client.Login(token)
+		return
+	}, func() {
+		c.client.Logout()
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return events, tokens, nil
+}
+
+func (c *contextClient) Logout(ctx context.Context) error {
+	return await(ctx, "Logout", c.client.Logout, nil)
+}
+
+func (c *contextClient) SetParameters(ctx context.Context, params map[string]interface{}) error {
+	return await(ctx, "SetParameters", func() error {
+		return c.client.SetParameters(params)
+	}, nil)
+}
+
+func (c *contextClient) GetParameters(ctx context.Context) (map[string]interface{}, error) {
+	var params map[string]interface{}
+	err := await(ctx, "GetParameters", func() error {
+		params = c.client.GetParameters()
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+func (c *contextClient) RenewToken(ctx context.Context, token string) error {
+	return await(ctx, "RenewToken", func() error {
+		return c.client.RenewToken(token)
+	}, nil)
+}
+
+func (c *contextClient) Storage() ContextStorage {
+	return &contextStorage{storage: c.client.Storage()}
+}
+
+func (c *contextClient) Lock() ContextLock {
+	return &contextLock{lock: c.client.Lock()}
+}
+
+func (c *contextClient) Presence() ContextPresence {
+	return &contextPresence{presence: c.client.Presence()}
+}
+
+func (c *contextClient) Publish(ctx context.Context, channel string, message []byte, opts ...MessageOption) error {
+	return await(ctx, "Publish", func() error {
+		return c.client.Publish(channel, message, opts...)
+	}, nil)
+}
+
+func (c *contextClient) Subscribe(ctx context.Context, channel string, opts ...MessageOption) (chan *Message, error) {
+	var messages chan *Message
+	err := await(ctx, "Subscribe", func() (err error) {
+		messages, err = c.client.Subscribe(channel, opts...)
+		return
+	}, func() {
+		c.client.Unsubscribe(channel)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (c *contextClient) Unsubscribe(ctx context.Context, channel string) error {
+	return await(ctx, "Unsubscribe", func() error {
+		return c.client.Unsubscribe(channel)
+	}, nil)
+}
+
+func (c *contextClient) StreamChannel(channel string) ContextStreamChannel {
+	return &contextStreamChannel{stream: c.client.StreamChannel(channel)}
+}
+
+type contextStreamChannel struct {
+	stream StreamChannel
+}
+
+func (s *contextStreamChannel) Join(ctx context.Context, opts ...StreamOption) (map[string][]string, <-chan *TopicEvent, <-chan string, error) {
+	var snapshot map[string][]string
+	var events <-chan *TopicEvent
+	var tokens <-chan string
+	err := await(ctx, "Join", func() (err error) {
+		snapshot, events, tokens, err = s.stream.Join(opts...)
+		return
+	}, func() {
+		s.stream.Leave()
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return snapshot, events, tokens, nil
+}
+
+func (s *contextStreamChannel) Leave(ctx context.Context) error {
+	return await(ctx, "Leave", s.stream.Leave, nil)
+}
+
+func (s *contextStreamChannel) ChannelName() string {
+	return s.stream.ChannelName()
+}
+
+func (s *contextStreamChannel) JoinTopic(ctx context.Context, topic string, opts ...StreamOption) error {
+	return await(ctx, "JoinTopic", func() error {
+		return s.stream.JoinTopic(topic, opts...)
+	}, func() {
+		s.stream.LeaveTopic(topic)
+	})
+}
+
+func (s *contextStreamChannel) PublishTopic(ctx context.Context, topic string, message []byte, opts ...StreamOption) error {
+	return await(ctx, "PublishTopic", func() error {
+		return s.stream.PublishTopic(topic, message, opts...)
+	}, nil)
+}
+
+func (s *contextStreamChannel) LeaveTopic(ctx context.Context, topic string) error {
+	return await(ctx, "LeaveTopic", func() error {
+		return s.stream.LeaveTopic(topic)
+	}, nil)
+}
+
+func (s *contextStreamChannel) SubscribeTopic(ctx context.Context, topic string, userIds []string) (<-chan *Message, error) {
+	var messages <-chan *Message
+	// users subscribed before, nil if the topic was not subscribed
+	var prior map[string]bool
+	var priorErr error
+	err := await(ctx, "SubscribeTopic", func() (err error) {
+		users, err := s.stream.GetSubscribedUsers(topic)
+		switch {
+		case err == nil:
+			prior = make(map[string]bool, len(users))
+			for _, userId := range users {
+				prior[userId] = true
+			}
+		case !errors.Is(err, ERR_NOT_SUBSCRIBED):
+			priorErr = err
+		}
+		messages, err = s.stream.SubscribeTopic(topic, userIds)
+		return
+	}, func() {
+		// restore the users subscribed before, which are unknown if they could not be read
+		switch {
+		case priorErr != nil:
+		case prior == nil:
+			s.stream.UnsubscribeTopic(topic, nil)
+		case len(userIds) == 0:
+			// subscribing all may have added any user, so subscribe the prior users again from scratch
+			s.stream.UnsubscribeTopic(topic, nil)
+			if len(prior) > 0 {
+				s.stream.SubscribeTopic(topic, slices.Sorted(maps.Keys(prior)))
+			}
+		default:
+			var added []string
+			for _, userId := range userIds {
+				if !prior[userId] {
+					added = append(added, userId)
+				}
+			}
+			if len(added) > 0 {
+				s.stream.UnsubscribeTopic(topic, added)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (s *contextStreamChannel) UnsubscribeTopic(ctx context.Context, topic string, userIds []string) error {
+	return await(ctx, "UnsubscribeTopic", func() error {
+		return s.stream.UnsubscribeTopic(topic, userIds)
+	}, nil)
+}
+
+func (s *contextStreamChannel) GetSubscribedUsers(ctx context.Context, topic string) ([]string, error) {
+	var users []string
+	err := await(ctx, "GetSubscribedUsers", func() (err error) {
+		users, err = s.stream.GetSubscribedUsers(topic)
+		return
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *contextStreamChannel) RenewToken(ctx context.Context, token string) error {
+	return await(ctx, "RenewToken", func() error {
+		return s.stream.RenewToken(token)
+	}, nil)
+}
+
+type contextStorage struct {
+	storage Storage
+}
+
+func (s *contextStorage) GetChannelMetadataChan(ctx context.Context, channel string, channelType ChannelType) (map[string]*MetadataItem, <-chan *StorageEvent, error) {
+	var items map[string]*MetadataItem
+	var events <-chan *StorageEvent
+	err := await(ctx, "GetChannelMetadataChan", func() (err error) {
+		items, events, err = s.storage.GetChannelMetadataChan(channel, channelType)
+		return
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, events, nil
+}
+
+func (s *contextStorage) SetChannelMetadata(ctx context.Context, channel string, channelType ChannelType, data map[string]*MetadataItem, opts ...StorageOption) error {
+	return await(ctx, "SetChannelMetadata", func() error {
+		return s.storage.SetChannelMetadata(channel, channelType, data, opts...)
+	}, nil)
+}
+
+func (s *contextStorage) UpdateChannelMetadata(ctx context.Context, channel string, channelType ChannelType, data map[string]*MetadataItem, opts ...StorageOption) error {
+	return await(ctx, "UpdateChannelMetadata", func() error {
+		return s.storage.UpdateChannelMetadata(channel, channelType, data, opts...)
+	}, nil)
+}
+
+func (s *contextStorage) RemoveChannelMetadata(ctx context.Context, channel string, channelType ChannelType, data map[string]*MetadataItem, opts ...StorageOption) error {
+	return await(ctx, "RemoveChannelMetadata", func() error {
+		return s.storage.RemoveChannelMetadata(channel, channelType, data, opts...)
+	}, nil)
+}
+
+func (s *contextStorage) GetChannelMetadata(ctx context.Context, channel string, channelType ChannelType) (int64, map[string]*MetadataItem, error) {
+	var rev int64
+	var items map[string]*MetadataItem
+	err := await(ctx, "GetChannelMetadata", func() (err error) {
+		rev, items, err = s.storage.GetChannelMetadata(channel, channelType)
+		return
+	}, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	return rev, items, nil
+}
+
+func (s *contextStorage) SetUserMetadata(ctx context.Context, userId string, data map[string]*MetadataItem, opts ...StorageOption) error {
+	return await(ctx, "SetUserMetadata", func() error {
+		return s.storage.SetUserMetadata(userId, data, opts...)
+	}, nil)
+}
+
+func (s *contextStorage) UpdateUserMetadata(ctx context.Context, userId string, data map[string]*MetadataItem, opts ...StorageOption) error {
+	return await(ctx, "UpdateUserMetadata", func() error {
+		return s.storage.UpdateUserMetadata(userId, data, opts...)
+	}, nil)
+}
+
+func (s *contextStorage) RemoveUserMetadata(ctx context.Context, userId string, data map[string]*MetadataItem, opts ...StorageOption) error {
+	return await(ctx, "RemoveUserMetadata", func() error {
+		return s.storage.RemoveUserMetadata(userId, data, opts...)
+	}, nil)
+}
+
+func (s *contextStorage) GetUserMetadata(ctx context.Context, userId string) (int64, map[string]*MetadataItem, error) {
+	var rev int64
+	var items map[string]*MetadataItem
+	err := await(ctx, "GetUserMetadata", func() (err error) {
+		rev, items, err = s.storage.GetUserMetadata(userId)
+		return
+	}, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	return rev, items, nil
+}
+
+func (s *contextStorage) SubscribeUserMetadata(ctx context.Context, userId string) (map[string]*MetadataItem, <-chan *StorageEvent, error) {
+	var items map[string]*MetadataItem
+	var events <-chan *StorageEvent
+	err := await(ctx, "SubscribeUserMetadata", func() (err error) {
+		items, events, err = s.storage.SubscribeUserMetadata(userId)
+		return
+	}, func() {
+		s.storage.UnsubscribeUserMetadata(userId)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, events, nil
+}
+
+func (s *contextStorage) UnsubscribeUserMetadata(ctx context.Context, userId string) error {
+	return await(ctx, "UnsubscribeUserMetadata", func() error {
+		return s.storage.UnsubscribeUserMetadata(userId)
+	}, nil)
+}
+
+type contextLock struct {
+	lock Lock
+}
+
+func (l *contextLock) GetLockChan(ctx context.Context, channel string, channelType ChannelType) (map[string]*LockDetail, <-chan *LockEvent, error) {
+	var locks map[string]*LockDetail
+	var events <-chan *LockEvent
+	err := await(ctx, "GetLockChan", func() (err error) {
+		locks, events, err = l.lock.GetLockChan(channel, channelType)
+		return
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return locks, events, nil
+}
+
+func (l *contextLock) Set(ctx context.Context, channel string, channelType ChannelType, name string, ttl uint32) error {
+	return await(ctx, "Set", func() error {
+		return l.lock.Set(channel, channelType, name, ttl)
+	}, nil)
+}
+
+func (l *contextLock) Get(ctx context.Context, channel string, channelType ChannelType) (map[string]*LockDetail, error) {
+	var locks map[string]*LockDetail
+	err := await(ctx, "Get", func() (err error) {
+		locks, err = l.lock.Get(channel, channelType)
+		return
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return locks, nil
+}
+
+func (l *contextLock) Remove(ctx context.Context, channel string, channelType ChannelType, name string) error {
+	return await(ctx, "Remove", func() error {
+		return l.lock.Remove(channel, channelType, name)
+	}, nil)
+}
+
+func (l *contextLock) Acquire(ctx context.Context, channel string, channelType ChannelType, name string, retry bool) error {
+	if err := ctx.Err(); err != nil {
+		return &canceledError{op: "Acquire", cause: err}
+	}
+	result := l.lock.Acquire(channel, channelType, name, retry)
+	select {
+	case err, ok := <-result:
+		if !ok {
+			return &canceledError{op: "Acquire", cause: context.Canceled}
+		}
+		return err
+	case <-ctx.Done():
+		if retry {
+			// Cancel the pending acquire. Fails if the lock has just been acquired, then release it below.
+			l.lock.Release(channel, channelType, name)
+		}
+		go func() {
+			if err, ok := <-result; ok && err == nil {
+				l.lock.Release(channel, channelType, name)
+			}
+		}()
+		return &canceledError{op: "Acquire", cause: ctx.Err()}
+	}
+}
+
+func (l *contextLock) Release(ctx context.Context, channel string, channelType ChannelType, name string) error {
+	return await(ctx, "Release", func() error {
+		return l.lock.Release(channel, channelType, name)
+	}, nil)
+}
+
+func (l *contextLock) Revoke(ctx context.Context, channel string, channelType ChannelType, name string, owner string) error {
+	return await(ctx, "Revoke", func() error {
+		return l.lock.Revoke(channel, channelType, name, owner)
+	}, nil)
+}
+
+type contextPresence struct {
+	presence Presence
+}
+
+func (p *contextPresence) GetPresenceChan(ctx context.Context, channel string, channelType ChannelType) (map[string]*UserState, <-chan *PresenceEvent, error) {
+	var users map[string]*UserState
+	var events <-chan *PresenceEvent
+	err := await(ctx, "GetPresenceChan", func() (err error) {
+		users, events, err = p.presence.GetPresenceChan(channel, channelType)
+		return
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return users, events, nil
+}
+
+func (p *contextPresence) WhoNow(ctx context.Context, channel string, channelType ChannelType, opts ...PresenceOption) (map[string]*UserState, string, error) {
+	var users map[string]*UserState
+	var next string
+	err := await(ctx, "WhoNow", func() (err error) {
+		users, next, err = p.presence.WhoNow(channel, channelType, opts...)
+		return
+	}, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return users, next, nil
+}
+
+func (p *contextPresence) WhereNow(ctx context.Context, userId string) ([]*ChannelInfo, error) {
+	var channels []*ChannelInfo
+	err := await(ctx, "WhereNow", func() (err error) {
+		channels, err = p.presence.WhereNow(userId)
+		return
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+func (p *contextPresence) SetState(ctx context.Context, channel string, channelType ChannelType, data map[string]string) error {
+	return await(ctx, "SetState", func() error {
+		return p.presence.SetState(channel, channelType, data)
+	}, nil)
+}
+
+func (p *contextPresence) RemoveState(ctx context.Context, channel string, channelType ChannelType, keys []string) error {
+	return await(ctx, "RemoveState", func() error {
+		return p.presence.RemoveState(channel, channelType, keys)
+	}, nil)
+}
+
+func (p *contextPresence) GetState(ctx context.Context, channel string, channelType ChannelType, userId string) (map[string]string, error) {
+	var state map[string]string
+	err := await(ctx, "GetState", func() (err error) {
+		state, err = p.presence.GetState(channel, channelType, userId)
+		return
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
@@ -0,0 +1,94 @@
+package crdt
+
+import (
+	"context"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+// GCounter is a grow-only counter: each replica counts its own increments, and the value is their sum.
+type GCounter struct {
+	r *replica[uint64]
+}
+
+func (d *Doc) GCounter(name string) (*GCounter, error) {
+	r, err := newReplica[uint64](d, name)
+	if err != nil {
+		return nil, err
+	}
+	return &GCounter{r: r}, nil
+}
+
+func (c *GCounter) Inc(delta uint64) error {
+	return c.r.update(func(own *uint64, _ map[string]uint64) {
+		*own += delta
+	})
+}
+
+func (c *GCounter) Value() uint64 {
+	return sumG(c.r.states())
+}
+
+// Watch returns a golang chan receiving the current value, then every new value, until ctx is done.
+func (c *GCounter) Watch(ctx context.Context) <-chan uint64 {
+	return watch(ctx, c.r.d, c.r.name, func(items map[string]*rtm2.MetadataItem) uint64 {
+		return sumG(c.r.decode(items))
+	})
+}
+
+func sumG(states map[string]uint64) uint64 {
+	var sum uint64
+	for _, n := range states {
+		sum += n
+	}
+	return sum
+}
+
+type pnState struct {
+	P uint64 `json:"p"`
+	N uint64 `json:"n"`
+}
+
+// PNCounter is a counter of increments and decrements, kept as two grow-only counters.
+type PNCounter struct {
+	r *replica[pnState]
+}
+
+func (d *Doc) PNCounter(name string) (*PNCounter, error) {
+	r, err := newReplica[pnState](d, name)
+	if err != nil {
+		return nil, err
+	}
+	return &PNCounter{r: r}, nil
+}
+
+// Add increments the counter by delta, which may be negative.
+func (c *PNCounter) Add(delta int64) error {
+	return c.r.update(func(own *pnState, _ map[string]pnState) {
+		if delta >= 0 {
+			own.P += uint64(delta)
+		} else {
+			own.N += uint64(-delta)
+		}
+	})
+}
+
+func (c *PNCounter) Value() int64 {
+	return sumPN(c.r.states())
+}
+
+// Watch returns a golang chan receiving the current value, then every new value, until ctx is done.
+func (c *PNCounter) Watch(ctx context.Context) <-chan int64 {
+	return watch(ctx, c.r.d, c.r.name, func(items map[string]*rtm2.MetadataItem) int64 {
+		return sumPN(c.r.decode(items))
+	})
+}
+
+func sumPN(states map[string]pnState) int64 {
+	var p, n uint64
+	for _, s := range states {
+		p += s.P
+		n += s.N
+	}
+	return int64(p - n)
+}
@@ -0,0 +1,289 @@
+package crdt
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/memrtm"
+)
+
+const (
+	replicas = 3
+	channel  = "ch"
+)
+
+type cluster struct {
+	docs  []*Doc
+	names int
+}
+
+func newCluster(t *testing.T) *cluster {
+	t.Helper()
+	hub := memrtm.NewHub()
+	c := &cluster{}
+	for i := 0; i < replicas; i++ {
+		userId := fmt.Sprintf("u%d", i)
+		client := hub.CreateRTMClient(&rtm2.RTMConfig{Appid: "app", UserId: userId})
+		if _, _, err := client.Login(""); err != nil {
+			t.Fatalf("login %s: %v", userId, err)
+		}
+		if _, err := client.Subscribe(channel, rtm2.WithMessageMetadata(true)); err != nil {
+			t.Fatalf("subscribe %s: %v", userId, err)
+		}
+		d, err := NewDoc(client.Storage(), userId, channel, rtm2.ChannelTypeMessage)
+		if err != nil {
+			t.Fatalf("new doc %s: %v", userId, err)
+		}
+		t.Cleanup(func() {
+			d.Close()
+			client.Logout()
+		})
+		c.docs = append(c.docs, d)
+	}
+	return c
+}
+
+func (c *cluster) name(kind string) string {
+	c.names++
+	return fmt.Sprintf("%s-%d", kind, c.names)
+}
+
+// settle waits until every replica has received the items of name in the channel metadata.
+func (c *cluster) settle(t *testing.T, name string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, all, err := c.docs[0].storage.GetChannelMetadata(channel, rtm2.ChannelTypeMessage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := make(map[string]string)
+		for key, item := range all {
+			if len(key) > len(name) && key[:len(name)+1] == name+sep {
+				want[key[len(name)+1:]] = item.Value
+			}
+		}
+		settled := true
+		for _, d := range c.docs {
+			got := make(map[string]string)
+			for userId, item := range d.items(name) {
+				got[userId] = item.Value
+			}
+			if !reflect.DeepEqual(got, want) {
+				settled = false
+				break
+			}
+		}
+		if settled {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replicas of %s did not receive all items", name)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// op is decoded from a random uint16: the replica applying it, the kind of operation and its argument.
+type op struct {
+	replica, kind, arg int
+}
+
+func decodeOps(raw []uint16, kinds int) []op {
+	ops := make([]op, len(raw))
+	for i, v := range raw {
+		ops[i] = op{replica: int(v) % replicas, kind: int(v>>4) % kinds, arg: int(v>>8) % 4}
+	}
+	return ops
+}
+
+// instance is a CRDT of one replica.
+type instance struct {
+	apply func(kind, arg int) error
+	value func() any
+}
+
+// converges checks that random operations, interleaved concurrently on all replicas, leave every replica
+// with the same value, which must equal want of the operations if given.
+func converges(t *testing.T, kind string, kinds int, open func(d *Doc, name string) (instance, error), want func(ops []op) any) {
+	c := newCluster(t)
+	property := func(raw []uint16) bool {
+		ops := decodeOps(raw, kinds)
+		name := c.name(kind)
+		instances := make([]instance, replicas)
+		for i, d := range c.docs {
+			x, err := open(d, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			instances[i] = x
+		}
+		var wg sync.WaitGroup
+		for i := range instances {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for _, o := range ops {
+					if o.replica != i {
+						continue
+					}
+					if err := instances[i].apply(o.kind, o.arg); err != nil {
+						t.Errorf("replica %d: %v", i, err)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		c.settle(t, name)
+		first := instances[0].value()
+		for i, x := range instances[1:] {
+			if v := x.value(); !reflect.DeepEqual(v, first) {
+				t.Logf("ops %v: replica %d has %v, replica 0 has %v", ops, i+1, v, first)
+				return false
+			}
+		}
+		if want != nil {
+			if w := want(ops); !reflect.DeepEqual(first, w) {
+				t.Logf("ops %v: value %v, want %v", ops, first, w)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 20}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGCounterConverges(t *testing.T) {
+	converges(t, "gcounter", 1, func(d *Doc, name string) (instance, error) {
+		c, err := d.GCounter(name)
+		if err != nil {
+			return instance{}, err
+		}
+		return instance{
+			apply: func(_, arg int) error { return c.Inc(uint64(arg + 1)) },
+			value: func() any { return c.Value() },
+		}, nil
+	}, func(ops []op) any {
+		var sum uint64
+		for _, o := range ops {
+			sum += uint64(o.arg + 1)
+		}
+		return sum
+	})
+}
+
+func TestPNCounterConverges(t *testing.T) {
+	converges(t, "pncounter", 1, func(d *Doc, name string) (instance, error) {
+		c, err := d.PNCounter(name)
+		if err != nil {
+			return instance{}, err
+		}
+		return instance{
+			apply: func(_, arg int) error { return c.Add(int64(arg) - 2) },
+			value: func() any { return c.Value() },
+		}, nil
+	}, func(ops []op) any {
+		var sum int64
+		for _, o := range ops {
+			sum += int64(o.arg) - 2
+		}
+		return sum
+	})
+}
+
+func TestLWWRegisterConverges(t *testing.T) {
+	converges(t, "register", 1, func(d *Doc, name string) (instance, error) {
+		r, err := d.LWWRegister(name)
+		if err != nil {
+			return instance{}, err
+		}
+		return instance{
+			apply: func(_, arg int) error { return r.Set(fmt.Sprintf("%s-%d", d.userId, arg)) },
+			value: func() any {
+				v, ok := r.Get()
+				return fmt.Sprint(v, ok)
+			},
+		}, nil
+	}, nil)
+}
+
+func TestORSetConverges(t *testing.T) {
+	converges(t, "orset", 2, func(d *Doc, name string) (instance, error) {
+		s, err := d.ORSet(name)
+		if err != nil {
+			return instance{}, err
+		}
+		return instance{
+			apply: func(kind, arg int) error {
+				elem := fmt.Sprintf("e%d", arg)
+				if kind == 0 {
+					return s.Add(elem)
+				}
+				return s.Remove(elem)
+			},
+			value: func() any { return s.Elements() },
+		}, nil
+	}, nil)
+}
+
+func TestLWWMapConverges(t *testing.T) {
+	converges(t, "lwwmap", 2, func(d *Doc, name string) (instance, error) {
+		m, err := d.LWWMap(name)
+		if err != nil {
+			return instance{}, err
+		}
+		return instance{
+			apply: func(kind, arg int) error {
+				key := fmt.Sprintf("k%d", arg)
+				if kind == 0 {
+					return m.Set(key, d.userId)
+				}
+				return m.Delete(key)
+			},
+			value: func() any { return m.Map() },
+		}, nil
+	}, nil)
+}
+
+func TestORSetCompacts(t *testing.T) {
+	c := newCluster(t)
+	name := c.name("orset")
+	adder, err := c.docs[0].ORSet(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remover, err := c.docs[1].ORSet(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := adder.Add("x"); err != nil {
+			t.Fatal(err)
+		}
+		if err := adder.Add("x"); err != nil {
+			t.Fatal(err)
+		}
+		c.settle(t, name)
+		if err := remover.Remove("x"); err != nil {
+			t.Fatal(err)
+		}
+		c.settle(t, name)
+	}
+	if adder.Contains("x") || remover.Contains("x") {
+		t.Fatal("removed element is still contained")
+	}
+	states := adder.r.states()
+	if tags := states["u0"].Adds["x"]; len(tags) > 1 {
+		t.Errorf("adder holds %d tags of x, want at most 1", len(tags))
+	}
+	if removes := states["u1"].Removes; len(removes) > 1 {
+		t.Errorf("remover holds %d tombstones, want at most 1", len(removes))
+	}
+}
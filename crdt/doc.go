@@ -0,0 +1,229 @@
+// Package crdt provides conflict-free replicated data types stored in channel metadata.
+//
+// Each replica, identified by its user id, writes only its own item "<name>#<userId>" holding its state,
+// so concurrent writers never overwrite each other. Values are merged from the items of all replicas,
+// which follow StorageEvents through a metadata.Cache. Replicas converge once they have received the same
+// items, without locks, revisions or a coordinator.
+//
+// A user id must be used by a single Doc at a time, and the state of a replica must fit rtm2.MetadataValueMaxSize.
+package crdt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+	"github.com/tomasliu-agora/rtm2/metadata"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidName is returned for an empty name, a name containing "#", or a name too long with the user id.
+var ErrInvalidName = errors.New("crdt: invalid name")
+
+const sep = "#"
+
+type Options struct {
+	Logger *zap.Logger
+}
+
+type Option func(*Options)
+
+func WithLogger(lg *zap.Logger) Option {
+	return func(o *Options) {
+		o.Logger = lg
+	}
+}
+
+// Doc holds the CRDTs of a channel, which must be subscribed with metadata, e.g. by rtm2.WithMessageMetadata.
+// Doc shares the StorageEvent golang chan of the channel with other caches, see metadata.Cache.
+type Doc struct {
+	storage     rtm2.Storage
+	channel     string
+	channelType rtm2.ChannelType
+	userId      string
+	cache       *metadata.Cache
+	lg          *zap.Logger
+}
+
+// NewDoc opens the CRDTs of the channel as the replica of userId.
+func NewDoc(storage rtm2.Storage, userId, channel string, channelType rtm2.ChannelType, opts ...Option) (*Doc, error) {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+	lg := o.Logger.With(zap.String("channel", channel), zap.String("user", userId))
+	cache, err := metadata.NewCache(storage, channel, channelType, metadata.WithLogger(lg))
+	if err != nil {
+		return nil, err
+	}
+	return &Doc{storage: storage, channel: channel, channelType: channelType, userId: userId, cache: cache, lg: lg}, nil
+}
+
+// Close stops following StorageEvents. Items of the replica are kept in the channel metadata.
+func (d *Doc) Close() error {
+	return d.cache.Close()
+}
+
+func (d *Doc) check(name string) error {
+	if name == "" || strings.Contains(name, sep) || len(name)+len(sep)+len(d.userId) > rtm2.MetadataKeyMaxSize {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// items returns copies of the items of name by user id.
+func (d *Doc) items(name string) map[string]*rtm2.MetadataItem {
+	res := make(map[string]*rtm2.MetadataItem)
+	d.cache.Range(func(key string, item *rtm2.MetadataItem) bool {
+		if userId, ok := strings.CutPrefix(key, name+sep); ok {
+			res[userId] = item
+		}
+		return true
+	})
+	return res
+}
+
+// write sets the item of the replica, recording the update time and author.
+func (d *Doc) write(name, value string) error {
+	if len(value) > rtm2.MetadataValueMaxSize {
+		return rtm2.ERR_METADATA_VALUE_SIZE_OVERFLOW
+	}
+	key := name + sep + d.userId
+	return d.storage.SetChannelMetadata(d.channel, d.channelType, map[string]*rtm2.MetadataItem{key: {Key: key, Value: value}},
+		rtm2.WithStorageRecordTs(true), rtm2.WithStorageRecordAuthor(true))
+}
+
+// replica is the JSON encoded state S of each user, of which the own one is updated locally.
+type replica[S any] struct {
+	d    *Doc
+	name string
+
+	mu     sync.Mutex
+	loaded bool
+	own    string
+}
+
+func newReplica[S any](d *Doc, name string) (*replica[S], error) {
+	if err := d.check(name); err != nil {
+		return nil, err
+	}
+	return &replica[S]{d: d, name: name}, nil
+}
+
+func decodeState[S any](value string) (S, error) {
+	var s S
+	if value == "" {
+		return s, nil
+	}
+	err := json.Unmarshal([]byte(value), &s)
+	return s, err
+}
+
+// decode skips items which are not a valid state, e.g. written by another type of the same name.
+func (r *replica[S]) decode(items map[string]*rtm2.MetadataItem) map[string]S {
+	res := make(map[string]S, len(items))
+	for userId, item := range items {
+		s, err := decodeState[S](item.Value)
+		if err != nil {
+			r.d.lg.Warn("decode crdt state failed", zap.String("name", r.name), zap.String("replica", userId), zap.Error(err))
+			continue
+		}
+		res[userId] = s
+	}
+	return res
+}
+
+// states returns the states of all replicas, with the own state of local updates not yet received.
+func (r *replica[S]) states() map[string]S {
+	states := r.decode(r.d.items(r.name))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.loaded {
+		if s, err := decodeState[S](r.own); err == nil {
+			states[r.d.userId] = s
+		}
+	}
+	return states
+}
+
+// update applies f on a copy of the own state, given the states of all replicas, and writes it.
+// The own state is kept only if the write succeeds.
+func (r *replica[S]) update(f func(own *S, states map[string]S)) error {
+	states := r.decode(r.d.items(r.name))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.loaded {
+		if item, ok := r.d.cache.Get(r.name + sep + r.d.userId); ok {
+			if _, err := decodeState[S](item.Value); err != nil {
+				return err
+			}
+			r.own = item.Value
+		}
+		r.loaded = true
+	}
+	own, err := decodeState[S](r.own)
+	if err != nil {
+		return err
+	}
+	states[r.d.userId] = own
+	f(&own, states)
+	data, err := json.Marshal(own)
+	if err != nil {
+		return err
+	}
+	if err := r.d.write(r.name, string(data)); err != nil {
+		return err
+	}
+	r.own = string(data)
+	return nil
+}
+
+// watch sends value of the received states, then every new value, until ctx is done.
+func watch[T any](ctx context.Context, d *Doc, name string, value func(items map[string]*rtm2.MetadataItem) T) <-chan T {
+	values := make(chan T)
+	box := outbox.New()
+	var last *T
+	cancel := d.cache.OnChange(func(all map[string]*rtm2.MetadataItem) {
+		items := make(map[string]*rtm2.MetadataItem)
+		for key, item := range all {
+			if userId, ok := strings.CutPrefix(key, name+sep); ok {
+				items[userId] = item
+			}
+		}
+		v := value(items)
+		if last != nil && reflect.DeepEqual(*last, v) {
+			return
+		}
+		last = &v
+		box.Push(func(done <-chan struct{}) {
+			select {
+			case values <- v:
+			case <-done:
+			}
+		})
+	})
+	go func() {
+		<-ctx.Done()
+		cancel()
+		box.Close()
+	}()
+	return values
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+package crdt
+
+import (
+	"context"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type lwwEntry struct {
+	Value   string `json:"v,omitempty"`
+	Ts      int64  `json:"t"`
+	Deleted bool   `json:"d,omitempty"`
+}
+
+type lwwState struct {
+	Entries map[string]lwwEntry `json:"e,omitempty"`
+}
+
+// LWWMap is a map of strings whose keys are last-writer-wins registers. Each write is stamped with
+// the local time in milliseconds, raised above every stamp seen for the key, ties broken by user id.
+// Deletes are kept as tombstones.
+type LWWMap struct {
+	r *replica[lwwState]
+}
+
+func (d *Doc) LWWMap(name string) (*LWWMap, error) {
+	r, err := newReplica[lwwState](d, name)
+	if err != nil {
+		return nil, err
+	}
+	return &LWWMap{r: r}, nil
+}
+
+func (m *LWWMap) Set(key, value string) error {
+	return m.put(key, lwwEntry{Value: value})
+}
+
+func (m *LWWMap) Delete(key string) error {
+	return m.put(key, lwwEntry{Deleted: true})
+}
+
+func (m *LWWMap) put(key string, e lwwEntry) error {
+	return m.r.update(func(own *lwwState, states map[string]lwwState) {
+		e.Ts = time.Now().UnixMilli()
+		for _, st := range states {
+			if old, ok := st.Entries[key]; ok && old.Ts >= e.Ts {
+				e.Ts = old.Ts + 1
+			}
+		}
+		if own.Entries == nil {
+			own.Entries = make(map[string]lwwEntry)
+		}
+		own.Entries[key] = e
+	})
+}
+
+func (m *LWWMap) Get(key string) (string, bool) {
+	value, ok := merged(m.r.states())[key]
+	return value, ok
+}
+
+// Map returns a copy of the merged map.
+func (m *LWWMap) Map() map[string]string {
+	return merged(m.r.states())
+}
+
+// Watch returns a golang chan receiving the current map, then every new map, until ctx is done.
+func (m *LWWMap) Watch(ctx context.Context) <-chan map[string]string {
+	return watch(ctx, m.r.d, m.r.name, func(items map[string]*rtm2.MetadataItem) map[string]string {
+		return merged(m.r.decode(items))
+	})
+}
+
+func merged(states map[string]lwwState) map[string]string {
+	type winner struct {
+		lwwEntry
+		userId string
+	}
+	wins := make(map[string]winner)
+	for userId, st := range states {
+		for key, e := range st.Entries {
+			w, ok := wins[key]
+			if !ok || e.Ts > w.Ts || e.Ts == w.Ts && userId > w.userId {
+				wins[key] = winner{lwwEntry: e, userId: userId}
+			}
+		}
+	}
+	res := make(map[string]string, len(wins))
+	for key, w := range wins {
+		if !w.Deleted {
+			res[key] = w.Value
+		}
+	}
+	return res
+}
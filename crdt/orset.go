@@ -0,0 +1,173 @@
+package crdt
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type orState struct {
+	// Seq numbers the tags added by the replica.
+	Seq uint64 `json:"s,omitempty"`
+	// Adds are the live tags of elements added by the replica, at most one per element.
+	Adds map[string][]string `json:"a,omitempty"`
+	// Removes are the tags of other replicas observed on Remove, until their owners drop them.
+	Removes []string `json:"r,omitempty"`
+}
+
+// ORSet is an observed-remove set of strings: Add tags the element uniquely, and Remove removes only the tags
+// it has observed, so an Add concurrent with a Remove wins. Removed tags of other replicas are kept as tombstones.
+//
+// States are compacted on every update: a replica keeps only its latest tag of an element, drops its own tags
+// tombstoned by others, and drops its tombstones of tags which their owners no longer hold. A tag is never
+// reused, so a tag missing from a state numbered past it is gone for good.
+type ORSet struct {
+	r *replica[orState]
+}
+
+func (d *Doc) ORSet(name string) (*ORSet, error) {
+	r, err := newReplica[orState](d, name)
+	if err != nil {
+		return nil, err
+	}
+	return &ORSet{r: r}, nil
+}
+
+func (s *ORSet) Add(elem string) error {
+	return s.r.update(func(own *orState, states map[string]orState) {
+		s.compact(own, states)
+		own.Seq++
+		if own.Adds == nil {
+			own.Adds = make(map[string][]string)
+		}
+		// the new tag supersedes the older ones, which a concurrent Remove may have observed
+		own.Adds[elem] = []string{strconv.FormatUint(own.Seq, 10) + sep + s.r.d.userId}
+	})
+}
+
+// Remove removes the element as observed by the replica.
+func (s *ORSet) Remove(elem string) error {
+	return s.r.update(func(own *orState, states map[string]orState) {
+		s.compact(own, states)
+		removed := tombstones(states)
+		for userId, st := range states {
+			if userId == s.r.d.userId {
+				continue
+			}
+			for _, tag := range st.Adds[elem] {
+				if !removed[tag] {
+					own.Removes = append(own.Removes, tag)
+					removed[tag] = true
+				}
+			}
+		}
+		// own tags leave with the next state, no tombstone needed
+		delete(own.Adds, elem)
+	})
+}
+
+// compact drops the own tags tombstoned by other replicas, and the own tombstones no longer needed.
+func (s *ORSet) compact(own *orState, states map[string]orState) {
+	removed := make(map[string]bool)
+	for userId, st := range states {
+		if userId == s.r.d.userId {
+			continue
+		}
+		for _, tag := range st.Removes {
+			removed[tag] = true
+		}
+	}
+	for elem, tags := range own.Adds {
+		live := tags[:0:0]
+		for _, tag := range tags {
+			if !removed[tag] {
+				live = append(live, tag)
+			}
+		}
+		if len(live) == 0 {
+			delete(own.Adds, elem)
+		} else {
+			own.Adds[elem] = live
+		}
+	}
+	kept := own.Removes[:0:0]
+	for _, tag := range own.Removes {
+		if held(tag, states) {
+			kept = append(kept, tag)
+		}
+	}
+	own.Removes = kept
+}
+
+// held reports whether the owner of tag may still hold it in its state.
+func held(tag string, states map[string]orState) bool {
+	seqStr, owner, ok := strings.Cut(tag, sep)
+	if !ok {
+		return false
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		return false
+	}
+	st, ok := states[owner]
+	if !ok {
+		return false
+	}
+	if st.Seq < seq {
+		// the add is not received yet
+		return true
+	}
+	for _, tags := range st.Adds {
+		for _, t := range tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *ORSet) Contains(elem string) bool {
+	_, ok := elements(s.r.states())[elem]
+	return ok
+}
+
+// Elements returns the elements in order.
+func (s *ORSet) Elements() []string {
+	return sortedKeys(elements(s.r.states()))
+}
+
+// Watch returns a golang chan receiving the current elements, then every new elements, until ctx is done.
+func (s *ORSet) Watch(ctx context.Context) <-chan []string {
+	return watch(ctx, s.r.d, s.r.name, func(items map[string]*rtm2.MetadataItem) []string {
+		return sortedKeys(elements(s.r.decode(items)))
+	})
+}
+
+func tombstones(states map[string]orState) map[string]bool {
+	res := make(map[string]bool)
+	for _, st := range states {
+		for _, tag := range st.Removes {
+			res[tag] = true
+		}
+	}
+	return res
+}
+
+func elements(states map[string]orState) map[string]struct{} {
+	removed := tombstones(states)
+	res := make(map[string]struct{})
+	for _, st := range states {
+		for elem, tags := range st.Adds {
+			for _, tag := range tags {
+				if !removed[tag] {
+					res[elem] = struct{}{}
+					break
+				}
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+package crdt
+
+import (
+	"context"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+// LWWRegister is a last-writer-wins register. Each replica writes its value into its own item,
+// recorded with UpdateTs and Author by the rtm service, and the value of the latest item wins,
+// ties broken by Author. Set is visible to Get once its StorageEvent is received.
+type LWWRegister struct {
+	d    *Doc
+	name string
+}
+
+func (d *Doc) LWWRegister(name string) (*LWWRegister, error) {
+	if err := d.check(name); err != nil {
+		return nil, err
+	}
+	return &LWWRegister{d: d, name: name}, nil
+}
+
+func (r *LWWRegister) Set(value string) error {
+	return r.d.write(r.name, value)
+}
+
+// Get returns the latest value, and false if no replica has set one.
+func (r *LWWRegister) Get() (string, bool) {
+	item := latest(r.d.items(r.name))
+	if item == nil {
+		return "", false
+	}
+	return item.Value, true
+}
+
+// Watch returns a golang chan receiving the current value, then every new value, until ctx is done.
+func (r *LWWRegister) Watch(ctx context.Context) <-chan string {
+	return watch(ctx, r.d, r.name, func(items map[string]*rtm2.MetadataItem) string {
+		if item := latest(items); item != nil {
+			return item.Value
+		}
+		return ""
+	})
+}
+
+func latest(items map[string]*rtm2.MetadataItem) *rtm2.MetadataItem {
+	var res *rtm2.MetadataItem
+	// in the order of user ids, so that full ties resolve the same on every replica
+	for _, userId := range sortedKeys(items) {
+		item := items[userId]
+		if res == nil || item.UpdateTs > res.UpdateTs || item.UpdateTs == res.UpdateTs && item.Author >= res.Author {
+			res = item
+		}
+	}
+	return res
+}
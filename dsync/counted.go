@@ -0,0 +1,273 @@
+package dsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+// errBusy is returned by a non-blocking acquisition.
+var errBusy = errors.New("dsync: busy")
+
+// entry is a holder recorded in channel metadata.
+type entry struct {
+	UserId    string `json:"u"`
+	Exclusive bool   `json:"x,omitempty"`
+}
+
+type holding int
+
+const (
+	holdingNone holding = iota
+	holdingShared
+	holdingExclusive
+)
+
+// counted admits holders up to a limit, the core of Semaphore and RWMutex:
+//   - Acquisitions queue on the gate lock, handed over in FIFO order by Acquire with retry.
+//     Only the head, holding the gate, checks for admission, woken by events of member locks.
+//   - Holders are recorded in channel metadata under key, updated with WithStorageMajorRev CAS.
+//   - Every holder holds its member lock with lease renewal. Holders whose member lock is released or expired,
+//     e.g. crashed, are pruned from the record by the next acquisition.
+type counted struct {
+	client  rtm2.RTMClient
+	userId  string
+	channel string
+	name    string
+	key     string
+	opts    *MutexOptions
+	lg      *zap.Logger
+	admit   func(holders []entry, exclusive bool) bool
+	gate    *DistributedMutex
+	member  *DistributedMutex
+
+	local chan struct{} // held by the goroutine owning a permit
+
+	mu   sync.Mutex
+	mode holding
+}
+
+func newCounted(client rtm2.RTMClient, userId, channel, name, key string, admit func([]entry, bool) bool, opts []MutexOption) *counted {
+	o := newMutexOptions(opts)
+	o.Fencing = false
+	sub := []MutexOption{WithChannelType(o.ChannelType), WithTTL(o.TTL), WithRenewInterval(o.RenewInterval), WithLogger(o.Logger)}
+	return &counted{
+		client:  client,
+		userId:  userId,
+		channel: channel,
+		name:    name,
+		key:     key,
+		opts:    o,
+		lg:      o.Logger.With(zap.String("channel", channel), zap.String("lock", name)),
+		admit:   admit,
+		gate:    NewMutex(client, userId, channel, name+".gate", sub...),
+		member:  NewMutex(client, userId, channel, memberLock(name, userId), sub...),
+		local:   make(chan struct{}, 1),
+	}
+}
+
+func memberLock(name, userId string) string {
+	return name + ".holder." + userId
+}
+
+func (c *counted) acquire(ctx context.Context, exclusive, wait bool) error {
+	if wait {
+		select {
+		case c.local <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else {
+		select {
+		case c.local <- struct{}{}:
+		default:
+			return errBusy
+		}
+	}
+	if err := c.enter(ctx, exclusive, wait); err != nil {
+		<-c.local
+		return err
+	}
+	c.mu.Lock()
+	c.mode = holdingShared
+	if exclusive {
+		c.mode = holdingExclusive
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *counted) enter(ctx context.Context, exclusive, wait bool) error {
+	// holders leave the record before releasing their member locks, so a Released, Expired or Remove event
+	// of a member lock, or a Snapshot after reconnection, is enough to wake the head
+	changed := make(chan struct{}, 1)
+	prefix := memberLock(c.name, "")
+	cancel, err := Watch(c.client, c.channel, c.opts.ChannelType, func(e *rtm2.LockEvent) {
+		if e.Type == rtm2.LockTypeAcquired || e.Type == rtm2.LockTypeSet {
+			return
+		}
+		wake := e.Type == rtm2.LockTypeSnapshot
+		for _, d := range e.Details {
+			wake = wake || strings.HasPrefix(d.Name, prefix)
+		}
+		if wake {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if err := c.lock(ctx, c.gate, wait); err != nil {
+		return err
+	}
+	defer func() {
+		if err := c.gate.Unlock(); err != nil {
+			c.lg.Warn("release gate failed", zap.Error(err))
+		}
+	}()
+	if err := c.lock(ctx, c.member, wait); err != nil {
+		return err
+	}
+	for {
+		ok, err := c.join(exclusive)
+		if err == nil && !ok && !wait {
+			err = errBusy
+		}
+		if err != nil {
+			c.unlockMember()
+			return err
+		}
+		if ok {
+			return nil
+		}
+		// TTL only covers events missed by a dropped connection, admission does not depend on it
+		select {
+		case <-changed:
+		case <-time.After(time.Duration(c.opts.TTL) * time.Second):
+		case <-ctx.Done():
+			c.unlockMember()
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *counted) lock(ctx context.Context, m *DistributedMutex, wait bool) error {
+	if wait {
+		return m.Lock(ctx)
+	}
+	if !m.TryLock() {
+		return errBusy
+	}
+	return nil
+}
+
+func (c *counted) unlockMember() {
+	if err := c.member.Unlock(); err != nil {
+		c.lg.Warn("release member lock failed", zap.Error(err))
+	}
+}
+
+// join records this user as a holder if admitted.
+func (c *counted) join(exclusive bool) (bool, error) {
+	var err error
+	for i := 0; i < casRetries; i++ {
+		var rev int64
+		var holders []entry
+		if rev, holders, err = c.holders(); err != nil {
+			return false, err
+		}
+		var locks map[string]*rtm2.LockDetail
+		if locks, err = c.client.Lock().Get(c.channel, c.opts.ChannelType); err != nil {
+			return false, err
+		}
+		alive := holders[:0]
+		for _, h := range holders {
+			if l, ok := locks[memberLock(c.name, h.UserId)]; ok && l.Owner == h.UserId && h.UserId != c.userId {
+				alive = append(alive, h)
+			}
+		}
+		if !c.admit(alive, exclusive) {
+			return false, nil
+		}
+		if err = c.store(rev, append(alive, entry{UserId: c.userId, Exclusive: exclusive})); err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, rtm2.ERR_METADATA_INVALID_REVISION) {
+			return false, err
+		}
+	}
+	return false, err
+}
+
+func (c *counted) release(exclusive bool) error {
+	c.mu.Lock()
+	if c.mode == holdingNone || (c.mode == holdingExclusive) != exclusive {
+		c.mu.Unlock()
+		return ErrNotLocked
+	}
+	c.mode = holdingNone
+	c.mu.Unlock()
+	defer func() { <-c.local }()
+	err := c.leave()
+	c.unlockMember()
+	return err
+}
+
+// leave removes this user from the record. The record is pruned by others anyway once the member lock is released.
+func (c *counted) leave() error {
+	var err error
+	for i := 0; i < casRetries; i++ {
+		var rev int64
+		var holders []entry
+		if rev, holders, err = c.holders(); err != nil {
+			return err
+		}
+		rest := holders[:0]
+		for _, h := range holders {
+			if h.UserId != c.userId {
+				rest = append(rest, h)
+			}
+		}
+		if err = c.store(rev, rest); err == nil || !errors.Is(err, rtm2.ERR_METADATA_INVALID_REVISION) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *counted) holders() (int64, []entry, error) {
+	rev, items, err := c.client.Storage().GetChannelMetadata(c.channel, c.opts.ChannelType)
+	if err != nil {
+		return 0, nil, err
+	}
+	var holders []entry
+	if item, ok := items[c.key]; ok && item.Value != "" {
+		if err := json.Unmarshal([]byte(item.Value), &holders); err != nil {
+			return 0, nil, err
+		}
+	}
+	return rev, holders, nil
+}
+
+func (c *counted) store(rev int64, holders []entry) error {
+	if holders == nil {
+		holders = []entry{}
+	}
+	value, err := json.Marshal(holders)
+	if err != nil {
+		return err
+	}
+	data := map[string]*rtm2.MetadataItem{c.key: {Key: c.key, Value: string(value)}}
+	return c.client.Storage().SetChannelMetadata(c.channel, c.opts.ChannelType, data, rtm2.WithStorageMajorRev(rev))
+}
@@ -0,0 +1,161 @@
+package dsync
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+// ErrStaleToken is returned if a fencing token is lower than the latest one.
+var ErrStaleToken = errors.New("dsync: stale fencing token")
+
+// casRetries bounds CAS attempts on channel metadata, each failing only if it is written meanwhile.
+const casRetries = 10
+
+// FenceKey is the channel metadata key storing the latest fencing token of lock.
+func FenceKey(lock string) string {
+	return "fence." + lock
+}
+
+// CurrentToken returns the latest fencing token of lock, 0 if none is issued.
+func CurrentToken(storage rtm2.Storage, channel string, channelType rtm2.ChannelType, lock string) (uint64, error) {
+	_, items, err := storage.GetChannelMetadata(channel, channelType)
+	if err != nil {
+		return 0, err
+	}
+	return parseToken(items[FenceKey(lock)])
+}
+
+func parseToken(item *rtm2.MetadataItem) (uint64, error) {
+	if item == nil || item.Value == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(item.Value, 10, 64)
+}
+
+// nextToken increases the fencing token of lock, which must be held by the caller.
+// The write is guarded by both the lock and the major revision read.
+func nextToken(storage rtm2.Storage, channel string, channelType rtm2.ChannelType, lock string) (uint64, error) {
+	key := FenceKey(lock)
+	var err error
+	for i := 0; i < casRetries; i++ {
+		var rev int64
+		var items map[string]*rtm2.MetadataItem
+		var token uint64
+		if rev, items, err = storage.GetChannelMetadata(channel, channelType); err != nil {
+			return 0, err
+		}
+		if token, err = parseToken(items[key]); err != nil {
+			return 0, err
+		}
+		token++
+		data := map[string]*rtm2.MetadataItem{key: {Key: key, Value: strconv.FormatUint(token, 10)}}
+		err = storage.SetChannelMetadata(channel, channelType, data, rtm2.WithStorageMajorRev(rev), rtm2.WithStorageLock(lock))
+		if err == nil {
+			return token, nil
+		}
+		if !errors.Is(err, rtm2.ERR_METADATA_INVALID_REVISION) {
+			return 0, err
+		}
+	}
+	return 0, err
+}
+
+// FencedStorage is a Storage whose channel metadata writes on Channel carry Token.
+// A write is rejected with ErrStaleToken once a newer token of Lock is issued, e.g. after this client was paused
+// and the lock was acquired by others. Otherwise, it is made with WithStorageLock and
+// WithStorageMajorRev of the revision the token is checked against, so no newer token is issued in between.
+// Writes with WithStorageMajorRev set by the caller are not retried on revision conflicts.
+type FencedStorage struct {
+	rtm2.Storage
+	Channel     string
+	ChannelType rtm2.ChannelType
+	Lock        string
+	Token       uint64
+}
+
+func NewFencedStorage(storage rtm2.Storage, channel string, channelType rtm2.ChannelType, lock string, token uint64) *FencedStorage {
+	return &FencedStorage{Storage: storage, Channel: channel, ChannelType: channelType, Lock: lock, Token: token}
+}
+
+func (s *FencedStorage) SetChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	return s.write(channel, channelType, opts, func(opts []rtm2.StorageOption) error {
+		return s.Storage.SetChannelMetadata(channel, channelType, data, opts...)
+	})
+}
+
+func (s *FencedStorage) UpdateChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	return s.write(channel, channelType, opts, func(opts []rtm2.StorageOption) error {
+		return s.Storage.UpdateChannelMetadata(channel, channelType, data, opts...)
+	})
+}
+
+func (s *FencedStorage) RemoveChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	return s.write(channel, channelType, opts, func(opts []rtm2.StorageOption) error {
+		return s.Storage.RemoveChannelMetadata(channel, channelType, data, opts...)
+	})
+}
+
+func (s *FencedStorage) write(channel string, channelType rtm2.ChannelType, opts []rtm2.StorageOption, do func([]rtm2.StorageOption) error) error {
+	if channel != s.Channel || channelType != s.ChannelType {
+		return do(opts)
+	}
+	o := &rtm2.StorageOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	var err error
+	for i := 0; i < casRetries; i++ {
+		var rev int64
+		var items map[string]*rtm2.MetadataItem
+		var latest uint64
+		if rev, items, err = s.Storage.GetChannelMetadata(channel, channelType); err != nil {
+			return err
+		}
+		if latest, err = parseToken(items[FenceKey(s.Lock)]); err != nil {
+			return err
+		}
+		if s.Token < latest {
+			return ErrStaleToken
+		}
+		if o.MajorRev > 0 {
+			return do(append(opts[:len(opts):len(opts)], rtm2.WithStorageLock(s.Lock)))
+		}
+		err = do(append(opts[:len(opts):len(opts)], rtm2.WithStorageLock(s.Lock), rtm2.WithStorageMajorRev(rev)))
+		if !errors.Is(err, rtm2.ERR_METADATA_INVALID_REVISION) {
+			return err
+		}
+	}
+	return err
+}
+
+// Validator rejects fencing tokens lower than the highest one seen, for services guarded by a lock.
+// Clients send their token along with every request, and the service validates it before taking effect.
+type Validator struct {
+	mu     sync.Mutex
+	latest map[string]uint64
+}
+
+func NewValidator() *Validator {
+	return &Validator{latest: make(map[string]uint64)}
+}
+
+// Validate returns ErrStaleToken if token is lower than the highest token validated for resource.
+func (v *Validator) Validate(resource string, token uint64) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if token < v.latest[resource] {
+		return ErrStaleToken
+	}
+	v.latest[resource] = token
+	return nil
+}
+
+// Latest returns the highest token validated for resource.
+func (v *Validator) Latest(resource string) uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.latest[resource]
+}
@@ -0,0 +1,389 @@
+// Package dsync provides distributed synchronization primitives on top of rtm2.Lock and rtm2.Storage.
+//
+// Lock must be subscribed on the channel before using the primitives, e.g. by
+// client.Subscribe(channel, rtm2.WithMessageLock(true)). The primitives share the LockEvent chan of the channel,
+// so do not read it elsewhere, use Watch instead, and call Unwatch once Lock of the channel is unsubscribed.
+package dsync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNotLocked = errors.New("dsync: not locked")
+	// ErrAcquireCanceled is returned by Lock if the pending Acquire is canceled by Release from elsewhere.
+	ErrAcquireCanceled = errors.New("dsync: acquire canceled")
+)
+
+type MutexOptions struct {
+	ChannelType rtm2.ChannelType
+	// TTL in seconds set on the lock, the lock expires TTL after the owner is disconnected. 10 by default.
+	TTL uint32
+	// RenewInterval between lease renewals, TTL / 3 by default.
+	RenewInterval time.Duration
+	// Fencing issues a fencing token on every acquisition, see Token.
+	Fencing bool
+	Logger  *zap.Logger
+}
+
+type MutexOption func(*MutexOptions)
+
+// WithChannelType is ChannelTypeMessage by default.
+func WithChannelType(t rtm2.ChannelType) MutexOption {
+	return func(o *MutexOptions) {
+		o.ChannelType = t
+	}
+}
+
+func WithTTL(ttl uint32) MutexOption {
+	return func(o *MutexOptions) {
+		o.TTL = ttl
+	}
+}
+
+func WithRenewInterval(d time.Duration) MutexOption {
+	return func(o *MutexOptions) {
+		o.RenewInterval = d
+	}
+}
+
+// WithFencing issues a monotonically increasing fencing token on every acquisition,
+// stored in channel metadata under FenceKey(name).
+func WithFencing(enabled bool) MutexOption {
+	return func(o *MutexOptions) {
+		o.Fencing = enabled
+	}
+}
+
+func WithLogger(lg *zap.Logger) MutexOption {
+	return func(o *MutexOptions) {
+		o.Logger = lg
+	}
+}
+
+func newMutexOptions(opts []MutexOption) *MutexOptions {
+	o := &MutexOptions{ChannelType: rtm2.ChannelTypeMessage, TTL: 10}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.RenewInterval <= 0 {
+		o.RenewInterval = time.Duration(o.TTL) * time.Second / 3
+	}
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+	return o
+}
+
+// hold is a single acquisition of the lock.
+// Events are watched before Acquire, and only count once the LockTypeAcquired event of this user is seen,
+// so that the Released event of a previous acquisition is not taken as a loss.
+type hold struct {
+	lost      chan struct{}
+	done      chan struct{}
+	lostOnce  sync.Once
+	confirmed bool // guarded by watcher.mu
+	cancel    func()
+	token     uint64
+}
+
+func (h *hold) lose() {
+	h.lostOnce.Do(func() {
+		close(h.lost)
+	})
+}
+
+// DistributedMutex is a mutual exclusion lock between users, on a named rtm2 Lock.
+// Goroutines of the same user are excluded locally as well.
+type DistributedMutex struct {
+	client  rtm2.RTMClient
+	userId  string
+	channel string
+	name    string
+	opts    *MutexOptions
+	lg      *zap.Logger
+
+	local chan struct{} // held by the goroutine owning the lock
+
+	mu      sync.Mutex
+	created bool
+	held    *hold
+	lost    chan struct{}
+}
+
+// NewMutex creates a mutex on lock name of channel. userId must be the user logged in by client.
+// The lock is created by Set with TTL on first use.
+func NewMutex(client rtm2.RTMClient, userId string, channel string, name string, opts ...MutexOption) *DistributedMutex {
+	o := newMutexOptions(opts)
+	return &DistributedMutex{
+		client:  client,
+		userId:  userId,
+		channel: channel,
+		name:    name,
+		opts:    o,
+		lg:      o.Logger.With(zap.String("channel", channel), zap.String("lock", name)),
+		local:   make(chan struct{}, 1),
+	}
+}
+
+func (m *DistributedMutex) ensure() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.created {
+		return nil
+	}
+	if err := m.client.Lock().Set(m.channel, m.opts.ChannelType, m.name, m.opts.TTL); err != nil {
+		return err
+	}
+	m.created = true
+	return nil
+}
+
+// Lock blocks until the lock is acquired or ctx is done.
+func (m *DistributedMutex) Lock(ctx context.Context) error {
+	select {
+	case m.local <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := m.lock(ctx); err != nil {
+		<-m.local
+		return err
+	}
+	return nil
+}
+
+func (m *DistributedMutex) lock(ctx context.Context) error {
+	if err := m.ensure(); err != nil {
+		return err
+	}
+	h := m.watch()
+	lock := m.client.Lock()
+	result := lock.Acquire(m.channel, m.opts.ChannelType, m.name, true)
+	select {
+	case err, ok := <-result:
+		if !ok {
+			err = ErrAcquireCanceled
+		}
+		if err != nil {
+			h.cancel()
+			return err
+		}
+	case <-ctx.Done():
+		h.cancel()
+		// cancels the pending Acquire, or releases the lock acquired meanwhile
+		if err := lock.Release(m.channel, m.opts.ChannelType, m.name); err != nil {
+			m.lg.Warn("release canceled acquire failed", zap.Error(err))
+		}
+		return ctx.Err()
+	}
+	return m.acquired(h)
+}
+
+// TryLock acquires the lock without waiting. Returns false if it is held by others or on error.
+func (m *DistributedMutex) TryLock() bool {
+	select {
+	case m.local <- struct{}{}:
+	default:
+		return false
+	}
+	if err := m.tryLock(); err != nil {
+		m.lg.Debug("try lock failed", zap.Error(err))
+		<-m.local
+		return false
+	}
+	return true
+}
+
+func (m *DistributedMutex) tryLock() error {
+	if err := m.ensure(); err != nil {
+		return err
+	}
+	h := m.watch()
+	if err := <-m.client.Lock().Acquire(m.channel, m.opts.ChannelType, m.name, false); err != nil {
+		h.cancel()
+		return err
+	}
+	return m.acquired(h)
+}
+
+// watch creates a hold watching the lock events.
+func (m *DistributedMutex) watch() *hold {
+	h := &hold{lost: make(chan struct{}), done: make(chan struct{})}
+	cancel, err := Watch(m.client, m.channel, m.opts.ChannelType, func(e *rtm2.LockEvent) {
+		m.onEvent(h, e)
+	})
+	if err != nil {
+		m.lg.Warn("watch lock events failed, loss is detected by renewal only", zap.Error(err))
+		cancel = func() {}
+	}
+	h.cancel = cancel
+	return h
+}
+
+// acquired issues the fencing token and starts renewing the lock. The lock is released if fencing fails.
+func (m *DistributedMutex) acquired(h *hold) error {
+	if m.opts.Fencing {
+		token, err := nextToken(m.client.Storage(), m.channel, m.opts.ChannelType, m.name)
+		if err != nil {
+			h.cancel()
+			if err := m.client.Lock().Release(m.channel, m.opts.ChannelType, m.name); err != nil {
+				m.lg.Warn("release lock failed", zap.Error(err))
+			}
+			return err
+		}
+		h.token = token
+	}
+	m.mu.Lock()
+	m.held = h
+	m.lost = h.lost
+	m.mu.Unlock()
+	go m.keepAlive(h)
+	return nil
+}
+
+func (m *DistributedMutex) onEvent(h *hold, e *rtm2.LockEvent) {
+	select {
+	case <-h.done:
+		// unlocked, the Released event of this user is not a loss
+		return
+	default:
+	}
+	found := false
+	for _, d := range e.Details {
+		if d.Name != m.name {
+			continue
+		}
+		found = true
+		switch e.Type {
+		case rtm2.LockTypeAcquired:
+			if d.Owner == m.userId {
+				h.confirmed = true
+			} else if h.confirmed {
+				h.lose()
+			}
+		case rtm2.LockTypeReleased, rtm2.LockTypeExpired:
+			if h.confirmed && d.Owner == m.userId {
+				h.lose()
+			}
+		case rtm2.LockTypeRemove:
+			if h.confirmed {
+				h.lose()
+			}
+		case rtm2.LockTypeSnapshot:
+			// the state after reconnection
+			if d.Owner == m.userId {
+				h.confirmed = true
+			} else if h.confirmed {
+				h.lose()
+			}
+		}
+	}
+	if e.Type == rtm2.LockTypeSnapshot && !found && h.confirmed {
+		h.lose()
+	}
+}
+
+// keepAlive renews the lease by acquiring the held lock again, which fails once the lock is taken by others.
+// Other failures, e.g. while reconnecting, are tolerated until TTL elapses, after which the lock expires.
+func (m *DistributedMutex) keepAlive(h *hold) {
+	defer h.cancel()
+	ticker := time.NewTicker(m.opts.RenewInterval)
+	defer ticker.Stop()
+	var failing time.Time
+	for {
+		select {
+		case <-ticker.C:
+			err := <-m.client.Lock().Acquire(m.channel, m.opts.ChannelType, m.name, false)
+			if err == nil {
+				failing = time.Time{}
+				continue
+			}
+			if failing.IsZero() {
+				failing = time.Now()
+			}
+			m.lg.Warn("renew lock failed", zap.Error(err))
+			if errors.Is(err, rtm2.ERR_LOCK_OPERATION_PERFORMING) || time.Since(failing) >= time.Duration(m.opts.TTL)*time.Second {
+				h.lose()
+				return
+			}
+		case <-h.lost:
+			return
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// Unlock releases the lock. Returns ErrNotLocked if not locked by this mutex.
+// The error of Release is returned after the mutex is unlocked locally, e.g. ERR_RELEASE_LOCK_NOT_ACQUIRED once lost.
+func (m *DistributedMutex) Unlock() error {
+	m.mu.Lock()
+	h := m.held
+	m.held = nil
+	m.mu.Unlock()
+	if h == nil {
+		return ErrNotLocked
+	}
+	close(h.done)
+	err := m.client.Lock().Release(m.channel, m.opts.ChannelType, m.name)
+	<-m.local
+	return err
+}
+
+// Lost returns a golang chan closed once the current acquisition is lost,
+// by LockTypeReleased / LockTypeExpired / LockTypeRemove events or a failed renewal.
+// It is nil before the first acquisition, and not closed by Unlock.
+func (m *DistributedMutex) Lost() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lost
+}
+
+// Token returns the fencing token of the current acquisition, 0 if not locked or without WithFencing.
+func (m *DistributedMutex) Token() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.held == nil {
+		return 0
+	}
+	return m.held.token
+}
+
+// FencedStorage returns a Storage whose metadata writes on the channel are rejected once the current token is outdated.
+func (m *DistributedMutex) FencedStorage() *FencedStorage {
+	return NewFencedStorage(m.client.Storage(), m.channel, m.opts.ChannelType, m.name, m.Token())
+}
+
+// Locker returns a sync.Locker of the mutex.
+// Lock blocks until acquired, retrying every RenewInterval on errors. Errors of Unlock are logged.
+func (m *DistributedMutex) Locker() sync.Locker {
+	return locker{m}
+}
+
+type locker struct {
+	m *DistributedMutex
+}
+
+func (l locker) Lock() {
+	for {
+		err := l.m.Lock(context.Background())
+		if err == nil {
+			return
+		}
+		l.m.lg.Warn("lock failed, retrying", zap.Error(err))
+		time.Sleep(l.m.opts.RenewInterval)
+	}
+}
+
+func (l locker) Unlock() {
+	if err := l.m.Unlock(); err != nil {
+		l.m.lg.Warn("unlock failed", zap.Error(err))
+	}
+}
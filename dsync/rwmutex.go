@@ -0,0 +1,55 @@
+package dsync
+
+import (
+	"context"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+// RWMutex is a reader/writer lock between users. Readers and writers are admitted in FIFO order,
+// so a waiting writer is not starved by readers arriving later. A crashed holder is removed once its lock expires after TTL.
+// Each RWMutex holds the lock once, goroutines of the same RWMutex wait for each other.
+type RWMutex struct {
+	c *counted
+}
+
+// NewRWMutex creates a reader/writer lock on locks prefixed by name in channel.
+// userId must be the user logged in by client. Holders are recorded in channel metadata under "rw.<name>".
+func NewRWMutex(client rtm2.RTMClient, userId string, channel string, name string, opts ...MutexOption) *RWMutex {
+	return &RWMutex{c: newCounted(client, userId, channel, name, "rw."+name, func(holders []entry, exclusive bool) bool {
+		if exclusive {
+			return len(holders) == 0
+		}
+		for _, h := range holders {
+			if h.Exclusive {
+				return false
+			}
+		}
+		return true
+	}, opts)}
+}
+
+// Lock blocks until locked for writing or ctx is done.
+func (rw *RWMutex) Lock(ctx context.Context) error {
+	return rw.c.acquire(ctx, true, true)
+}
+
+// Unlock releases the write lock. Returns ErrNotLocked if not locked for writing.
+func (rw *RWMutex) Unlock() error {
+	return rw.c.release(true)
+}
+
+// RLock blocks until locked for reading or ctx is done.
+func (rw *RWMutex) RLock(ctx context.Context) error {
+	return rw.c.acquire(ctx, false, true)
+}
+
+// RUnlock releases the read lock. Returns ErrNotLocked if not locked for reading.
+func (rw *RWMutex) RUnlock() error {
+	return rw.c.release(false)
+}
+
+// Lost returns a golang chan closed once the lock held is lost, see DistributedMutex.Lost.
+func (rw *RWMutex) Lost() <-chan struct{} {
+	return rw.c.member.Lost()
+}
@@ -0,0 +1,45 @@
+package dsync
+
+import (
+	"context"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+// Semaphore limits the count of users holding a permit at the same time, e.g. concurrent renders of a room.
+// Waiters are admitted in FIFO order. A permit of a crashed user is reclaimed once its lock expires after TTL.
+// Each Semaphore holds at most one permit, goroutines of the same Semaphore wait for each other.
+type Semaphore struct {
+	c       *counted
+	permits int
+}
+
+// NewSemaphore creates a semaphore of permits on locks prefixed by name in channel.
+// userId must be the user logged in by client. Holders are recorded in channel metadata under "sem.<name>".
+func NewSemaphore(client rtm2.RTMClient, userId string, channel string, name string, permits int, opts ...MutexOption) *Semaphore {
+	s := &Semaphore{permits: permits}
+	s.c = newCounted(client, userId, channel, name, "sem."+name, func(holders []entry, _ bool) bool {
+		return len(holders) < s.permits
+	}, opts)
+	return s
+}
+
+// Acquire blocks until a permit is acquired or ctx is done.
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	return s.c.acquire(ctx, false, true)
+}
+
+// TryAcquire acquires a permit without waiting. Returns false if none is available or on error.
+func (s *Semaphore) TryAcquire() bool {
+	return s.c.acquire(context.Background(), false, false) == nil
+}
+
+// Release returns the permit. Returns ErrNotLocked if no permit is held.
+func (s *Semaphore) Release() error {
+	return s.c.release(false)
+}
+
+// Lost returns a golang chan closed once the permit held is lost, see DistributedMutex.Lost.
+func (s *Semaphore) Lost() <-chan struct{} {
+	return s.c.member.Lost()
+}
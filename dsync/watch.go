@@ -0,0 +1,105 @@
+package dsync
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type watchKey struct {
+	client      rtm2.RTMClient
+	channel     string
+	channelType rtm2.ChannelType
+}
+
+// watcher reads the LockEvent chan of a channel, and fans events out to all primitives on that channel.
+// It keeps draining the chan without handlers, since the rtm sdk blocks on an unread chan, until Unwatch.
+type watcher struct {
+	mu       sync.Mutex
+	handlers map[int]func(*rtm2.LockEvent)
+	next     int
+	stop     chan struct{}
+}
+
+var watchers = struct {
+	sync.Mutex
+	m map[watchKey]*watcher
+}{m: make(map[watchKey]*watcher)}
+
+// Watch calls h on every LockEvent of the channel until cancel is called, sharing the LockEvent chan with
+// the primitives of this package. h must not block, but may call Watch or cancel, after which it may still
+// receive the event being dispatched. Handlers run in the order of Watch. Lock must be subscribed on the channel,
+// see rtm2.WithMessageLock and rtm2.WithStreamLock.
+// The chan is drained from the first Watch until Unwatch, whether handlers are registered or not.
+func Watch(client rtm2.RTMClient, channel string, channelType rtm2.ChannelType, h func(*rtm2.LockEvent)) (func(), error) {
+	key := watchKey{client: client, channel: channel, channelType: channelType}
+	watchers.Lock()
+	defer watchers.Unlock()
+	w, ok := watchers.m[key]
+	if !ok {
+		_, events, err := client.Lock().GetLockChan(channel, channelType)
+		if err != nil {
+			return nil, err
+		}
+		w = &watcher{handlers: make(map[int]func(*rtm2.LockEvent)), stop: make(chan struct{})}
+		watchers.m[key] = w
+		go w.run(events)
+	}
+	w.mu.Lock()
+	id := w.next
+	w.next++
+	w.handlers[id] = h
+	w.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			delete(w.handlers, id)
+		})
+	}, nil
+}
+
+// Unwatch stops draining the LockEvent chan of the channel, and drops its handlers.
+// Call it once Lock of the channel is unsubscribed, e.g. after Unsubscribe, so that a later Watch
+// reads the chan of the new subscription.
+func Unwatch(client rtm2.RTMClient, channel string, channelType rtm2.ChannelType) {
+	key := watchKey{client: client, channel: channel, channelType: channelType}
+	watchers.Lock()
+	defer watchers.Unlock()
+	w, ok := watchers.m[key]
+	if !ok {
+		return
+	}
+	delete(watchers.m, key)
+	close(w.stop)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers = make(map[int]func(*rtm2.LockEvent))
+}
+
+func (w *watcher) run(events <-chan *rtm2.LockEvent) {
+	for {
+		select {
+		case e := <-events:
+			// handlers run unlocked, so they may call Watch or cancel
+			w.mu.Lock()
+			ids := make([]int, 0, len(w.handlers))
+			for id := range w.handlers {
+				ids = append(ids, id)
+			}
+			sort.Ints(ids)
+			handlers := make([]func(*rtm2.LockEvent), 0, len(ids))
+			for _, id := range ids {
+				handlers = append(handlers, w.handlers[id])
+			}
+			w.mu.Unlock()
+			for _, h := range handlers {
+				h(e)
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
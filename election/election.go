@@ -0,0 +1,385 @@
+// Package election elects one leader among users of a channel, on top of dsync.DistributedMutex.
+//
+// Candidates subscribe the channel with Lock and Presence, e.g. by
+// client.Subscribe(channel, rtm2.WithMessageLock(true), rtm2.WithMessagePresence(true)).
+// The leader holds the lock, renewing its lease, and writes its user id into the channel metadata.
+// Leader changes are observed from LockEvents shared by dsync.Watch.
+// While campaigning, the lock is revoked from a leader timed out or left, as seen by a presence.Roster of the channel.
+// The Election creates its own Roster, which reads the PresenceEvent chan for the Election's lifetime,
+// unless one is shared by WithRoster.
+package election
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/dsync"
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+	"github.com/tomasliu-agora/rtm2/presence"
+	"go.uber.org/zap"
+)
+
+var ErrNotLeader = errors.New("election: not leader")
+
+type Options struct {
+	ChannelType rtm2.ChannelType
+	// TTL in seconds of the leader lock, the leader is replaced TTL after it is disconnected. 10 by default.
+	TTL uint32
+	// RenewInterval between lease renewals, TTL / 3 by default.
+	RenewInterval time.Duration
+	// Key of the channel metadata item holding the leader's user id, "election.<name>" by default.
+	Key string
+	// Roster of the channel to detect leaders timed out, created by the Election if not set.
+	Roster *presence.Roster
+	Logger *zap.Logger
+}
+
+type Option func(*Options)
+
+// WithChannelType is ChannelTypeMessage by default.
+func WithChannelType(t rtm2.ChannelType) Option {
+	return func(o *Options) {
+		o.ChannelType = t
+	}
+}
+
+func WithTTL(ttl uint32) Option {
+	return func(o *Options) {
+		o.TTL = ttl
+	}
+}
+
+func WithRenewInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.RenewInterval = d
+	}
+}
+
+// WithMetadataKey sets the channel metadata key the leader is published to.
+func WithMetadataKey(key string) Option {
+	return func(o *Options) {
+		o.Key = key
+	}
+}
+
+// WithRoster shares the Roster of the channel, which must be closed by the caller after the Election.
+func WithRoster(r *presence.Roster) Option {
+	return func(o *Options) {
+		o.Roster = r
+	}
+}
+
+func WithLogger(lg *zap.Logger) Option {
+	return func(o *Options) {
+		o.Logger = lg
+	}
+}
+
+// Election is a campaign of one user for the leadership named name in channel.
+type Election struct {
+	client  rtm2.RTMClient
+	userId  string
+	channel string
+	name    string
+	opts    *Options
+	lg      *zap.Logger
+	mutex   *dsync.DistributedMutex
+	cancel  func()
+	roster  *presence.Roster
+	// ownRoster is closed by Close
+	ownRoster bool
+
+	mu        sync.Mutex
+	seen      bool // whether leader is set by LockEvent
+	campaigns int  // pending Campaign calls revoking absent leaders
+	leader    string
+	isLeader  bool
+	resigned  chan struct{}
+	observers []*observer
+}
+
+type observer struct {
+	ch  chan string
+	box *outbox.Outbox
+}
+
+// New starts observing the leader named name in channel. userId must be the user logged in by client.
+func New(client rtm2.RTMClient, userId string, channel string, name string, opts ...Option) (*Election, error) {
+	o := &Options{ChannelType: rtm2.ChannelTypeMessage, TTL: 10}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Key == "" {
+		o.Key = "election." + name
+	}
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+	e := &Election{
+		client:  client,
+		userId:  userId,
+		channel: channel,
+		name:    name,
+		opts:    o,
+		lg:      o.Logger.With(zap.String("channel", channel), zap.String("election", name)),
+	}
+	e.mutex = dsync.NewMutex(client, userId, channel, name,
+		dsync.WithChannelType(o.ChannelType), dsync.WithTTL(o.TTL), dsync.WithRenewInterval(o.RenewInterval), dsync.WithLogger(o.Logger))
+	cancel, err := dsync.Watch(client, channel, o.ChannelType, e.onEvent)
+	if err != nil {
+		return nil, err
+	}
+	e.cancel = cancel
+	locks, err := client.Lock().Get(channel, o.ChannelType)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	e.mu.Lock()
+	if l, ok := locks[name]; ok && !e.seen {
+		e.leader = l.Owner
+	}
+	e.mu.Unlock()
+	e.roster = o.Roster
+	if e.roster == nil {
+		r, err := presence.NewRoster(client.Presence(), channel, o.ChannelType, presence.WithLogger(o.Logger))
+		if err != nil {
+			e.lg.Warn("presence not subscribed, dead leader is replaced after lock expiry only", zap.Error(err))
+		} else {
+			e.roster, e.ownRoster = r, true
+		}
+	}
+	if e.roster != nil {
+		cancelRoster := e.roster.OnChange(e.onChange)
+		e.cancel = func() {
+			cancelRoster()
+			cancel()
+		}
+	}
+	return e, nil
+}
+
+// Campaign blocks until this user is elected, or ctx is done.
+// A leader timed out by Presence is revoked meanwhile, instead of waiting for its lock to expire.
+// Returns nil at once if already the leader.
+func (e *Election) Campaign(ctx context.Context) error {
+	e.mu.Lock()
+	isLeader := e.isLeader
+	e.mu.Unlock()
+	if isLeader {
+		return nil
+	}
+	e.mu.Lock()
+	e.campaigns++
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.campaigns--
+		e.mu.Unlock()
+	}()
+	if leader := e.Leader(); leader != "" && e.roster != nil {
+		if _, ok := e.roster.State(leader); !ok {
+			go e.revoke(leader)
+		}
+	}
+	if err := e.mutex.Lock(ctx); err != nil {
+		return err
+	}
+	resigned := make(chan struct{})
+	e.mu.Lock()
+	e.isLeader = true
+	e.resigned = resigned
+	e.mu.Unlock()
+	go e.keep(e.mutex.Lost(), resigned)
+	e.publish()
+	return nil
+}
+
+// Resign gives up the leadership. Returns ErrNotLeader if not the leader.
+func (e *Election) Resign() error {
+	e.mu.Lock()
+	if !e.isLeader {
+		e.mu.Unlock()
+		return ErrNotLeader
+	}
+	e.isLeader = false
+	close(e.resigned)
+	e.mu.Unlock()
+	data := map[string]*rtm2.MetadataItem{e.opts.Key: {Key: e.opts.Key}}
+	if err := e.client.Storage().RemoveChannelMetadata(e.channel, e.opts.ChannelType, data, rtm2.WithStorageLock(e.name)); err != nil {
+		e.lg.Warn("remove leader metadata failed", zap.Error(err))
+	}
+	return e.mutex.Unlock()
+}
+
+// Leader returns the user id of the current leader, empty if there is none.
+func (e *Election) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// IsLeader returns whether this user is the leader.
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.isLeader
+}
+
+// Lost returns a golang chan closed once the leadership is lost, e.g. revoked or expired.
+// A disconnected leader learns the loss after reconnection, or once renewals have failed for TTL.
+// It is nil before the first election, and not closed by Resign.
+func (e *Election) Lost() <-chan struct{} {
+	return e.mutex.Lost()
+}
+
+// Observe returns a golang chan receiving the current leader, then the user id of every new leader.
+// Empty string means the leadership is vacant.
+func (e *Election) Observe() <-chan string {
+	o := &observer{ch: make(chan string), box: outbox.New()}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.observers = append(e.observers, o)
+	o.send(e.leader)
+	return o.ch
+}
+
+// Close resigns if elected, and stops observing. Golang chans of Observe are left open, same as rtm sdk.
+// The own Roster keeps draining PresenceEvents, share one by WithRoster to Detach it after Unsubscribe.
+func (e *Election) Close() error {
+	err := e.Resign()
+	if errors.Is(err, ErrNotLeader) {
+		err = nil
+	}
+	e.cancel()
+	if e.ownRoster {
+		e.roster.Close()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, o := range e.observers {
+		o.box.Close()
+	}
+	e.observers = nil
+	return err
+}
+
+// keep unlocks the mutex locally once the leadership is lost.
+func (e *Election) keep(lost <-chan struct{}, resigned chan struct{}) {
+	select {
+	case <-lost:
+	case <-resigned:
+		return
+	}
+	e.mu.Lock()
+	if e.resigned != resigned || !e.isLeader {
+		e.mu.Unlock()
+		return
+	}
+	e.isLeader = false
+	e.mu.Unlock()
+	e.lg.Warn("leadership lost")
+	if err := e.mutex.Unlock(); err != nil {
+		e.lg.Debug("release lost lock failed", zap.Error(err))
+	}
+}
+
+// publish writes the leader into channel metadata, only allowed while holding the lock.
+func (e *Election) publish() {
+	data := map[string]*rtm2.MetadataItem{e.opts.Key: {Key: e.opts.Key, Value: e.userId}}
+	if err := e.client.Storage().SetChannelMetadata(e.channel, e.opts.ChannelType, data,
+		rtm2.WithStorageLock(e.name), rtm2.WithStorageRecordAuthor(true), rtm2.WithStorageRecordTs(true)); err != nil {
+		e.lg.Warn("publish leader metadata failed", zap.Error(err))
+	}
+}
+
+func (e *Election) onEvent(ev *rtm2.LockEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	leader, found := e.leader, false
+	for _, d := range ev.Details {
+		if d.Name != e.name {
+			continue
+		}
+		found = true
+		switch ev.Type {
+		case rtm2.LockTypeAcquired, rtm2.LockTypeSnapshot:
+			leader = d.Owner
+		case rtm2.LockTypeReleased, rtm2.LockTypeExpired:
+			if d.Owner == leader {
+				leader = ""
+			}
+		case rtm2.LockTypeRemove:
+			leader = ""
+		}
+	}
+	if ev.Type == rtm2.LockTypeSnapshot && !found {
+		leader = ""
+	}
+	e.seen = true
+	if leader == e.leader {
+		return
+	}
+	e.leader = leader
+	for _, o := range e.observers {
+		o.send(leader)
+	}
+}
+
+// send must be called with Election.mu locked to keep the order.
+func (o *observer) send(leader string) {
+	o.box.Push(func(done <-chan struct{}) {
+		select {
+		case o.ch <- leader:
+		case <-done:
+		}
+	})
+}
+
+// onChange revokes the lock from a leader timed out or left while campaigning.
+// Roster handlers must not block, so revoke runs on its own goroutine.
+func (e *Election) onChange(c *presence.Change) {
+	if c.Type != presence.ChangeTimeout && c.Type != presence.ChangeLeft {
+		return
+	}
+	e.mu.Lock()
+	campaigning := e.campaigns > 0
+	e.mu.Unlock()
+	if campaigning {
+		go e.revoke(c.UserId)
+	}
+}
+
+// revoke takes the lock from userId if it is the leader. The lock is then handed over to the first candidate waiting.
+func (e *Election) revoke(userId string) {
+	if userId == e.userId || userId != e.Leader() {
+		return
+	}
+	if err := e.client.Lock().Revoke(e.channel, e.opts.ChannelType, e.name, userId); err != nil {
+		e.lg.Debug("revoke leader failed", zap.String("leader", userId), zap.Error(err))
+		return
+	}
+	e.lg.Info("revoked leader timed out", zap.String("leader", userId))
+	e.clear(userId)
+}
+
+// clear removes the leader metadata still naming userId, unless a new leader has published meanwhile.
+func (e *Election) clear(userId string) {
+	storage := e.client.Storage()
+	_, items, err := storage.GetChannelMetadata(e.channel, e.opts.ChannelType)
+	if err != nil {
+		e.lg.Debug("get leader metadata failed", zap.Error(err))
+		return
+	}
+	item, ok := items[e.opts.Key]
+	if !ok || item.Value != userId {
+		return
+	}
+	data := map[string]*rtm2.MetadataItem{e.opts.Key: {Key: e.opts.Key, Revision: item.Revision}}
+	if err := storage.RemoveChannelMetadata(e.channel, e.opts.ChannelType, data); err != nil {
+		e.lg.Debug("remove revoked leader metadata failed", zap.String("leader", userId), zap.Error(err))
+	}
+}
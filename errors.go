@@ -1,9 +1,39 @@
 package rtm2
 
 import (
+	"errors"
 	"fmt"
 )
 
+// ErrorCategory groups errnos by how callers usually handle them.
+type ErrorCategory int
+
+const (
+	ErrorCategoryUnknown         ErrorCategory = 0
+	ErrorCategoryAuth            ErrorCategory = 1 // login or token problems
+	ErrorCategoryLimit           ErrorCategory = 2 // quota or size limitation exceeded, see consts.go
+	ErrorCategoryNotJoined       ErrorCategory = 3 // channel not joined or not subscribed
+	ErrorCategoryInvalidArgument ErrorCategory = 4 // invalid argument or state, retrying does not help
+	ErrorCategoryTransient       ErrorCategory = 5 // may succeed if retried later
+)
+
+func (c ErrorCategory) String() string {
+	switch c {
+	case ErrorCategoryAuth:
+		return "auth"
+	case ErrorCategoryLimit:
+		return "limit"
+	case ErrorCategoryNotJoined:
+		return "not-joined"
+	case ErrorCategoryInvalidArgument:
+		return "invalid-argument"
+	case ErrorCategoryTransient:
+		return "transient"
+	default:
+		return "unknown"
+	}
+}
+
 type RTMError struct {
 	msg   string
 	errno int
@@ -13,6 +43,22 @@ func (e RTMError) Error() string {
 	return fmt.Sprintf("%d: %s", e.errno, e.msg)
 }
 
+// Code returns the errno.
+func (e RTMError) Code() int {
+	return e.errno
+}
+
+// Category returns the category of the errno, ErrorCategoryUnknown for unknown errnos.
+func (e RTMError) Category() ErrorCategory {
+	return categories[e.errno]
+}
+
+// Is matches any RTMError with the same errno, so errors.Is works on wrapped errors and on values from ErrorFromCode.
+func (e RTMError) Is(target error) bool {
+	t, ok := target.(RTMError)
+	return ok && t.errno == e.errno
+}
+
 func newRTMError(errno int, msg string) error {
 	return RTMError{errno: errno, msg: msg}
 }
@@ -58,6 +104,102 @@ var (
 	ERR_PRESENCE_CHANNEL_NOT_EXIST              = newRTMError(10311, "ERR_PRESENCE_CHANNEL_NOT_EXIST")
 )
 
+var categories = map[int]ErrorCategory{
+	10001: ErrorCategoryInvalidArgument, // ERR_TOPIC_ALREADY_JOINED
+	10002: ErrorCategoryLimit,           // ERR_EXCEED_JOIN_TOPIC_LIMITATION
+	10003: ErrorCategoryInvalidArgument, // ERR_INVALID_TOPIC_NAME
+	10004: ErrorCategoryTransient,       // ERR_PUBLISH_TOPIC_MESSAGE_FAILED
+	10005: ErrorCategoryLimit,           // ERR_EXCEED_SUBSCRIBE_TOPIC_LIMITATION
+	10006: ErrorCategoryLimit,           // ERR_EXCEED_USER_LIMITATION
+	10007: ErrorCategoryLimit,           // ERR_EXCEED_CHANNEL_LIMITATION
+	10008: ErrorCategoryInvalidArgument, // ERR_ALREADY_JOIN_CHANNEL
+	10009: ErrorCategoryNotJoined,       // ERR_NOT_JOIN_CHANNEL
+	10010: ErrorCategoryAuth,            // ERR_ALREADY_LOGIN
+	10011: ErrorCategoryAuth,            // ERR_NOT_LOGIN
+	10012: ErrorCategoryAuth,            // ERR_DUPLICATE_TOKEN
+	10013: ErrorCategoryNotJoined,       // ERR_NOT_SUBSCRIBED
+	10014: ErrorCategoryInvalidArgument, // ERR_ALREADY_SUBSCRIBED
+	10015: ErrorCategoryAuth,            // ERR_ENCRYPTION_FAILED
+	10101: ErrorCategoryLimit,           // ERR_METADATA_SIZE_OVERFLOW
+	10102: ErrorCategoryLimit,           // ERR_METADATA_ITEM_SIZE_OVERFLOW
+	10103: ErrorCategoryLimit,           // ERR_METADATA_KEY_SIZE_OVERFLOW
+	10104: ErrorCategoryLimit,           // ERR_METADATA_VALUE_SIZE_OVERFLOW
+	10105: ErrorCategoryInvalidArgument, // ERR_METADATA_INVALID_KEY
+	10106: ErrorCategoryTransient,       // ERR_METADATA_INVALID_REVISION
+	10107: ErrorCategoryNotJoined,       // ERR_METADATA_NOT_SUBSCRIBED
+	10108: ErrorCategoryInvalidArgument, // ERR_METADATA_ALREADY_SUBSCRIBED
+	10109: ErrorCategoryLimit,           // ERR_METADATA_EXCEED_SUBSCRIPTION_LIMIT
+	10110: ErrorCategoryInvalidArgument, // ERR_METADATA_WITH_INVALID_LOCK
+	10201: ErrorCategoryTransient,       // ERR_LOCK_OPERATION_PERFORMING
+	10202: ErrorCategoryInvalidArgument, // ERR_RELEASE_LOCK_NOT_ACQUIRED
+	10301: ErrorCategoryTransient,       // ERR_PRESENCE_SERVICE_NOT_READY
+	10302: ErrorCategoryNotJoined,       // ERR_PRESENCE_OPERATION_WITHOUT_JOIN_CHANNEL
+	10303: ErrorCategoryLimit,           // ERR_PRESENCE_STATE_SIZE_OVERFLOW
+	10304: ErrorCategoryLimit,           // ERR_PRESENCE_STATE_KEY_SIZE_OVERFLOW
+	10305: ErrorCategoryInvalidArgument, // ERR_PRESENCE_STATE_INVALID_KEY
+	10306: ErrorCategoryInvalidArgument, // ERR_PRESENCE_STATE_DUPLICATE_KEY
+	10307: ErrorCategoryLimit,           // ERR_PRESENCE_STATE_VALUE_SIZE_OVERFLOW
+	10308: ErrorCategoryTransient,       // ERR_PRESENCE_SYNC_CLIENT_ERROR
+	10309: ErrorCategoryInvalidArgument, // ERR_PRESENCE_USER_NOT_EXIST
+	10310: ErrorCategoryLimit,           // ERR_PRESENCE_CHANNEL_COUNT_OVERFLOW
+	10311: ErrorCategoryInvalidArgument, // ERR_PRESENCE_CHANNEL_NOT_EXIST
+}
+
+// OpError wraps an error with the operation context.
+// errors.Is and errors.As see through OpError to the RTMError.
+type OpError struct {
+	Op      string // e.g. "Publish", "StreamChannel.JoinTopic"
+	Channel string
+	Topic   string
+	Err     error
+}
+
+func (e *OpError) Error() string {
+	msg := e.Op
+	if e.Channel != "" {
+		msg += " channel=" + e.Channel
+	}
+	if e.Topic != "" {
+		msg += " topic=" + e.Topic
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// WrapError adds the operation context to err. Returns nil if err is nil.
+func WrapError(err error, op string, channel string, topic string) error {
+	if err == nil {
+		return nil
+	}
+	return &OpError{Op: op, Channel: channel, Topic: topic, Err: err}
+}
+
+// ErrorCode returns the errno of the RTMError in err's chain, or 0 if there is none.
+func ErrorCode(err error) int {
+	var e RTMError
+	if errors.As(err, &e) {
+		return e.Code()
+	}
+	return 0
+}
+
+// ErrorCategoryOf returns the category of the RTMError in err's chain, or ErrorCategoryUnknown if there is none.
+func ErrorCategoryOf(err error) ErrorCategory {
+	var e RTMError
+	if errors.As(err, &e) {
+		return e.Category()
+	}
+	return ErrorCategoryUnknown
+}
+
+// IsRetryable reports whether err is a transient RTMError, which may succeed if the same call is retried later.
+func IsRetryable(err error) bool {
+	return ErrorCategoryOf(err) == ErrorCategoryTransient
+}
+
 func ErrorFromCode(errno int32) error {
 	if errno == 0 {
 		return nil
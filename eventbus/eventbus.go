@@ -0,0 +1,374 @@
+// Package eventbus merges the golang chans of rtm2 into one ordered stream of tagged Events.
+//
+// Sources are drained as soon as events arrive, and queued without bound for each consumer,
+// so slow consumers never block the rtm sdk, whose blocked golang chans cause fatal errors.
+// Sources of a channel are drained until Detach, even after Close.
+//
+//	bus := eventbus.New()
+//	conns, _, _ := client.Login(token)
+//	bus.AddConnection(conns)
+//	messages, _ := client.Subscribe(channel, rtm2.WithMessageMetadata(true), rtm2.WithMessageLock(true))
+//	bus.Attach(client, channel, rtm2.ChannelTypeMessage, messages)
+//	bus.On(eventbus.KindLock, func(e *eventbus.Event) { ... })
+//	events, cancel := bus.Events(eventbus.ForChannel(channel), eventbus.ForKinds(eventbus.KindMessage, eventbus.KindStorage))
+package eventbus
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+	"go.uber.org/zap"
+)
+
+type EventKind int
+
+const (
+	KindConnection EventKind = iota
+	KindMessage
+	KindStorage
+	KindLock
+	KindPresence
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case KindConnection:
+		return "connection"
+	case KindMessage:
+		return "message"
+	case KindStorage:
+		return "storage"
+	case KindLock:
+		return "lock"
+	case KindPresence:
+		return "presence"
+	}
+	return "unknown"
+}
+
+// Event is one event of any source, tagged by Kind with the matching field set.
+// Channel is empty for ConnectionEvents of the client, Topic is set for messages of Stream Channel topics.
+type Event struct {
+	Kind EventKind
+	// Seq numbers events in the order of arrival on the bus, from 1.
+	Seq         uint64
+	Channel     string
+	ChannelType rtm2.ChannelType
+	Topic       string
+
+	Connection *rtm2.ConnectionEvent
+	Message    *rtm2.Message
+	Storage    *rtm2.StorageEvent
+	Lock       *rtm2.LockEvent
+	Presence   *rtm2.PresenceEvent
+}
+
+// Filter selects events for Events and On.
+type Filter func(e *Event) bool
+
+// ForChannel selects events of the channel, including its ConnectionEvents.
+func ForChannel(channel string) Filter {
+	return func(e *Event) bool {
+		return e.Channel == channel
+	}
+}
+
+// ForTopic selects messages of the topic.
+func ForTopic(topic string) Filter {
+	return func(e *Event) bool {
+		return e.Kind == KindMessage && e.Topic == topic
+	}
+}
+
+func ForKinds(kinds ...EventKind) Filter {
+	return func(e *Event) bool {
+		for _, k := range kinds {
+			if e.Kind == k {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type Options struct {
+	Logger *zap.Logger
+}
+
+type Option func(*Options)
+
+func WithLogger(lg *zap.Logger) Option {
+	return func(o *Options) {
+		o.Logger = lg
+	}
+}
+
+type subscriber struct {
+	filters []Filter
+	ch      chan *Event
+	box     *outbox.Outbox
+}
+
+type handler struct {
+	id      int
+	kind    EventKind
+	filters []Filter
+	f       func(*Event)
+}
+
+// EventBus merges sources added by Attach and Add* into one ordered stream.
+type EventBus struct {
+	lg *zap.Logger
+	// handlers run one event after another on their own outbox
+	handlerBox *outbox.Outbox
+
+	mu          sync.Mutex
+	seq         uint64
+	subscribers map[int]*subscriber
+	handlers    []*handler
+	nextId      int
+	closed      bool
+	// sources are the stop chans of draining sources by channel, "" for ConnectionEvents
+	sources map[string][]chan struct{}
+}
+
+func New(opts ...Option) *EventBus {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+	return &EventBus{
+		lg:          o.Logger,
+		handlerBox:  outbox.New(),
+		subscribers: make(map[int]*subscriber),
+		sources:     make(map[string][]chan struct{}),
+	}
+}
+
+// Attach adds the messages of a subscribed channel, or nil for a Stream Channel, along with the StorageEvents,
+// LockEvents and PresenceEvents of the channel which are subscribed. Snapshots are sent as the first events:
+// a StorageEvent without MajorRevision, a LockTypeSnapshot LockEvent and a PresenceTypeSnapshot PresenceEvent.
+// The bus consumes these golang chans, so do not read them elsewhere, and call Detach once the channel is unsubscribed.
+func (b *EventBus) Attach(client rtm2.RTMClient, channel string, channelType rtm2.ChannelType, messages <-chan *rtm2.Message) error {
+	if messages != nil {
+		b.AddMessages(channel, channelType, messages)
+	}
+	var errs []error
+	items, storage, err := client.Storage().GetChannelMetadataChan(channel, channelType)
+	switch {
+	case err == nil:
+		b.publish(&Event{Kind: KindStorage, Channel: channel, ChannelType: channelType, Storage: &rtm2.StorageEvent{Items: items}})
+		b.AddStorage(channel, channelType, storage)
+	case !notSubscribed(err):
+		errs = append(errs, err)
+	}
+	locks, lockEvents, err := client.Lock().GetLockChan(channel, channelType)
+	switch {
+	case err == nil:
+		details := make([]*rtm2.LockDetail, 0, len(locks))
+		for _, d := range locks {
+			details = append(details, d)
+		}
+		b.publish(&Event{Kind: KindLock, Channel: channel, ChannelType: channelType, Lock: &rtm2.LockEvent{Type: rtm2.LockTypeSnapshot, Details: details}})
+		b.AddLock(channel, channelType, lockEvents)
+	case !notSubscribed(err):
+		errs = append(errs, err)
+	}
+	users, presence, err := client.Presence().GetPresenceChan(channel, channelType)
+	switch {
+	case err == nil:
+		states := make(map[string]map[string]string, len(users))
+		for userId, user := range users {
+			states[userId] = user.State
+		}
+		b.publish(&Event{Kind: KindPresence, Channel: channel, ChannelType: channelType, Presence: &rtm2.PresenceEvent{Type: rtm2.PresenceTypeSnapshot, States: states}})
+		b.AddPresence(channel, channelType, presence)
+	case !notSubscribed(err):
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func notSubscribed(err error) bool {
+	return errors.Is(err, rtm2.ERR_NOT_SUBSCRIBED) || errors.Is(err, rtm2.ERR_METADATA_NOT_SUBSCRIBED)
+}
+
+// AddConnection adds the ConnectionEvents returned by Login, drained until Detach("").
+func (b *EventBus) AddConnection(events <-chan *rtm2.ConnectionEvent) {
+	drain(b, "", events, func(e *rtm2.ConnectionEvent) *Event {
+		return &Event{Kind: KindConnection, Channel: e.Channel, Connection: e}
+	})
+}
+
+func (b *EventBus) AddMessages(channel string, channelType rtm2.ChannelType, messages <-chan *rtm2.Message) {
+	drain(b, channel, messages, func(m *rtm2.Message) *Event {
+		return &Event{Kind: KindMessage, Channel: channel, ChannelType: channelType, Message: m}
+	})
+}
+
+// AddTopic adds the messages of a topic returned by SubscribeTopic of a Stream Channel.
+func (b *EventBus) AddTopic(channel, topic string, messages <-chan *rtm2.Message) {
+	drain(b, channel, messages, func(m *rtm2.Message) *Event {
+		return &Event{Kind: KindMessage, Channel: channel, ChannelType: rtm2.ChannelTypeStream, Topic: topic, Message: m}
+	})
+}
+
+func (b *EventBus) AddStorage(channel string, channelType rtm2.ChannelType, events <-chan *rtm2.StorageEvent) {
+	drain(b, channel, events, func(e *rtm2.StorageEvent) *Event {
+		return &Event{Kind: KindStorage, Channel: channel, ChannelType: channelType, Storage: e}
+	})
+}
+
+func (b *EventBus) AddLock(channel string, channelType rtm2.ChannelType, events <-chan *rtm2.LockEvent) {
+	drain(b, channel, events, func(e *rtm2.LockEvent) *Event {
+		return &Event{Kind: KindLock, Channel: channel, ChannelType: channelType, Lock: e}
+	})
+}
+
+func (b *EventBus) AddPresence(channel string, channelType rtm2.ChannelType, events <-chan *rtm2.PresenceEvent) {
+	drain(b, channel, events, func(e *rtm2.PresenceEvent) *Event {
+		return &Event{Kind: KindPresence, Channel: channel, ChannelType: channelType, Presence: e}
+	})
+}
+
+// drain reads src until the channel is detached, discarding events after Close.
+// Golang chans of rtm sdk are never closed, but a closed src ends draining.
+func drain[T any](b *EventBus, channel string, src <-chan T, wrap func(T) *Event) {
+	stop := make(chan struct{})
+	b.mu.Lock()
+	b.sources[channel] = append(b.sources[channel], stop)
+	b.mu.Unlock()
+	go func() {
+		for {
+			select {
+			case v, ok := <-src:
+				if !ok {
+					b.lg.Debug("event source closed")
+					return
+				}
+				b.publish(wrap(v))
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func match(filters []Filter, e *Event) bool {
+	for _, f := range filters {
+		if !f(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// publish numbers e and queues it for every consumer, without blocking.
+func (b *EventBus) publish(e *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.seq++
+	e.Seq = b.seq
+	for _, s := range b.subscribers {
+		if !match(s.filters, e) {
+			continue
+		}
+		s := s
+		s.box.Push(func(done <-chan struct{}) {
+			select {
+			case s.ch <- e:
+			case <-done:
+			}
+		})
+	}
+	var hs []func(*Event)
+	for _, h := range b.handlers {
+		if h.kind == e.Kind && match(h.filters, e) {
+			hs = append(hs, h.f)
+		}
+	}
+	if len(hs) == 0 {
+		return
+	}
+	b.handlerBox.Push(func(done <-chan struct{}) {
+		for _, f := range hs {
+			f(e)
+		}
+	})
+}
+
+// Events returns a golang chan receiving events matching all filters, in order, until cancel is called.
+// Events are queued for a slow reader without bound. The golang chan is left open, same as rtm sdk.
+func (b *EventBus) Events(filters ...Filter) (events <-chan *Event, cancel func()) {
+	s := &subscriber{filters: filters, ch: make(chan *Event), box: outbox.New()}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextId
+	b.nextId++
+	if b.closed {
+		s.box.Close()
+	} else {
+		b.subscribers[id] = s
+	}
+	return s.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[id]; ok {
+			delete(b.subscribers, id)
+			s.box.Close()
+		}
+	}
+}
+
+// On registers f for events of kind matching all filters, until cancel is called.
+// Handlers run one event after another in order, on a goroutine shared by all handlers of the bus,
+// so a slow handler delays other handlers, but never the sources.
+func (b *EventBus) On(kind EventKind, f func(*Event), filters ...Filter) (cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextId
+	b.nextId++
+	b.handlers = append(b.handlers, &handler{id: id, kind: kind, filters: filters, f: f})
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.handlers = slices.DeleteFunc(b.handlers, func(h *handler) bool { return h.id == id })
+	}
+}
+
+// Detach stops draining the sources of the channel, including its topics, or the ConnectionEvents for "".
+// Call it once the channel is unsubscribed, e.g. after Unsubscribe, so that the sources of a new subscription
+// can be added again.
+func (b *EventBus) Detach(channel string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, stop := range b.sources[channel] {
+		close(stop)
+	}
+	delete(b.sources, channel)
+}
+
+// Close stops delivering events. Sources are still drained and their events discarded,
+// since the rtm sdk blocks on unread golang chans, until Detach.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for id, s := range b.subscribers {
+		s.box.Close()
+		delete(b.subscribers, id)
+	}
+	b.handlerBox.Close()
+}
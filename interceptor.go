@@ -0,0 +1,273 @@
+package rtm2
+
+import (
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+)
+
+// PublishInfo is the message being published. Interceptors may change Message and Type.
+// Channel, ChannelType and Topic tell where it goes, changing them does not re-route the message.
+type PublishInfo struct {
+	Channel     string
+	ChannelType ChannelType
+	Topic       string // only for Stream Channel
+	Type        MessageType
+	Message     []byte
+}
+
+// PublishHandler publishes the message, or calls the next interceptor.
+type PublishHandler func(info *PublishInfo) error
+
+// PublishInterceptor runs before Publish and PublishTopic.
+// Return an error to reject the message, or return nil without calling next to drop it silently.
+type PublishInterceptor func(info *PublishInfo, next PublishHandler) error
+
+// ReceiveInfo is where a received message comes from.
+type ReceiveInfo struct {
+	Channel     string
+	ChannelType ChannelType
+	Topic       string // only for Stream Channel
+}
+
+// ReceiveHandler delivers the message to the golang chan, or calls the next interceptor.
+type ReceiveHandler func(info *ReceiveInfo, m *Message) error
+
+// ReceiveInterceptor runs on every message received by Subscribe and SubscribeTopic.
+// Call next with another *Message to mutate it. Return nil without calling next to drop it,
+// or return an error to drop it and report the error to the ReceiveErrorHandler.
+type ReceiveInterceptor func(info *ReceiveInfo, m *Message, next ReceiveHandler) error
+
+type InterceptorOptions struct {
+	Publish []PublishInterceptor
+	Receive []ReceiveInterceptor
+	// ReceiveErrorHandler is called when a ReceiveInterceptor rejects a message.
+	ReceiveErrorHandler func(info *ReceiveInfo, m *Message, err error)
+}
+
+type InterceptorOption func(*InterceptorOptions)
+
+// WithPublishInterceptors appends interceptors. The first one registered runs first.
+func WithPublishInterceptors(interceptors ...PublishInterceptor) InterceptorOption {
+	return func(o *InterceptorOptions) {
+		o.Publish = append(o.Publish, interceptors...)
+	}
+}
+
+// WithReceiveInterceptors appends interceptors. The first one registered runs first.
+func WithReceiveInterceptors(interceptors ...ReceiveInterceptor) InterceptorOption {
+	return func(o *InterceptorOptions) {
+		o.Receive = append(o.Receive, interceptors...)
+	}
+}
+
+// WithReceiveErrorHandler sets the handler of messages rejected by ReceiveInterceptor, which are dropped by default.
+func WithReceiveErrorHandler(h func(info *ReceiveInfo, m *Message, err error)) InterceptorOption {
+	return func(o *InterceptorOptions) {
+		o.ReceiveErrorHandler = h
+	}
+}
+
+// WithInterceptors returns an RTMClient running interceptors on
+// RTMClient.Publish / Subscribe and StreamChannel.PublishTopic / SubscribeTopic.
+// Messages received before Subscribe returns are delivered after it, in order.
+func WithInterceptors(client RTMClient, opts ...InterceptorOption) RTMClient {
+	o := &InterceptorOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &interceptedClient{
+		RTMClient: client,
+		opts:      o,
+		channels:  make(map[string]*forwarder),
+		streams:   make(map[string]*interceptedStream),
+	}
+}
+
+func (o *InterceptorOptions) publish(info *PublishInfo, last PublishHandler) error {
+	h := last
+	for i := len(o.Publish) - 1; i >= 0; i-- {
+		interceptor, next := o.Publish[i], h
+		h = func(info *PublishInfo) error {
+			return interceptor(info, next)
+		}
+	}
+	return h(info)
+}
+
+func (o *InterceptorOptions) receive(info *ReceiveInfo, m *Message, last ReceiveHandler) {
+	h := last
+	for i := len(o.Receive) - 1; i >= 0; i-- {
+		interceptor, next := o.Receive[i], h
+		h = func(info *ReceiveInfo, m *Message) error {
+			return interceptor(info, m, next)
+		}
+	}
+	if err := h(info, m); err != nil && o.ReceiveErrorHandler != nil {
+		o.ReceiveErrorHandler(info, m, err)
+	}
+}
+
+// forwarder runs ReceiveInterceptors on messages from the sdk golang chan, and delivers them to its own golang chan.
+type forwarder struct {
+	out  chan *Message
+	box  *outbox.Outbox
+	stop chan struct{}
+}
+
+func newForwarder(o *InterceptorOptions, info *ReceiveInfo, in <-chan *Message) *forwarder {
+	f := &forwarder{out: make(chan *Message), box: outbox.New(), stop: make(chan struct{})}
+	deliver := func(_ *ReceiveInfo, m *Message) error {
+		f.box.Push(func(done <-chan struct{}) {
+			select {
+			case f.out <- m:
+			case <-done:
+			}
+		})
+		return nil
+	}
+	go func() {
+		for {
+			select {
+			case m := <-in:
+				o.receive(info, m, deliver)
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+	return f
+}
+
+func (f *forwarder) close() {
+	close(f.stop)
+	f.box.Close()
+}
+
+type interceptedClient struct {
+	RTMClient
+	opts *InterceptorOptions
+
+	mu       sync.Mutex
+	channels map[string]*forwarder
+	streams  map[string]*interceptedStream
+}
+
+func (c *interceptedClient) Publish(channel string, message []byte, opts ...MessageOption) error {
+	o := DefaultMessageOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	info := &PublishInfo{Channel: channel, ChannelType: ChannelTypeMessage, Type: o.Type, Message: message}
+	return c.opts.publish(info, func(info *PublishInfo) error {
+		return c.RTMClient.Publish(channel, info.Message, append(opts[:len(opts):len(opts)], WithMessageType(info.Type))...)
+	})
+}
+
+func (c *interceptedClient) Subscribe(channel string, opts ...MessageOption) (chan *Message, error) {
+	messages, err := c.RTMClient.Subscribe(channel, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.channels[channel]; ok {
+		f.close()
+	}
+	f := newForwarder(c.opts, &ReceiveInfo{Channel: channel, ChannelType: ChannelTypeMessage}, messages)
+	c.channels[channel] = f
+	return f.out, nil
+}
+
+func (c *interceptedClient) Unsubscribe(channel string) error {
+	if err := c.RTMClient.Unsubscribe(channel); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.channels[channel]; ok {
+		f.close()
+		delete(c.channels, channel)
+	}
+	return nil
+}
+
+// Logout stops the forwarders of all channels and topics, as the sdk drops their subscriptions.
+func (c *interceptedClient) Logout() error {
+	if err := c.RTMClient.Logout(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for channel, f := range c.channels {
+		f.close()
+		delete(c.channels, channel)
+	}
+	for _, s := range c.streams {
+		s.closeTopics()
+	}
+	return nil
+}
+
+func (c *interceptedClient) StreamChannel(channel string) StreamChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.streams[channel]
+	if !ok {
+		s = &interceptedStream{StreamChannel: c.RTMClient.StreamChannel(channel), opts: c.opts, topics: make(map[<-chan *Message]*forwarder)}
+		c.streams[channel] = s
+	}
+	return s
+}
+
+type interceptedStream struct {
+	StreamChannel
+	opts *InterceptorOptions
+
+	mu     sync.Mutex
+	topics map[<-chan *Message]*forwarder // by the sdk golang chan
+}
+
+func (s *interceptedStream) Leave() error {
+	if err := s.StreamChannel.Leave(); err != nil {
+		return err
+	}
+	s.closeTopics()
+	return nil
+}
+
+func (s *interceptedStream) closeTopics() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for in, f := range s.topics {
+		f.close()
+		delete(s.topics, in)
+	}
+}
+
+func (s *interceptedStream) PublishTopic(topic string, message []byte, opts ...StreamOption) error {
+	o := &StreamOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	info := &PublishInfo{Channel: s.ChannelName(), ChannelType: ChannelTypeStream, Topic: topic, Type: o.Type, Message: message}
+	return s.opts.publish(info, func(info *PublishInfo) error {
+		return s.StreamChannel.PublishTopic(topic, info.Message, append(opts[:len(opts):len(opts)], WithStreamMessageType(info.Type))...)
+	})
+}
+
+// SubscribeTopic returns the same golang chan for the same topic, as long as the sdk does.
+func (s *interceptedStream) SubscribeTopic(topic string, userIds []string) (<-chan *Message, error) {
+	messages, err := s.StreamChannel.SubscribeTopic(topic, userIds)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.topics[messages]
+	if !ok {
+		f = newForwarder(s.opts, &ReceiveInfo{Channel: s.ChannelName(), ChannelType: ChannelTypeStream, Topic: topic}, messages)
+		s.topics[messages] = f
+	}
+	return f.out, nil
+}
@@ -0,0 +1,63 @@
+// Package outbox delivers events to golang chans without blocking the producer.
+package outbox
+
+import "sync"
+
+// Outbox runs queued sends one by one in order.
+// Producers never block on a slow consumer: sends are queued without bound until the chan is drained.
+type Outbox struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []func(done <-chan struct{})
+	done   chan struct{}
+	closed bool
+}
+
+func New() *Outbox {
+	o := &Outbox{done: make(chan struct{})}
+	o.cond = sync.NewCond(&o.mu)
+	go o.run()
+	return o
+}
+
+func (o *Outbox) run() {
+	for {
+		o.mu.Lock()
+		for len(o.items) == 0 && !o.closed {
+			o.cond.Wait()
+		}
+		if o.closed {
+			o.mu.Unlock()
+			return
+		}
+		f := o.items[0]
+		o.items[0] = nil
+		o.items = o.items[1:]
+		o.mu.Unlock()
+		f(o.done)
+	}
+}
+
+// Push queues a send. The send must give up once done is closed.
+func (o *Outbox) Push(f func(done <-chan struct{})) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.items = append(o.items, f)
+	o.cond.Signal()
+}
+
+// Close drops pending sends. The golang chan itself is left open, same as rtm sdk.
+func (o *Outbox) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.closed = true
+	o.items = nil
+	close(o.done)
+	o.cond.Signal()
+}
@@ -0,0 +1,84 @@
+// Package tagcodec maps exported struct fields tagged `rtm:"key[,omitempty]"` to string values.
+// Strings, booleans and numbers are encoded as their text, other types as JSON.
+package tagcodec
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type Field struct {
+	Index     int
+	Name      string
+	Key       string
+	OmitEmpty bool
+}
+
+// Fields returns the tagged fields of struct typ in order. Fields without tag, tagged "-" or unexported are skipped.
+// Keys are not validated.
+func Fields(typ reflect.Type) []Field {
+	var fields []Field
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag, ok := f.Tag.Lookup("rtm")
+		if !ok || tag == "-" || !f.IsExported() {
+			continue
+		}
+		key, flags, _ := strings.Cut(tag, ",")
+		fields = append(fields, Field{Index: i, Name: f.Name, Key: key, OmitEmpty: flags == "omitempty"})
+	}
+	return fields
+}
+
+func Encode(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	}
+	data, err := json.Marshal(v.Interface())
+	return string(data), err
+}
+
+// Decode sets the addressable v from s.
+func Decode(s string, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err == nil {
+			v.SetBool(b)
+		}
+		return err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err == nil {
+			v.SetInt(n)
+		}
+		return err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err == nil {
+			v.SetUint(n)
+		}
+		return err
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err == nil {
+			v.SetFloat(f)
+		}
+		return err
+	}
+	return json.Unmarshal([]byte(s), v.Addr().Interface())
+}
@@ -0,0 +1,47 @@
+package localrtm
+
+import (
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+// Admin drives the simulation on a local server, e.g. the network loss of certain user.
+type Admin struct {
+	cn *conn
+}
+
+func DialAdmin(addr string) (*Admin, error) {
+	cn, err := dial(addr, zap.NewNop())
+	if err != nil {
+		return nil, err
+	}
+	return &Admin{cn: cn}, nil
+}
+
+// Interrupt simulates a network loss of certain user. See memrtm.Hub.Interrupt.
+func (a *Admin) Interrupt(userId string) error {
+	_, err := a.cn.call("admin.interrupt", &params{UserId: userId})
+	return err
+}
+
+// Restore simulates the recovery of certain user. See memrtm.Hub.Restore.
+func (a *Admin) Restore(userId string) error {
+	_, err := a.cn.call("admin.restore", &params{UserId: userId})
+	return err
+}
+
+// ExpireToken notifies certain user that the token will expire. Empty channel stands for the RTM token.
+func (a *Admin) ExpireToken(userId string, channel string) error {
+	_, err := a.cn.call("admin.expireToken", &params{UserId: userId, Channel: channel})
+	return err
+}
+
+// PresenceOutOfService notifies presence subscribers of certain channel that presence service is unavailable.
+func (a *Admin) PresenceOutOfService(channel string, channelType rtm2.ChannelType) error {
+	_, err := a.cn.call("admin.presenceOutOfService", &params{Channel: channel, ChannelType: channelType})
+	return err
+}
+
+func (a *Admin) Close() {
+	a.cn.close()
+}
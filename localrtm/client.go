@@ -0,0 +1,418 @@
+package localrtm
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+	"go.uber.org/zap"
+)
+
+// CreateRTMClient creates a client of the local server listening on addr.
+// The TCP connection is established by Login and closed by Logout.
+// Once the connection is lost, ConnectionStateFAILED with ConnectionChangedReasonLost is notified.
+func CreateRTMClient(addr string, config *rtm2.RTMConfig) rtm2.RTMClient {
+	lg := config.Logger
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	c := &client{
+		addr:    addr,
+		config:  *config,
+		lg:      lg.With(zap.String("user", config.UserId)),
+		streams: make(map[string]*streamChannel),
+	}
+	c.storage = &storage{c: c}
+	c.locks = &locks{c: c}
+	c.presence = &presence{c: c}
+	return c
+}
+
+type client struct {
+	addr   string
+	config rtm2.RTMConfig
+	lg     *zap.Logger
+
+	storage  *storage
+	locks    *locks
+	presence *presence
+
+	mu      sync.Mutex
+	streams map[string]*streamChannel
+	conn    *conn
+}
+
+// conn is a single TCP connection, from Login till Logout or connection lost.
+type conn struct {
+	lg  *zap.Logger
+	nc  net.Conn
+	wmu sync.Mutex
+	enc *json.Encoder
+
+	mu       sync.Mutex
+	nextId   uint64
+	calls    map[uint64]chan *frame
+	handlers map[uint64]func(raw json.RawMessage)
+	closers  map[uint64]func()            // notify streams waiting for a final event that the connection is lost
+	pending  map[uint64][]json.RawMessage // events arrived before the handler is registered
+	boxes    []*outbox.Outbox
+	topics   map[uint64]chan *rtm2.Message // SubscribeTopic returns the same stream for the same topic
+	closed   bool
+	onClose  func()
+}
+
+func dial(addr string, lg *zap.Logger) (*conn, error) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		lg:       lg,
+		nc:       nc,
+		enc:      json.NewEncoder(nc),
+		calls:    make(map[uint64]chan *frame),
+		handlers: make(map[uint64]func(raw json.RawMessage)),
+		closers:  make(map[uint64]func()),
+		pending:  make(map[uint64][]json.RawMessage),
+		topics:   make(map[uint64]chan *rtm2.Message),
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *conn) read() {
+	scanner := bufio.NewScanner(c.nc)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		f := &frame{}
+		if err := json.Unmarshal(scanner.Bytes(), f); err != nil {
+			c.lg.Warn("invalid frame", zap.Error(err))
+			break
+		}
+		c.mu.Lock()
+		if f.Stream != 0 {
+			if h, ok := c.handlers[f.Stream]; ok {
+				h(f.Event)
+			} else {
+				c.pending[f.Stream] = append(c.pending[f.Stream], f.Event)
+			}
+		} else if call, ok := c.calls[f.Id]; ok {
+			delete(c.calls, f.Id)
+			call <- f
+		}
+		c.mu.Unlock()
+	}
+	c.close()
+}
+
+func (c *conn) close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	for id, call := range c.calls {
+		close(call)
+		delete(c.calls, id)
+	}
+	closers := c.closers
+	c.closers = make(map[uint64]func())
+	onClose := c.onClose
+	c.mu.Unlock()
+	c.nc.Close()
+	for _, f := range closers {
+		f()
+	}
+	if onClose != nil {
+		onClose()
+	}
+}
+
+// call sends a request and waits for the response. ERR_NOT_LOGIN is returned if the connection is lost.
+func (c *conn) call(method string, p *params) (*result, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, rtm2.ERR_NOT_LOGIN
+	}
+	c.nextId++
+	id := c.nextId
+	wait := make(chan *frame, 1)
+	c.calls[id] = wait
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	err := c.enc.Encode(&frame{Id: id, Method: method, Params: p})
+	c.wmu.Unlock()
+	if err != nil {
+		c.close()
+		return nil, rtm2.ERR_NOT_LOGIN
+	}
+	f, ok := <-wait
+	if !ok {
+		return nil, rtm2.ERR_NOT_LOGIN
+	}
+	if f.Error != nil {
+		// errors of the server are reported with the method, use errors.Is to match the sentinels
+		var channel, topic string
+		if p != nil {
+			channel, topic = p.Channel, p.Topic
+		}
+		return nil, rtm2.WrapError(fromWire(f.Error), opName(method), channel, topic)
+	}
+	if f.Result == nil {
+		return &result{}, nil
+	}
+	return f.Result, nil
+}
+
+func (c *conn) on(id uint64, h func(raw json.RawMessage)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[id] = h
+	for _, raw := range c.pending[id] {
+		h(raw)
+	}
+	delete(c.pending, id)
+}
+
+// onClosed registers f to be called once the connection is lost, immediately if it is already.
+func (c *conn) onClosed(id uint64, f func()) {
+	c.mu.Lock()
+	if !c.closed {
+		c.closers[id] = f
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	f()
+}
+
+func (c *conn) dropClosed(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.closers, id)
+}
+
+// forward decodes every event of certain stream into ch, which must be a golang chan of pointer or string.
+// Events are queued, so a slow consumer never blocks the connection.
+func (c *conn) forward(id uint64, ch interface{}) {
+	v := reflect.ValueOf(ch)
+	elem := v.Type().Elem()
+	box := outbox.New()
+	c.mu.Lock()
+	c.boxes = append(c.boxes, box)
+	c.mu.Unlock()
+	c.on(id, func(raw json.RawMessage) {
+		e := reflect.New(elem)
+		if err := json.Unmarshal(raw, e.Interface()); err != nil {
+			c.lg.Warn("invalid event", zap.Uint64("stream", id), zap.Error(err))
+			return
+		}
+		box.Push(func(done <-chan struct{}) {
+			reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: v, Send: e.Elem()},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+			})
+		})
+	})
+}
+
+func (c *conn) topicMessages(id uint64) chan *rtm2.Message {
+	c.mu.Lock()
+	messages, ok := c.topics[id]
+	if !ok {
+		messages = make(chan *rtm2.Message)
+		c.topics[id] = messages
+	}
+	c.mu.Unlock()
+	if !ok {
+		c.forward(id, messages)
+	}
+	return messages
+}
+
+// current returns the connection established by Login.
+func (c *client) current() *conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *client) call(method string, p *params) (*result, error) {
+	cn := c.current()
+	if cn == nil {
+		return nil, rtm2.ERR_NOT_LOGIN
+	}
+	return cn.call(method, p)
+}
+
+func (c *client) Login(token string) (<-chan *rtm2.ConnectionEvent, <-chan string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return nil, nil, rtm2.ERR_ALREADY_LOGIN
+	}
+	cn, err := dial(c.addr, c.lg)
+	if err != nil {
+		return nil, nil, err
+	}
+	hello := &config{
+		Appid:           c.config.Appid,
+		UserId:          c.config.UserId,
+		Vid:             c.config.Vid,
+		AreaCode:        c.config.AreaCode,
+		PresenceTimeout: c.config.PresenceTimeout,
+	}
+	if _, err := cn.call("hello", &params{Config: hello}); err != nil {
+		cn.close()
+		return nil, nil, err
+	}
+	res, err := cn.call("login", &params{Token: token})
+	if err != nil {
+		cn.close()
+		return nil, nil, err
+	}
+	events := make(chan *rtm2.ConnectionEvent)
+	tokens := make(chan string)
+	cn.forward(res.Connection, events)
+	cn.forward(res.Tokens, tokens)
+	lost := outbox.New()
+	cn.mu.Lock()
+	cn.boxes = append(cn.boxes, lost)
+	cn.onClose = func() {
+		c.mu.Lock()
+		if c.conn == cn {
+			c.conn = nil
+		}
+		c.mu.Unlock()
+		c.lg.Warn("connection lost")
+		lost.Push(func(done <-chan struct{}) {
+			select {
+			case events <- &rtm2.ConnectionEvent{State: rtm2.ConnectionStateFAILED, Reason: rtm2.ConnectionChangedReasonLost}:
+			case <-done:
+			}
+		})
+	}
+	cn.mu.Unlock()
+	c.conn = cn
+	c.lg.Info("login", zap.String("addr", c.addr))
+	return events, tokens, nil
+}
+
+func (c *client) Logout() error {
+	c.mu.Lock()
+	cn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if cn == nil {
+		return rtm2.ERR_NOT_LOGIN
+	}
+	_, err := cn.call("logout", nil)
+	cn.mu.Lock()
+	cn.onClose = nil
+	boxes := cn.boxes
+	cn.mu.Unlock()
+	cn.close()
+	for _, box := range boxes {
+		box.Close()
+	}
+	return err
+}
+
+func (c *client) SetParameters(values map[string]interface{}) error {
+	_, err := c.call("setParameters", &params{Params: values})
+	return err
+}
+
+func (c *client) GetParameters() map[string]interface{} {
+	res, err := c.call("getParameters", nil)
+	if err != nil {
+		return make(map[string]interface{})
+	}
+	return res.Params
+}
+
+func (c *client) RenewToken(token string) error {
+	_, err := c.call("renewToken", &params{Token: token})
+	return err
+}
+
+func (c *client) Storage() rtm2.Storage {
+	return c.storage
+}
+
+func (c *client) Lock() rtm2.Lock {
+	return c.locks
+}
+
+func (c *client) Presence() rtm2.Presence {
+	return c.presence
+}
+
+func (c *client) Publish(channel string, message []byte, opts ...rtm2.MessageOption) error {
+	o := rtm2.DefaultMessageOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	_, err := c.call("publish", &params{Channel: channel, Message: message, MessageOptions: o})
+	return err
+}
+
+func (c *client) Subscribe(channel string, opts ...rtm2.MessageOption) (chan *rtm2.Message, error) {
+	o := rtm2.DefaultMessageOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	cn := c.current()
+	if cn == nil {
+		return nil, rtm2.ERR_NOT_LOGIN
+	}
+	res, err := cn.call("subscribe", &params{Channel: channel, MessageOptions: o})
+	if err != nil {
+		return nil, err
+	}
+	messages := make(chan *rtm2.Message)
+	cn.forward(res.Messages, messages)
+	return messages, nil
+}
+
+func (c *client) Unsubscribe(channel string) error {
+	_, err := c.call("unsubscribe", &params{Channel: channel})
+	return err
+}
+
+func (c *client) StreamChannel(channel string) rtm2.StreamChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.streams[channel]
+	if !ok {
+		s = &streamChannel{c: c, name: channel}
+		c.streams[channel] = s
+	}
+	return s
+}
+
+var opPrefixes = map[string]string{"storage": "Storage", "lock": "Lock", "presence": "Presence", "stream": "StreamChannel"}
+
+// opName returns the OpError.Op of a wire method, e.g. "StreamChannel.JoinTopic" for "stream.joinTopic".
+func opName(method string) string {
+	iface, name, ok := strings.Cut(method, ".")
+	if !ok {
+		iface, name = "", method
+	} else if p, found := opPrefixes[iface]; found {
+		iface = p + "."
+	} else {
+		iface += "."
+	}
+	if name == "" {
+		return iface
+	}
+	return iface + strings.ToUpper(name[:1]) + name[1:]
+}
@@ -0,0 +1,103 @@
+package localrtm
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type locks struct {
+	c *client
+}
+
+func (l *locks) GetLockChan(channel string, channelType rtm2.ChannelType) (map[string]*rtm2.LockDetail, <-chan *rtm2.LockEvent, error) {
+	cn := l.c.current()
+	if cn == nil {
+		return nil, nil, rtm2.ERR_NOT_LOGIN
+	}
+	res, err := cn.call("lock.getLockChan", &params{Channel: channel, ChannelType: channelType})
+	if err != nil {
+		return nil, nil, err
+	}
+	events := make(chan *rtm2.LockEvent)
+	cn.forward(res.Events, events)
+	return lockMap(res), events, nil
+}
+
+func lockMap(res *result) map[string]*rtm2.LockDetail {
+	if res.Locks == nil {
+		return make(map[string]*rtm2.LockDetail)
+	}
+	return res.Locks
+}
+
+func (l *locks) Set(channel string, channelType rtm2.ChannelType, name string, ttl uint32) error {
+	_, err := l.c.call("lock.set", &params{Channel: channel, ChannelType: channelType, Name: name, TTL: ttl})
+	return err
+}
+
+func (l *locks) Get(channel string, channelType rtm2.ChannelType) (map[string]*rtm2.LockDetail, error) {
+	res, err := l.c.call("lock.get", &params{Channel: channel, ChannelType: channelType})
+	if err != nil {
+		return nil, err
+	}
+	return lockMap(res), nil
+}
+
+func (l *locks) Remove(channel string, channelType rtm2.ChannelType, name string) error {
+	_, err := l.c.call("lock.remove", &params{Channel: channel, ChannelType: channelType, Name: name})
+	return err
+}
+
+// Acquire sends the request in background. The golang chan is closed if the pending Acquire is canceled by Release,
+// and receives ERR_NOT_LOGIN if the connection is lost before the result.
+func (l *locks) Acquire(channel string, channelType rtm2.ChannelType, name string, retry bool) <-chan error {
+	result := make(chan error, 1)
+	cn := l.c.current()
+	if cn == nil {
+		result <- rtm2.ERR_NOT_LOGIN
+		return result
+	}
+	var once sync.Once
+	done := func(err error, closed bool) {
+		once.Do(func() {
+			if closed {
+				close(result)
+				return
+			}
+			result <- err
+		})
+	}
+	go func() {
+		res, err := cn.call("lock.acquire", &params{Channel: channel, ChannelType: channelType, Name: name, Retry: retry})
+		if err != nil {
+			done(err, false)
+			return
+		}
+		cn.onClosed(res.Acquire, func() {
+			done(rtm2.ERR_NOT_LOGIN, false)
+		})
+		cn.on(res.Acquire, func(raw json.RawMessage) {
+			// handlers run with cn.mu locked
+			go cn.dropClosed(res.Acquire)
+			e := &acquireEvent{}
+			if err := json.Unmarshal(raw, e); err != nil {
+				done(err, false)
+				return
+			}
+			done(fromWire(e.Error), e.Closed)
+		})
+	}()
+	return result
+}
+
+func (l *locks) Release(channel string, channelType rtm2.ChannelType, name string) error {
+	_, err := l.c.call("lock.release", &params{Channel: channel, ChannelType: channelType, Name: name})
+	return err
+}
+
+func (l *locks) Revoke(channel string, channelType rtm2.ChannelType, name string, owner string) error {
+	_, err := l.c.call("lock.revoke", &params{Channel: channel, ChannelType: channelType, Name: name, Owner: owner})
+	return err
+}
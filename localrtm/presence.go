@@ -0,0 +1,69 @@
+package localrtm
+
+import "github.com/tomasliu-agora/rtm2"
+
+type presence struct {
+	c *client
+}
+
+func userStates(res *result) map[string]*rtm2.UserState {
+	if res.UserStates == nil {
+		return make(map[string]*rtm2.UserState)
+	}
+	return res.UserStates
+}
+
+func (p *presence) GetPresenceChan(channel string, channelType rtm2.ChannelType) (map[string]*rtm2.UserState, <-chan *rtm2.PresenceEvent, error) {
+	cn := p.c.current()
+	if cn == nil {
+		return nil, nil, rtm2.ERR_NOT_LOGIN
+	}
+	res, err := cn.call("presence.getPresenceChan", &params{Channel: channel, ChannelType: channelType})
+	if err != nil {
+		return nil, nil, err
+	}
+	events := make(chan *rtm2.PresenceEvent)
+	cn.forward(res.Events, events)
+	return userStates(res), events, nil
+}
+
+func (p *presence) WhoNow(channel string, channelType rtm2.ChannelType, opts ...rtm2.PresenceOption) (map[string]*rtm2.UserState, string, error) {
+	o := &rtm2.PresenceOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	res, err := p.c.call("presence.whoNow", &params{Channel: channel, ChannelType: channelType, PresenceOptions: o})
+	if err != nil {
+		return nil, "", err
+	}
+	return userStates(res), res.Next, nil
+}
+
+func (p *presence) WhereNow(userId string) ([]*rtm2.ChannelInfo, error) {
+	res, err := p.c.call("presence.whereNow", &params{UserId: userId})
+	if err != nil {
+		return nil, err
+	}
+	return res.Channels, nil
+}
+
+func (p *presence) SetState(channel string, channelType rtm2.ChannelType, data map[string]string) error {
+	_, err := p.c.call("presence.setState", &params{Channel: channel, ChannelType: channelType, State: data})
+	return err
+}
+
+func (p *presence) RemoveState(channel string, channelType rtm2.ChannelType, keys []string) error {
+	_, err := p.c.call("presence.removeState", &params{Channel: channel, ChannelType: channelType, Keys: keys})
+	return err
+}
+
+func (p *presence) GetState(channel string, channelType rtm2.ChannelType, userId string) (map[string]string, error) {
+	res, err := p.c.call("presence.getState", &params{Channel: channel, ChannelType: channelType, UserId: userId})
+	if err != nil {
+		return nil, err
+	}
+	if res.State == nil {
+		return make(map[string]string), nil
+	}
+	return res.State, nil
+}
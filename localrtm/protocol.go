@@ -0,0 +1,105 @@
+// Package localrtm runs a local stand-in of the rtm service over TCP, and provides an RTMClient talking to it.
+// The server is backed by memrtm, so every client connected to the same server shares
+// Message Channels, Stream Channels, metadata, locks and presence.
+// See PROTOCOL.md for the wire protocol.
+package localrtm
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+// frame is a single line of JSON on the wire.
+// Requests carry Id and Method, responses carry Id and Result or Error, events carry Stream and Event.
+type frame struct {
+	Id     uint64          `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params *params         `json:"params,omitempty"`
+	Result *result         `json:"result,omitempty"`
+	Error  *wireError      `json:"error,omitempty"`
+	Stream uint64          `json:"stream,omitempty"`
+	Event  json.RawMessage `json:"event,omitempty"`
+}
+
+type wireError struct {
+	Code int    `json:"code"` // errno of RTMError, 0 for other errors
+	Msg  string `json:"msg"`
+}
+
+type config struct {
+	Appid           string `json:"appid"`
+	UserId          string `json:"userId"`
+	Vid             uint32 `json:"vid,omitempty"`
+	AreaCode        uint32 `json:"areaCode,omitempty"`
+	PresenceTimeout uint32 `json:"presenceTimeout,omitempty"`
+}
+
+type params struct {
+	Config      *config                       `json:"config,omitempty"`
+	Token       string                        `json:"token,omitempty"`
+	Channel     string                        `json:"channel,omitempty"`
+	ChannelType rtm2.ChannelType              `json:"channelType,omitempty"`
+	Topic       string                        `json:"topic,omitempty"`
+	Message     []byte                        `json:"message,omitempty"`
+	UserId      string                        `json:"userId,omitempty"`
+	Users       []string                      `json:"users,omitempty"`
+	Keys        []string                      `json:"keys,omitempty"`
+	Name        string                        `json:"name,omitempty"`
+	Owner       string                        `json:"owner,omitempty"`
+	TTL         uint32                        `json:"ttl,omitempty"`
+	Retry       bool                          `json:"retry,omitempty"`
+	Params      map[string]interface{}        `json:"params,omitempty"`
+	Items       map[string]*rtm2.MetadataItem `json:"items,omitempty"`
+	State       map[string]string             `json:"state,omitempty"`
+
+	MessageOptions  *rtm2.MessageOptions  `json:"messageOptions,omitempty"`
+	StreamOptions   *rtm2.StreamOptions   `json:"streamOptions,omitempty"`
+	StorageOptions  *rtm2.StorageOptions  `json:"storageOptions,omitempty"`
+	PresenceOptions *rtm2.PresenceOptions `json:"presenceOptions,omitempty"`
+}
+
+type result struct {
+	// Stream ids of events pushed afterwards
+	Connection  uint64 `json:"connection,omitempty"`
+	Tokens      uint64 `json:"tokens,omitempty"`
+	Messages    uint64 `json:"messages,omitempty"`
+	Events      uint64 `json:"events,omitempty"`
+	TopicEvents uint64 `json:"topicEvents,omitempty"`
+	Acquire     uint64 `json:"acquire,omitempty"`
+
+	Params        map[string]interface{}        `json:"params,omitempty"`
+	Snapshot      map[string][]string           `json:"snapshot,omitempty"`
+	MajorRevision int64                         `json:"majorRevision,omitempty"`
+	Items         map[string]*rtm2.MetadataItem `json:"items,omitempty"`
+	Users         []string                      `json:"users,omitempty"`
+	UserStates    map[string]*rtm2.UserState    `json:"userStates,omitempty"`
+	Next          string                        `json:"next,omitempty"`
+	Channels      []*rtm2.ChannelInfo           `json:"channels,omitempty"`
+	Locks         map[string]*rtm2.LockDetail   `json:"locks,omitempty"`
+	State         map[string]string             `json:"state,omitempty"`
+}
+
+// acquireEvent is pushed on the stream returned by lock.acquire.
+type acquireEvent struct {
+	Error  *wireError `json:"error,omitempty"`
+	Closed bool       `json:"closed,omitempty"`
+}
+
+func toWire(err error) *wireError {
+	if err == nil {
+		return nil
+	}
+	return &wireError{Code: rtm2.ErrorCode(err), Msg: err.Error()}
+}
+
+func fromWire(e *wireError) error {
+	if e == nil {
+		return nil
+	}
+	if e.Code != 0 {
+		return rtm2.ErrorFromCode(int32(e.Code))
+	}
+	return errors.New(e.Msg)
+}
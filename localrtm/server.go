@@ -0,0 +1,394 @@
+package localrtm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/memrtm"
+	"go.uber.org/zap"
+)
+
+var errBadRequest = errors.New("bad request")
+
+// Server serves the rtm stand-in over TCP. A connection dropped without Logout is treated as a network loss:
+// presence times out after RTMConfig.PresenceTimeout and locks owned expire after their TTL.
+type Server struct {
+	hub *memrtm.Hub
+	lg  *zap.Logger
+}
+
+func NewServer(hub *memrtm.Hub, lg *zap.Logger) *Server {
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	return &Server{hub: hub, lg: lg}
+}
+
+// Serve accepts connections until the listener is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		c := &serverConn{s: s, nc: nc, enc: json.NewEncoder(nc), done: make(chan struct{}), streams: make(map[interface{}]uint64), lg: s.lg.With(zap.Stringer("remote", nc.RemoteAddr()))}
+		go c.serve()
+	}
+}
+
+type serverConn struct {
+	s   *Server
+	nc  net.Conn
+	lg  *zap.Logger
+	wmu sync.Mutex
+	enc *json.Encoder
+
+	client     rtm2.RTMClient
+	userId     string
+	nextStream uint64
+	streams    map[interface{}]uint64 // golang chan -> stream id, the same chan is pumped only once
+	starts     []func()               // event pumps started after the response is written
+	done       chan struct{}
+}
+
+func (c *serverConn) serve() {
+	defer func() {
+		close(c.done)
+		c.nc.Close()
+		if c.client != nil {
+			c.s.hub.InterruptClient(c.client)
+		}
+		c.lg.Info("connection closed")
+	}()
+	scanner := bufio.NewScanner(c.nc)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		req := &frame{}
+		if err := json.Unmarshal(scanner.Bytes(), req); err != nil {
+			c.lg.Warn("invalid frame", zap.Error(err))
+			return
+		}
+		if req.Params == nil {
+			req.Params = &params{}
+		}
+		res, err := c.dispatch(req.Method, req.Params)
+		c.write(&frame{Id: req.Id, Result: res, Error: toWire(err)})
+		for _, start := range c.starts {
+			start()
+		}
+		c.starts = nil
+	}
+}
+
+func (c *serverConn) write(f *frame) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.enc.Encode(f); err != nil {
+		c.lg.Debug("write failed", zap.Error(err))
+	}
+}
+
+// pump forwards every event received from ch, which must be a receivable golang chan, as a stream.
+func (c *serverConn) pump(ch interface{}) uint64 {
+	if id, ok := c.streams[ch]; ok {
+		return id
+	}
+	c.nextStream++
+	id := c.nextStream
+	c.streams[ch] = id
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.done)},
+	}
+	c.starts = append(c.starts, func() {
+		go func() {
+			for {
+				chosen, v, ok := reflect.Select(cases)
+				if chosen == 1 || !ok {
+					return
+				}
+				c.emit(id, v.Interface())
+			}
+		}()
+	})
+	return id
+}
+
+func (c *serverConn) emit(id uint64, event interface{}) {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		c.lg.Warn("marshal event failed", zap.Error(err))
+		return
+	}
+	c.write(&frame{Stream: id, Event: raw})
+}
+
+// pumpAcquire forwards the result of Lock.Acquire, including the close of the golang chan.
+func (c *serverConn) pumpAcquire(ch <-chan error) uint64 {
+	c.nextStream++
+	id := c.nextStream
+	c.starts = append(c.starts, func() {
+		go func() {
+			select {
+			case err, ok := <-ch:
+				c.emit(id, &acquireEvent{Error: toWire(err), Closed: !ok})
+			case <-c.done:
+			}
+		}()
+	})
+	return id
+}
+
+func (c *serverConn) dispatch(method string, p *params) (*result, error) {
+	switch method {
+	case "hello":
+		if c.client != nil || p.Config == nil || p.Config.UserId == "" {
+			return nil, errBadRequest
+		}
+		c.userId = p.Config.UserId
+		c.lg = c.lg.With(zap.String("user", c.userId))
+		c.client = c.s.hub.CreateRTMClient(&rtm2.RTMConfig{
+			Appid:           p.Config.Appid,
+			UserId:          p.Config.UserId,
+			Vid:             p.Config.Vid,
+			AreaCode:        p.Config.AreaCode,
+			PresenceTimeout: p.Config.PresenceTimeout,
+			Logger:          c.lg,
+		})
+		return &result{}, nil
+	case "admin.interrupt":
+		c.s.hub.Interrupt(p.UserId)
+		return &result{}, nil
+	case "admin.restore":
+		c.s.hub.Restore(p.UserId)
+		return &result{}, nil
+	case "admin.expireToken":
+		c.s.hub.ExpireToken(p.UserId, p.Channel)
+		return &result{}, nil
+	case "admin.presenceOutOfService":
+		c.s.hub.PresenceOutOfService(p.Channel, p.ChannelType)
+		return &result{}, nil
+	}
+	if c.client == nil {
+		return nil, rtm2.ERR_NOT_LOGIN
+	}
+	cl := c.client
+	switch method {
+	case "login":
+		conn, tokens, err := cl.Login(p.Token)
+		if err != nil {
+			return nil, err
+		}
+		return &result{Connection: c.pump(conn), Tokens: c.pump(tokens)}, nil
+	case "logout":
+		return &result{}, cl.Logout()
+	case "setParameters":
+		return &result{}, cl.SetParameters(p.Params)
+	case "getParameters":
+		return &result{Params: cl.GetParameters()}, nil
+	case "renewToken":
+		return &result{}, cl.RenewToken(p.Token)
+	case "publish":
+		return &result{}, cl.Publish(p.Channel, p.Message, messageOptions(p))
+	case "subscribe":
+		ch, err := cl.Subscribe(p.Channel, messageOptions(p))
+		if err != nil {
+			return nil, err
+		}
+		return &result{Messages: c.pump(ch)}, nil
+	case "unsubscribe":
+		return &result{}, cl.Unsubscribe(p.Channel)
+	}
+	if s, ok := streamMethods[method]; ok {
+		return s(c, cl.StreamChannel(p.Channel), p)
+	}
+	if s, ok := storageMethods[method]; ok {
+		return s(c, cl.Storage(), p)
+	}
+	if s, ok := lockMethods[method]; ok {
+		return s(c, cl.Lock(), p)
+	}
+	if s, ok := presenceMethods[method]; ok {
+		return s(c, cl.Presence(), p)
+	}
+	return nil, errBadRequest
+}
+
+func messageOptions(p *params) rtm2.MessageOption {
+	return func(o *rtm2.MessageOptions) {
+		if p.MessageOptions != nil {
+			*o = *p.MessageOptions
+		}
+	}
+}
+
+func streamOptions(p *params) rtm2.StreamOption {
+	return func(o *rtm2.StreamOptions) {
+		if p.StreamOptions != nil {
+			*o = *p.StreamOptions
+		}
+	}
+}
+
+func storageOptions(p *params) rtm2.StorageOption {
+	return func(o *rtm2.StorageOptions) {
+		if p.StorageOptions != nil {
+			*o = *p.StorageOptions
+		}
+	}
+}
+
+func presenceOptions(p *params) rtm2.PresenceOption {
+	return func(o *rtm2.PresenceOptions) {
+		if p.PresenceOptions != nil {
+			*o = *p.PresenceOptions
+		}
+	}
+}
+
+var streamMethods = map[string]func(c *serverConn, s rtm2.StreamChannel, p *params) (*result, error){
+	"stream.join": func(c *serverConn, s rtm2.StreamChannel, p *params) (*result, error) {
+		snapshot, events, tokens, err := s.Join(streamOptions(p))
+		if err != nil {
+			return nil, err
+		}
+		return &result{Snapshot: snapshot, TopicEvents: c.pump(events), Tokens: c.pump(tokens)}, nil
+	},
+	"stream.leave": func(c *serverConn, s rtm2.StreamChannel, p *params) (*result, error) {
+		return &result{}, s.Leave()
+	},
+	"stream.joinTopic": func(c *serverConn, s rtm2.StreamChannel, p *params) (*result, error) {
+		return &result{}, s.JoinTopic(p.Topic, streamOptions(p))
+	},
+	"stream.publishTopic": func(c *serverConn, s rtm2.StreamChannel, p *params) (*result, error) {
+		return &result{}, s.PublishTopic(p.Topic, p.Message, streamOptions(p))
+	},
+	"stream.leaveTopic": func(c *serverConn, s rtm2.StreamChannel, p *params) (*result, error) {
+		return &result{}, s.LeaveTopic(p.Topic)
+	},
+	"stream.subscribeTopic": func(c *serverConn, s rtm2.StreamChannel, p *params) (*result, error) {
+		ch, err := s.SubscribeTopic(p.Topic, p.Users)
+		if err != nil {
+			return nil, err
+		}
+		return &result{Messages: c.pump(ch)}, nil
+	},
+	"stream.unsubscribeTopic": func(c *serverConn, s rtm2.StreamChannel, p *params) (*result, error) {
+		return &result{}, s.UnsubscribeTopic(p.Topic, p.Users)
+	},
+	"stream.getSubscribedUsers": func(c *serverConn, s rtm2.StreamChannel, p *params) (*result, error) {
+		users, err := s.GetSubscribedUsers(p.Topic)
+		return &result{Users: users}, err
+	},
+	"stream.renewToken": func(c *serverConn, s rtm2.StreamChannel, p *params) (*result, error) {
+		return &result{}, s.RenewToken(p.Token)
+	},
+}
+
+var storageMethods = map[string]func(c *serverConn, s rtm2.Storage, p *params) (*result, error){
+	"storage.getChannelMetadataChan": func(c *serverConn, s rtm2.Storage, p *params) (*result, error) {
+		items, events, err := s.GetChannelMetadataChan(p.Channel, p.ChannelType)
+		if err != nil {
+			return nil, err
+		}
+		return &result{Items: items, Events: c.pump(events)}, nil
+	},
+	"storage.setChannelMetadata": func(c *serverConn, s rtm2.Storage, p *params) (*result, error) {
+		return &result{}, s.SetChannelMetadata(p.Channel, p.ChannelType, p.Items, storageOptions(p))
+	},
+	"storage.updateChannelMetadata": func(c *serverConn, s rtm2.Storage, p *params) (*result, error) {
+		return &result{}, s.UpdateChannelMetadata(p.Channel, p.ChannelType, p.Items, storageOptions(p))
+	},
+	"storage.removeChannelMetadata": func(c *serverConn, s rtm2.Storage, p *params) (*result, error) {
+		return &result{}, s.RemoveChannelMetadata(p.Channel, p.ChannelType, p.Items, storageOptions(p))
+	},
+	"storage.getChannelMetadata": func(c *serverConn, s rtm2.Storage, p *params) (*result, error) {
+		rev, items, err := s.GetChannelMetadata(p.Channel, p.ChannelType)
+		return &result{MajorRevision: rev, Items: items}, err
+	},
+	"storage.setUserMetadata": func(c *serverConn, s rtm2.Storage, p *params) (*result, error) {
+		return &result{}, s.SetUserMetadata(p.UserId, p.Items, storageOptions(p))
+	},
+	"storage.updateUserMetadata": func(c *serverConn, s rtm2.Storage, p *params) (*result, error) {
+		return &result{}, s.UpdateUserMetadata(p.UserId, p.Items, storageOptions(p))
+	},
+	"storage.removeUserMetadata": func(c *serverConn, s rtm2.Storage, p *params) (*result, error) {
+		return &result{}, s.RemoveUserMetadata(p.UserId, p.Items, storageOptions(p))
+	},
+	"storage.getUserMetadata": func(c *serverConn, s rtm2.Storage, p *params) (*result, error) {
+		rev, items, err := s.GetUserMetadata(p.UserId)
+		return &result{MajorRevision: rev, Items: items}, err
+	},
+	"storage.subscribeUserMetadata": func(c *serverConn, s rtm2.Storage, p *params) (*result, error) {
+		items, events, err := s.SubscribeUserMetadata(p.UserId)
+		if err != nil {
+			return nil, err
+		}
+		return &result{Items: items, Events: c.pump(events)}, nil
+	},
+	"storage.unsubscribeUserMetadata": func(c *serverConn, s rtm2.Storage, p *params) (*result, error) {
+		return &result{}, s.UnsubscribeUserMetadata(p.UserId)
+	},
+}
+
+var lockMethods = map[string]func(c *serverConn, l rtm2.Lock, p *params) (*result, error){
+	"lock.getLockChan": func(c *serverConn, l rtm2.Lock, p *params) (*result, error) {
+		locks, events, err := l.GetLockChan(p.Channel, p.ChannelType)
+		if err != nil {
+			return nil, err
+		}
+		return &result{Locks: locks, Events: c.pump(events)}, nil
+	},
+	"lock.set": func(c *serverConn, l rtm2.Lock, p *params) (*result, error) {
+		return &result{}, l.Set(p.Channel, p.ChannelType, p.Name, p.TTL)
+	},
+	"lock.get": func(c *serverConn, l rtm2.Lock, p *params) (*result, error) {
+		locks, err := l.Get(p.Channel, p.ChannelType)
+		return &result{Locks: locks}, err
+	},
+	"lock.remove": func(c *serverConn, l rtm2.Lock, p *params) (*result, error) {
+		return &result{}, l.Remove(p.Channel, p.ChannelType, p.Name)
+	},
+	"lock.acquire": func(c *serverConn, l rtm2.Lock, p *params) (*result, error) {
+		return &result{Acquire: c.pumpAcquire(l.Acquire(p.Channel, p.ChannelType, p.Name, p.Retry))}, nil
+	},
+	"lock.release": func(c *serverConn, l rtm2.Lock, p *params) (*result, error) {
+		return &result{}, l.Release(p.Channel, p.ChannelType, p.Name)
+	},
+	"lock.revoke": func(c *serverConn, l rtm2.Lock, p *params) (*result, error) {
+		return &result{}, l.Revoke(p.Channel, p.ChannelType, p.Name, p.Owner)
+	},
+}
+
+var presenceMethods = map[string]func(c *serverConn, pr rtm2.Presence, p *params) (*result, error){
+	"presence.getPresenceChan": func(c *serverConn, pr rtm2.Presence, p *params) (*result, error) {
+		users, events, err := pr.GetPresenceChan(p.Channel, p.ChannelType)
+		if err != nil {
+			return nil, err
+		}
+		return &result{UserStates: users, Events: c.pump(events)}, nil
+	},
+	"presence.whoNow": func(c *serverConn, pr rtm2.Presence, p *params) (*result, error) {
+		users, next, err := pr.WhoNow(p.Channel, p.ChannelType, presenceOptions(p))
+		return &result{UserStates: users, Next: next}, err
+	},
+	"presence.whereNow": func(c *serverConn, pr rtm2.Presence, p *params) (*result, error) {
+		channels, err := pr.WhereNow(p.UserId)
+		return &result{Channels: channels}, err
+	},
+	"presence.setState": func(c *serverConn, pr rtm2.Presence, p *params) (*result, error) {
+		return &result{}, pr.SetState(p.Channel, p.ChannelType, p.State)
+	},
+	"presence.removeState": func(c *serverConn, pr rtm2.Presence, p *params) (*result, error) {
+		return &result{}, pr.RemoveState(p.Channel, p.ChannelType, p.Keys)
+	},
+	"presence.getState": func(c *serverConn, pr rtm2.Presence, p *params) (*result, error) {
+		state, err := pr.GetState(p.Channel, p.ChannelType, p.UserId)
+		return &result{State: state}, err
+	},
+}
@@ -0,0 +1,101 @@
+package localrtm
+
+import "github.com/tomasliu-agora/rtm2"
+
+type storage struct {
+	c *client
+}
+
+func storageOptionsOf(opts []rtm2.StorageOption) *rtm2.StorageOptions {
+	o := &rtm2.StorageOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func items(res *result) map[string]*rtm2.MetadataItem {
+	if res.Items == nil {
+		return make(map[string]*rtm2.MetadataItem)
+	}
+	return res.Items
+}
+
+func (s *storage) GetChannelMetadataChan(channel string, channelType rtm2.ChannelType) (map[string]*rtm2.MetadataItem, <-chan *rtm2.StorageEvent, error) {
+	cn := s.c.current()
+	if cn == nil {
+		return nil, nil, rtm2.ERR_NOT_LOGIN
+	}
+	res, err := cn.call("storage.getChannelMetadataChan", &params{Channel: channel, ChannelType: channelType})
+	if err != nil {
+		return nil, nil, err
+	}
+	events := make(chan *rtm2.StorageEvent)
+	cn.forward(res.Events, events)
+	return items(res), events, nil
+}
+
+func (s *storage) SetChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	_, err := s.c.call("storage.setChannelMetadata", &params{Channel: channel, ChannelType: channelType, Items: data, StorageOptions: storageOptionsOf(opts)})
+	return err
+}
+
+func (s *storage) UpdateChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	_, err := s.c.call("storage.updateChannelMetadata", &params{Channel: channel, ChannelType: channelType, Items: data, StorageOptions: storageOptionsOf(opts)})
+	return err
+}
+
+func (s *storage) RemoveChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	_, err := s.c.call("storage.removeChannelMetadata", &params{Channel: channel, ChannelType: channelType, Items: data, StorageOptions: storageOptionsOf(opts)})
+	return err
+}
+
+func (s *storage) GetChannelMetadata(channel string, channelType rtm2.ChannelType) (int64, map[string]*rtm2.MetadataItem, error) {
+	res, err := s.c.call("storage.getChannelMetadata", &params{Channel: channel, ChannelType: channelType})
+	if err != nil {
+		return 0, nil, err
+	}
+	return res.MajorRevision, items(res), nil
+}
+
+func (s *storage) SetUserMetadata(userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	_, err := s.c.call("storage.setUserMetadata", &params{UserId: userId, Items: data, StorageOptions: storageOptionsOf(opts)})
+	return err
+}
+
+func (s *storage) UpdateUserMetadata(userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	_, err := s.c.call("storage.updateUserMetadata", &params{UserId: userId, Items: data, StorageOptions: storageOptionsOf(opts)})
+	return err
+}
+
+func (s *storage) RemoveUserMetadata(userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	_, err := s.c.call("storage.removeUserMetadata", &params{UserId: userId, Items: data, StorageOptions: storageOptionsOf(opts)})
+	return err
+}
+
+func (s *storage) GetUserMetadata(userId string) (int64, map[string]*rtm2.MetadataItem, error) {
+	res, err := s.c.call("storage.getUserMetadata", &params{UserId: userId})
+	if err != nil {
+		return 0, nil, err
+	}
+	return res.MajorRevision, items(res), nil
+}
+
+func (s *storage) SubscribeUserMetadata(userId string) (map[string]*rtm2.MetadataItem, <-chan *rtm2.StorageEvent, error) {
+	cn := s.c.current()
+	if cn == nil {
+		return nil, nil, rtm2.ERR_NOT_LOGIN
+	}
+	res, err := cn.call("storage.subscribeUserMetadata", &params{UserId: userId})
+	if err != nil {
+		return nil, nil, err
+	}
+	events := make(chan *rtm2.StorageEvent)
+	cn.forward(res.Events, events)
+	return items(res), events, nil
+}
+
+func (s *storage) UnsubscribeUserMetadata(userId string) error {
+	_, err := s.c.call("storage.unsubscribeUserMetadata", &params{UserId: userId})
+	return err
+}
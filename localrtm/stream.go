@@ -0,0 +1,91 @@
+package localrtm
+
+import "github.com/tomasliu-agora/rtm2"
+
+type streamChannel struct {
+	c    *client
+	name string
+}
+
+func (s *streamChannel) options(opts []rtm2.StreamOption) *rtm2.StreamOptions {
+	o := &rtm2.StreamOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Join returns the snapshot of topic publishers, the TopicEvent chan and the token expiry chan of this Stream Channel.
+func (s *streamChannel) Join(opts ...rtm2.StreamOption) (map[string][]string, <-chan *rtm2.TopicEvent, <-chan string, error) {
+	cn := s.c.current()
+	if cn == nil {
+		return nil, nil, nil, rtm2.ERR_NOT_LOGIN
+	}
+	res, err := cn.call("stream.join", &params{Channel: s.name, StreamOptions: s.options(opts)})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	events := make(chan *rtm2.TopicEvent)
+	tokens := make(chan string)
+	cn.forward(res.TopicEvents, events)
+	cn.forward(res.Tokens, tokens)
+	snapshot := res.Snapshot
+	if snapshot == nil {
+		snapshot = make(map[string][]string)
+	}
+	return snapshot, events, tokens, nil
+}
+
+func (s *streamChannel) Leave() error {
+	_, err := s.c.call("stream.leave", &params{Channel: s.name})
+	return err
+}
+
+func (s *streamChannel) ChannelName() string {
+	return s.name
+}
+
+func (s *streamChannel) JoinTopic(topic string, opts ...rtm2.StreamOption) error {
+	_, err := s.c.call("stream.joinTopic", &params{Channel: s.name, Topic: topic, StreamOptions: s.options(opts)})
+	return err
+}
+
+func (s *streamChannel) PublishTopic(topic string, message []byte, opts ...rtm2.StreamOption) error {
+	_, err := s.c.call("stream.publishTopic", &params{Channel: s.name, Topic: topic, Message: message, StreamOptions: s.options(opts)})
+	return err
+}
+
+func (s *streamChannel) LeaveTopic(topic string) error {
+	_, err := s.c.call("stream.leaveTopic", &params{Channel: s.name, Topic: topic})
+	return err
+}
+
+func (s *streamChannel) SubscribeTopic(topic string, userIds []string) (<-chan *rtm2.Message, error) {
+	cn := s.c.current()
+	if cn == nil {
+		return nil, rtm2.ERR_NOT_LOGIN
+	}
+	res, err := cn.call("stream.subscribeTopic", &params{Channel: s.name, Topic: topic, Users: userIds})
+	if err != nil {
+		return nil, err
+	}
+	return cn.topicMessages(res.Messages), nil
+}
+
+func (s *streamChannel) UnsubscribeTopic(topic string, userIds []string) error {
+	_, err := s.c.call("stream.unsubscribeTopic", &params{Channel: s.name, Topic: topic, Users: userIds})
+	return err
+}
+
+func (s *streamChannel) GetSubscribedUsers(topic string) ([]string, error) {
+	res, err := s.c.call("stream.getSubscribedUsers", &params{Channel: s.name, Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+	return res.Users, nil
+}
+
+func (s *streamChannel) RenewToken(token string) error {
+	_, err := s.c.call("stream.renewToken", &params{Channel: s.name, Token: token})
+	return err
+}
@@ -0,0 +1,230 @@
+package memrtm
+
+import (
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+type client struct {
+	hub    *Hub
+	config rtm2.RTMConfig
+	lg     *zap.Logger
+
+	state  int32 // see ConnectionState in consts.go
+	token  string
+	params map[string]interface{}
+	conn   *connectionBox
+	tokens *stringBox
+
+	storage  *storage
+	locks    *locks
+	presence *presence
+
+	streams     map[string]*streamChannel
+	memberships map[chanKey]*member
+	cached      map[chanKey]map[string]string // presence state set by SetState
+	lost        []chanKey                     // channels timed out during interruption
+}
+
+// check must be called with hub locked.
+func (c *client) check() error {
+	if c.state != rtm2.ConnectionStateCONNECTED {
+		return rtm2.ERR_NOT_LOGIN
+	}
+	return nil
+}
+
+func (c *client) Login(token string) (_ <-chan *rtm2.ConnectionEvent, _ <-chan string, err error) {
+	defer wrapError(&err, "Login", "", "")
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c.state != rtm2.ConnectionStateDISCONNECTED {
+		return nil, nil, rtm2.ERR_ALREADY_LOGIN
+	}
+	if old, ok := h.clients[c.config.UserId]; ok {
+		old.conn.send(&rtm2.ConnectionEvent{State: rtm2.ConnectionStateFAILED, Reason: rtm2.ConnectionChangedReasonSameUidLogin})
+		h.logout(old)
+	}
+	if c.conn != nil {
+		c.conn.Close()
+		c.tokens.Close()
+	}
+	c.conn = newConnectionBox()
+	c.tokens = newStringBox()
+	c.token = token
+	c.state = rtm2.ConnectionStateCONNECTED
+	h.clients[c.config.UserId] = c
+	c.lg.Info("login")
+	c.conn.send(&rtm2.ConnectionEvent{State: rtm2.ConnectionStateCONNECTED, Reason: rtm2.ConnectionChangedReasonLoginSuccess})
+	return c.conn.ch, c.tokens.ch, nil
+}
+
+func (c *client) Logout() (err error) {
+	defer wrapError(&err, "Logout", "", "")
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c.state == rtm2.ConnectionStateDISCONNECTED {
+		return rtm2.ERR_NOT_LOGIN
+	}
+	h.logout(c)
+	return nil
+}
+
+// logout must be called with hub locked.
+func (h *Hub) logout(c *client) {
+	userId := c.config.UserId
+	for _, m := range c.memberships {
+		h.leave(m)
+	}
+	c.lost = nil
+	for _, ch := range h.channels {
+		for _, l := range ch.locks {
+			ch.cancelWait(l, userId, rtm2.ERR_NOT_LOGIN)
+			if l.owner == userId {
+				ch.releaseLock(l, rtm2.LockTypeReleased)
+			}
+		}
+	}
+	for target, subs := range h.userSubs {
+		if box, ok := subs[c]; ok {
+			box.Close()
+			delete(subs, c)
+		}
+		if len(subs) == 0 {
+			delete(h.userSubs, target)
+		}
+	}
+	c.state = rtm2.ConnectionStateDISCONNECTED
+	if h.clients[userId] == c {
+		delete(h.clients, userId)
+	}
+	c.lg.Info("logout")
+}
+
+func (c *client) SetParameters(params map[string]interface{}) (err error) {
+	defer wrapError(&err, "SetParameters", "", "")
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	for k, v := range params {
+		c.params[k] = v
+	}
+	return nil
+}
+
+func (c *client) GetParameters() map[string]interface{} {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	params := make(map[string]interface{}, len(c.params))
+	for k, v := range c.params {
+		params[k] = v
+	}
+	return params
+}
+
+func (c *client) RenewToken(token string) (err error) {
+	defer wrapError(&err, "RenewToken", "", "")
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	if err := c.check(); err != nil {
+		return err
+	}
+	if token == c.token {
+		return rtm2.ERR_DUPLICATE_TOKEN
+	}
+	c.token = token
+	return nil
+}
+
+func (c *client) Storage() rtm2.Storage {
+	return c.storage
+}
+
+func (c *client) Lock() rtm2.Lock {
+	return c.locks
+}
+
+func (c *client) Presence() rtm2.Presence {
+	return c.presence
+}
+
+func (c *client) Publish(channel string, message []byte, opts ...rtm2.MessageOption) (err error) {
+	defer wrapError(&err, "Publish", channel, "")
+	o := rtm2.DefaultMessageOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := c.check(); err != nil {
+		return err
+	}
+	ch, ok := h.channels[chanKey{name: channel, channelType: rtm2.ChannelTypeMessage}]
+	if !ok {
+		return nil
+	}
+	for userId, m := range ch.members {
+		if userId == c.config.UserId || !m.message || m.c.state != rtm2.ConnectionStateCONNECTED {
+			continue
+		}
+		m.messages.send(&rtm2.Message{UserId: c.config.UserId, Type: o.Type, Message: append([]byte(nil), message...)})
+	}
+	return nil
+}
+
+func (c *client) Subscribe(channel string, opts ...rtm2.MessageOption) (_ chan *rtm2.Message, err error) {
+	defer wrapError(&err, "Subscribe", channel, "")
+	o := rtm2.DefaultMessageOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	key := chanKey{name: channel, channelType: rtm2.ChannelTypeMessage}
+	if _, ok := c.memberships[key]; ok {
+		return nil, rtm2.ERR_ALREADY_SUBSCRIBED
+	}
+	if len(c.memberships) >= rtm2.ChannelMaxCount {
+		return nil, rtm2.ERR_EXCEED_CHANNEL_LIMITATION
+	}
+	m := h.join(c, key, o.Message, o.Metadata, o.Presence, o.Lock)
+	return m.messages.ch, nil
+}
+
+func (c *client) Unsubscribe(channel string) (err error) {
+	defer wrapError(&err, "Unsubscribe", channel, "")
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := c.check(); err != nil {
+		return err
+	}
+	m, ok := c.memberships[chanKey{name: channel, channelType: rtm2.ChannelTypeMessage}]
+	if !ok {
+		return rtm2.ERR_NOT_SUBSCRIBED
+	}
+	h.leave(m)
+	return nil
+}
+
+func (c *client) StreamChannel(channel string) rtm2.StreamChannel {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	s, ok := c.streams[channel]
+	if !ok {
+		s = &streamChannel{c: c, name: channel}
+		c.streams[channel] = s
+	}
+	return s
+}
+
+// wrapError adds the operation context to *err, same as the errors of localrtm.
+func wrapError(err *error, op, channel, topic string) {
+	*err = rtm2.WrapError(*err, op, channel, topic)
+}
@@ -0,0 +1,418 @@
+// Package memrtm is an in-process implementation of the rtm2 interfaces for offline development and tests.
+// All clients created from the same Hub share Message Channels, Stream Channels, metadata, locks and presence.
+package memrtm
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+type HubOptions struct {
+	// PresenceInterval enables interval mode once a channel has more than PresenceIntervalThreshold users.
+	// Join / Leave / Timeout / StateChange events are then merged into one PresenceTypeInterval event per interval.
+	PresenceInterval          time.Duration
+	PresenceIntervalThreshold int
+	// WhoNowPageSize is the max count of users returned by a single WhoNow call.
+	WhoNowPageSize int
+}
+
+type HubOption func(*HubOptions)
+
+// WithPresenceInterval enables interval mode on channels with more than threshold users.
+func WithPresenceInterval(interval time.Duration, threshold int) HubOption {
+	return func(o *HubOptions) {
+		o.PresenceInterval = interval
+		o.PresenceIntervalThreshold = threshold
+	}
+}
+
+// WithWhoNowPageSize sets the page size of WhoNow, 100 by default.
+func WithWhoNowPageSize(size int) HubOption {
+	return func(o *HubOptions) {
+		o.WhoNowPageSize = size
+	}
+}
+
+type chanKey struct {
+	name        string
+	channelType rtm2.ChannelType
+}
+
+// Hub is the shared in-memory rtm service.
+type Hub struct {
+	mu       sync.Mutex
+	opts     HubOptions
+	clients  map[string]*client // logged in clients by user id
+	channels map[chanKey]*channel
+	users    map[string]*metaStore // user metadata
+	userSubs map[string]map[*client]*storageBox
+}
+
+// NewHub creates an empty in-memory rtm service.
+func NewHub(opts ...HubOption) *Hub {
+	o := HubOptions{WhoNowPageSize: 100}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Hub{
+		opts:     o,
+		clients:  make(map[string]*client),
+		channels: make(map[chanKey]*channel),
+		users:    make(map[string]*metaStore),
+		userSubs: make(map[string]map[*client]*storageBox),
+	}
+}
+
+// CreateRTMClient creates a client connected to this hub.
+func (h *Hub) CreateRTMClient(config *rtm2.RTMConfig) rtm2.RTMClient {
+	lg := config.Logger
+	if lg == nil {
+		lg = zap.NewNop()
+	}
+	c := &client{
+		hub:         h,
+		config:      *config,
+		lg:          lg.With(zap.String("user", config.UserId)),
+		state:       rtm2.ConnectionStateDISCONNECTED,
+		params:      make(map[string]interface{}),
+		streams:     make(map[string]*streamChannel),
+		memberships: make(map[chanKey]*member),
+		cached:      make(map[chanKey]map[string]string),
+	}
+	c.storage = &storage{c: c}
+	c.locks = &locks{c: c}
+	c.presence = &presence{c: c}
+	return c
+}
+
+// Interrupt simulates a network loss of certain user.
+// Presence of the user times out after RTMConfig.PresenceTimeout, and locks owned expire after their TTL.
+func (h *Hub) Interrupt(userId string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c, ok := h.clients[userId]; ok {
+		h.interrupt(c)
+	}
+}
+
+// InterruptClient is same as Interrupt, but only takes effect if the client is still the logged in one of its user.
+func (h *Hub) InterruptClient(rtmClient rtm2.RTMClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c, ok := rtmClient.(*client); ok && h.clients[c.config.UserId] == c {
+		h.interrupt(c)
+	}
+}
+
+func (h *Hub) interrupt(c *client) {
+	if c.state != rtm2.ConnectionStateCONNECTED {
+		return
+	}
+	userId := c.config.UserId
+	c.lg.Info("interrupted")
+	c.state = rtm2.ConnectionStateRECONNECTING
+	c.conn.send(&rtm2.ConnectionEvent{State: rtm2.ConnectionStateRECONNECTING, Reason: rtm2.ConnectionChangedReasonInterrupted})
+	for key, m := range c.memberships {
+		c.conn.send(&rtm2.ConnectionEvent{State: rtm2.ConnectionStateRECONNECTING, Reason: rtm2.ConnectionChangedReasonInterrupted, Channel: key.name})
+		if c.config.PresenceTimeout == 0 {
+			h.timeoutMember(m)
+			continue
+		}
+		m := m
+		m.timeout = time.AfterFunc(time.Duration(c.config.PresenceTimeout)*time.Second, func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if c.state == rtm2.ConnectionStateRECONNECTING && c.memberships[m.ch.key] == m {
+				h.timeoutMember(m)
+			}
+		})
+	}
+	for _, ch := range h.channels {
+		for _, l := range ch.locks {
+			if l.owner == userId {
+				h.scheduleExpiry(ch, l)
+			}
+		}
+	}
+}
+
+// Restore simulates the recovery of certain user after Interrupt.
+// Channels whose presence has timed out are reported with ConnectionStateFAILED and need to be joined again.
+func (h *Hub) Restore(userId string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.clients[userId]
+	if !ok || c.state != rtm2.ConnectionStateRECONNECTING {
+		return
+	}
+	c.lg.Info("restored")
+	c.state = rtm2.ConnectionStateCONNECTED
+	for _, ch := range h.channels {
+		for _, l := range ch.locks {
+			if l.owner == userId && l.expiry != nil {
+				l.expiry.Stop()
+				l.expiry = nil
+			}
+		}
+	}
+	c.conn.send(&rtm2.ConnectionEvent{State: rtm2.ConnectionStateCONNECTED, Reason: rtm2.ConnectionChangedReasonRejoinSuccess})
+	for key, m := range c.memberships {
+		if m.timeout != nil {
+			m.timeout.Stop()
+			m.timeout = nil
+		}
+		c.conn.send(&rtm2.ConnectionEvent{State: rtm2.ConnectionStateCONNECTED, Reason: rtm2.ConnectionChangedReasonRejoinSuccess, Channel: key.name})
+		if m.presence {
+			m.presences.send(&rtm2.PresenceEvent{Type: rtm2.PresenceTypeSnapshot, States: m.ch.states()})
+		}
+		if m.lock {
+			m.locks.send(&rtm2.LockEvent{Type: rtm2.LockTypeSnapshot, Details: m.ch.lockDetails()})
+		}
+	}
+	for _, key := range c.lost {
+		c.conn.send(&rtm2.ConnectionEvent{State: rtm2.ConnectionStateFAILED, Reason: rtm2.ConnectionChangedReasonJoinFailed, Channel: key.name})
+	}
+	c.lost = nil
+}
+
+// ExpireToken notifies certain user that the token will expire.
+// Empty channel stands for the RTM token, otherwise the Stream Channel token.
+func (h *Hub) ExpireToken(userId string, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.clients[userId]
+	if !ok {
+		return
+	}
+	c.tokens.send(channel)
+	if channel == "" {
+		return
+	}
+	if m, ok := c.memberships[chanKey{name: channel, channelType: rtm2.ChannelTypeStream}]; ok {
+		m.tokens.send(channel)
+	}
+}
+
+// PresenceOutOfService notifies all presence subscribers of certain channel that presence service is unavailable.
+func (h *Hub) PresenceOutOfService(channel string, channelType rtm2.ChannelType) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch, ok := h.channels[chanKey{name: channel, channelType: channelType}]
+	if !ok {
+		return
+	}
+	for _, m := range ch.members {
+		if m.presence {
+			m.presences.send(&rtm2.PresenceEvent{Type: rtm2.PresenceTypeOutOfService})
+		}
+	}
+}
+
+func (h *Hub) channel(name string, channelType rtm2.ChannelType) *channel {
+	key := chanKey{name: name, channelType: channelType}
+	ch, ok := h.channels[key]
+	if !ok {
+		ch = &channel{
+			hub:     h,
+			key:     key,
+			members: make(map[string]*member),
+			meta:    newMetaStore(),
+			locks:   make(map[string]*lockState),
+			topics:  make(map[string]map[string]bool),
+		}
+		h.channels[key] = ch
+	}
+	return ch
+}
+
+type channel struct {
+	hub     *Hub
+	key     chanKey
+	members map[string]*member
+	meta    *metaStore
+	locks   map[string]*lockState
+	topics  map[string]map[string]bool // topic -> publishers
+	pending *rtm2.PresenceEvent        // merged events in interval mode
+}
+
+type member struct {
+	c        *client
+	ch       *channel
+	message  bool
+	metadata bool
+	presence bool
+	lock     bool
+	state    map[string]string
+	timeout  *time.Timer
+
+	messages  *messageBox
+	storages  *storageBox
+	locks     *lockBox
+	presences *presenceBox
+
+	// Stream Channel only
+	token       string
+	topicEvents *topicBox
+	tokens      *stringBox
+	topics      map[string]bool // joined topics
+	subs        map[string]*topicSub
+}
+
+type topicSub struct {
+	all   bool // follow every publisher, including the ones joined later
+	users map[string]bool
+	box   *messageBox
+}
+
+func (m *member) close() {
+	if m.timeout != nil {
+		m.timeout.Stop()
+	}
+	m.messages.Close()
+	m.storages.Close()
+	m.locks.Close()
+	m.presences.Close()
+	m.topicEvents.Close()
+	m.tokens.Close()
+	for _, sub := range m.subs {
+		sub.box.Close()
+	}
+}
+
+func (h *Hub) join(c *client, key chanKey, message, metadata, presence, lock bool) *member {
+	ch := h.channel(key.name, key.channelType)
+	m := &member{
+		c:           c,
+		ch:          ch,
+		message:     message,
+		metadata:    metadata,
+		presence:    presence,
+		lock:        lock,
+		state:       copyState(c.cached[key]),
+		messages:    newMessageBox(),
+		storages:    newStorageBox(),
+		locks:       newLockBox(),
+		presences:   newPresenceBox(),
+		topicEvents: newTopicBox(),
+		tokens:      newStringBox(),
+		topics:      make(map[string]bool),
+		subs:        make(map[string]*topicSub),
+	}
+	ch.members[c.config.UserId] = m
+	c.memberships[key] = m
+	ch.notifyPresence(c.config.UserId, &rtm2.PresenceEvent{Type: rtm2.PresenceTypeJoinChannel, UserId: c.config.UserId, Items: copyState(m.state)})
+	c.conn.send(&rtm2.ConnectionEvent{State: rtm2.ConnectionStateCONNECTED, Reason: rtm2.ConnectionChangedReasonJoinSuccess, Channel: key.name})
+	return m
+}
+
+func (h *Hub) leave(m *member) {
+	h.remove(m, rtm2.PresenceTypeLeaveChannel)
+	m.c.conn.send(&rtm2.ConnectionEvent{State: rtm2.ConnectionStateDISCONNECTED, Reason: rtm2.ConnectionChangedReasonLeaveChannel, Channel: m.ch.key.name})
+}
+
+func (h *Hub) timeoutMember(m *member) {
+	h.remove(m, rtm2.PresenceTypeTimeout)
+	m.c.lost = append(m.c.lost, m.ch.key)
+}
+
+func (h *Hub) remove(m *member, reason rtm2.PresenceEventType) {
+	userId := m.c.config.UserId
+	ch := m.ch
+	for topic := range m.topics {
+		ch.leaveTopic(m, topic)
+	}
+	delete(ch.members, userId)
+	delete(m.c.memberships, ch.key)
+	m.close()
+	ch.notifyPresence(userId, &rtm2.PresenceEvent{Type: reason, UserId: userId})
+}
+
+func (ch *channel) notifyPresence(except string, e *rtm2.PresenceEvent) {
+	opts := ch.hub.opts
+	if opts.PresenceInterval > 0 && len(ch.members) > opts.PresenceIntervalThreshold {
+		ch.mergePresence(e)
+		return
+	}
+	for userId, m := range ch.members {
+		if userId != except && m.presence {
+			m.presences.send(e)
+		}
+	}
+}
+
+func (ch *channel) mergePresence(e *rtm2.PresenceEvent) {
+	if ch.pending == nil {
+		ch.pending = &rtm2.PresenceEvent{Type: rtm2.PresenceTypeInterval, States: make(map[string]map[string]string)}
+		time.AfterFunc(ch.hub.opts.PresenceInterval, func() {
+			ch.hub.mu.Lock()
+			defer ch.hub.mu.Unlock()
+			ch.flushPresence()
+		})
+	}
+	p := ch.pending
+	switch e.Type {
+	case rtm2.PresenceTypeJoinChannel:
+		p.Joined = append(p.Joined, e.UserId)
+		if len(e.Items) > 0 {
+			p.States[e.UserId] = e.Items
+		}
+	case rtm2.PresenceTypeLeaveChannel:
+		p.Left = append(p.Left, e.UserId)
+		delete(p.States, e.UserId)
+	case rtm2.PresenceTypeTimeout:
+		p.Timeout = append(p.Timeout, e.UserId)
+		delete(p.States, e.UserId)
+	case rtm2.PresenceTypeStateChange:
+		p.States[e.UserId] = e.Items
+	}
+}
+
+func (ch *channel) flushPresence() {
+	p := ch.pending
+	ch.pending = nil
+	if p == nil {
+		return
+	}
+	for _, m := range ch.members {
+		if m.presence {
+			m.presences.send(p)
+		}
+	}
+}
+
+func (ch *channel) states() map[string]map[string]string {
+	states := make(map[string]map[string]string, len(ch.members))
+	for userId, m := range ch.members {
+		states[userId] = copyState(m.state)
+	}
+	return states
+}
+
+func (ch *channel) userStates() map[string]*rtm2.UserState {
+	users := make(map[string]*rtm2.UserState, len(ch.members))
+	for userId, m := range ch.members {
+		users[userId] = &rtm2.UserState{UserId: userId, State: copyState(m.state)}
+	}
+	return users
+}
+
+func (ch *channel) sortedMembers() []string {
+	userIds := make([]string, 0, len(ch.members))
+	for userId := range ch.members {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+	return userIds
+}
+
+func copyState(state map[string]string) map[string]string {
+	res := make(map[string]string, len(state))
+	for k, v := range state {
+		res[k] = v
+	}
+	return res
+}
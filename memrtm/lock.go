@@ -0,0 +1,285 @@
+package memrtm
+
+import (
+	"sort"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+// lockState keeps a lock of a channel. TTL starts counting once the owner is disconnected.
+type lockState struct {
+	name    string
+	ttl     uint32
+	owner   string
+	waiters []*lockWaiter
+	expiry  *time.Timer
+}
+
+type lockWaiter struct {
+	userId string
+	result chan error
+}
+
+func (l *lockState) detail(owner string) *rtm2.LockDetail {
+	return &rtm2.LockDetail{Name: l.name, Owner: owner, TTL: l.ttl}
+}
+
+func (ch *channel) lockDetails() []*rtm2.LockDetail {
+	names := make([]string, 0, len(ch.locks))
+	for name := range ch.locks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	details := make([]*rtm2.LockDetail, 0, len(names))
+	for _, name := range names {
+		l := ch.locks[name]
+		details = append(details, l.detail(l.owner))
+	}
+	return details
+}
+
+func (ch *channel) notifyLock(t rtm2.LockEventType, details ...*rtm2.LockDetail) {
+	for _, m := range ch.members {
+		if m.lock {
+			m.locks.send(&rtm2.LockEvent{Type: t, Details: details})
+		}
+	}
+}
+
+// releaseLock notifies the previous owner with t and hands the lock over to the first waiter.
+func (ch *channel) releaseLock(l *lockState, t rtm2.LockEventType) {
+	if l.expiry != nil {
+		l.expiry.Stop()
+		l.expiry = nil
+	}
+	owner := l.owner
+	l.owner = ""
+	ch.notifyLock(t, l.detail(owner))
+	if len(l.waiters) == 0 {
+		return
+	}
+	w := l.waiters[0]
+	l.waiters = l.waiters[1:]
+	ch.grantLock(l, w.userId)
+	w.result <- nil
+}
+
+func (ch *channel) grantLock(l *lockState, userId string) {
+	l.owner = userId
+	ch.notifyLock(rtm2.LockTypeAcquired, l.detail(userId))
+	if c, ok := ch.hub.clients[userId]; !ok || c.state != rtm2.ConnectionStateCONNECTED {
+		ch.hub.scheduleExpiry(ch, l)
+	}
+}
+
+// cancelWait drops the pending Acquire of userId. Its chan receives err before being closed,
+// or is only closed for a nil err, as by Release.
+func (ch *channel) cancelWait(l *lockState, userId string, err error) bool {
+	for i, w := range l.waiters {
+		if w.userId == userId {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			if err != nil {
+				w.result <- rtm2.WrapError(err, "Lock.Acquire", ch.key.name, "")
+			}
+			close(w.result)
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hub) scheduleExpiry(ch *channel, l *lockState) {
+	if l.expiry != nil {
+		return
+	}
+	owner := l.owner
+	l.expiry = time.AfterFunc(time.Duration(l.ttl)*time.Second, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if l.owner == owner && ch.locks[l.name] == l {
+			l.expiry = nil
+			ch.releaseLock(l, rtm2.LockTypeExpired)
+		}
+	})
+}
+
+type locks struct {
+	c *client
+}
+
+// GetLockChan returns the snapshot of locks and the chan of LockEvent.
+// For Released and Expired events, LockDetail.Owner is the user who lost the lock.
+func (l *locks) GetLockChan(channel string, channelType rtm2.ChannelType) (_ map[string]*rtm2.LockDetail, _ <-chan *rtm2.LockEvent, err error) {
+	defer wrapError(&err, "Lock.GetLockChan", channel, "")
+	h := l.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := l.c.check(); err != nil {
+		return nil, nil, err
+	}
+	m, ok := l.c.memberships[chanKey{name: channel, channelType: channelType}]
+	if !ok || !m.lock {
+		return nil, nil, rtm2.ERR_NOT_SUBSCRIBED
+	}
+	return m.ch.lockMap(), m.locks.ch, nil
+}
+
+func (ch *channel) lockMap() map[string]*rtm2.LockDetail {
+	res := make(map[string]*rtm2.LockDetail, len(ch.locks))
+	for name, l := range ch.locks {
+		res[name] = l.detail(l.owner)
+	}
+	return res
+}
+
+// Set creates a lock, or updates the TTL if the lock exists.
+func (l *locks) Set(channel string, channelType rtm2.ChannelType, name string, ttl uint32) (err error) {
+	defer wrapError(&err, "Lock.Set", channel, "")
+	h := l.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := l.c.check(); err != nil {
+		return err
+	}
+	ch := h.channel(channel, channelType)
+	ls, ok := ch.locks[name]
+	if !ok {
+		ls = &lockState{name: name}
+		ch.locks[name] = ls
+	}
+	ls.ttl = ttl
+	ch.notifyLock(rtm2.LockTypeSet, ls.detail(ls.owner))
+	return nil
+}
+
+func (l *locks) Get(channel string, channelType rtm2.ChannelType) (_ map[string]*rtm2.LockDetail, err error) {
+	defer wrapError(&err, "Lock.Get", channel, "")
+	h := l.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := l.c.check(); err != nil {
+		return nil, err
+	}
+	ch, ok := h.channels[chanKey{name: channel, channelType: channelType}]
+	if !ok {
+		return make(map[string]*rtm2.LockDetail), nil
+	}
+	return ch.lockMap(), nil
+}
+
+// Remove deletes a lock. Pending Acquire calls fail with ERR_LOCK_OPERATION_PERFORMING.
+func (l *locks) Remove(channel string, channelType rtm2.ChannelType, name string) (err error) {
+	defer wrapError(&err, "Lock.Remove", channel, "")
+	h := l.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := l.c.check(); err != nil {
+		return err
+	}
+	ch, ok := h.channels[chanKey{name: channel, channelType: channelType}]
+	if !ok {
+		return rtm2.ERR_LOCK_OPERATION_PERFORMING
+	}
+	ls, ok := ch.locks[name]
+	if !ok {
+		return rtm2.ERR_LOCK_OPERATION_PERFORMING
+	}
+	if ls.expiry != nil {
+		ls.expiry.Stop()
+	}
+	for _, w := range ls.waiters {
+		w.result <- rtm2.ERR_LOCK_OPERATION_PERFORMING
+	}
+	delete(ch.locks, name)
+	ch.notifyLock(rtm2.LockTypeRemove, ls.detail(ls.owner))
+	return nil
+}
+
+// Acquire fails with ERR_LOCK_OPERATION_PERFORMING if the lock does not exist,
+// or if it is owned by another user and retry is not set.
+// With retry, the chan receives nil once the lock is handed over, or is closed by Release.
+// A pending Acquire receives ERR_NOT_LOGIN on Logout, and ERR_LOCK_OPERATION_PERFORMING if acquired again.
+func (l *locks) Acquire(channel string, channelType rtm2.ChannelType, name string, retry bool) <-chan error {
+	result := make(chan error, 1)
+	fail := func(err error) {
+		result <- rtm2.WrapError(err, "Lock.Acquire", channel, "")
+	}
+	h := l.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := l.c.check(); err != nil {
+		fail(err)
+		return result
+	}
+	userId := l.c.config.UserId
+	ch, ok := h.channels[chanKey{name: channel, channelType: channelType}]
+	if !ok {
+		fail(rtm2.ERR_LOCK_OPERATION_PERFORMING)
+		return result
+	}
+	ls, ok := ch.locks[name]
+	switch {
+	case !ok:
+		fail(rtm2.ERR_LOCK_OPERATION_PERFORMING)
+	case ls.owner == "":
+		ch.grantLock(ls, userId)
+		result <- nil
+	case ls.owner == userId:
+		result <- nil
+	case retry:
+		ch.cancelWait(ls, userId, rtm2.ERR_LOCK_OPERATION_PERFORMING)
+		ls.waiters = append(ls.waiters, &lockWaiter{userId: userId, result: result})
+	default:
+		fail(rtm2.ERR_LOCK_OPERATION_PERFORMING)
+	}
+	return result
+}
+
+// Release releases an acquired lock, or cancels a pending Acquire with retry.
+func (l *locks) Release(channel string, channelType rtm2.ChannelType, name string) (err error) {
+	defer wrapError(&err, "Lock.Release", channel, "")
+	h := l.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := l.c.check(); err != nil {
+		return err
+	}
+	ch, ok := h.channels[chanKey{name: channel, channelType: channelType}]
+	if !ok {
+		return rtm2.ERR_RELEASE_LOCK_NOT_ACQUIRED
+	}
+	ls, ok := ch.locks[name]
+	if !ok {
+		return rtm2.ERR_RELEASE_LOCK_NOT_ACQUIRED
+	}
+	userId := l.c.config.UserId
+	if ls.owner == userId {
+		ch.releaseLock(ls, rtm2.LockTypeReleased)
+		return nil
+	}
+	if ch.cancelWait(ls, userId, nil) {
+		return nil
+	}
+	return rtm2.ERR_RELEASE_LOCK_NOT_ACQUIRED
+}
+
+func (l *locks) Revoke(channel string, channelType rtm2.ChannelType, name string, owner string) (err error) {
+	defer wrapError(&err, "Lock.Revoke", channel, "")
+	h := l.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := l.c.check(); err != nil {
+		return err
+	}
+	ch, ok := h.channels[chanKey{name: channel, channelType: channelType}]
+	if !ok {
+		return rtm2.ERR_RELEASE_LOCK_NOT_ACQUIRED
+	}
+	ls, ok := ch.locks[name]
+	if !ok || ls.owner == "" || ls.owner != owner {
+		return rtm2.ERR_RELEASE_LOCK_NOT_ACQUIRED
+	}
+	ch.releaseLock(ls, rtm2.LockTypeReleased)
+	return nil
+}
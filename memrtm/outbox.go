@@ -0,0 +1,132 @@
+package memrtm
+
+import (
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+)
+
+type messageBox struct {
+	*outbox.Outbox
+	ch chan *rtm2.Message
+}
+
+func newMessageBox() *messageBox {
+	return &messageBox{Outbox: outbox.New(), ch: make(chan *rtm2.Message)}
+}
+
+func (b *messageBox) send(m *rtm2.Message) {
+	b.Push(func(done <-chan struct{}) {
+		select {
+		case b.ch <- m:
+		case <-done:
+		}
+	})
+}
+
+type connectionBox struct {
+	*outbox.Outbox
+	ch chan *rtm2.ConnectionEvent
+}
+
+func newConnectionBox() *connectionBox {
+	return &connectionBox{Outbox: outbox.New(), ch: make(chan *rtm2.ConnectionEvent)}
+}
+
+func (b *connectionBox) send(e *rtm2.ConnectionEvent) {
+	b.Push(func(done <-chan struct{}) {
+		select {
+		case b.ch <- e:
+		case <-done:
+		}
+	})
+}
+
+type stringBox struct {
+	*outbox.Outbox
+	ch chan string
+}
+
+func newStringBox() *stringBox {
+	return &stringBox{Outbox: outbox.New(), ch: make(chan string)}
+}
+
+func (b *stringBox) send(s string) {
+	b.Push(func(done <-chan struct{}) {
+		select {
+		case b.ch <- s:
+		case <-done:
+		}
+	})
+}
+
+type storageBox struct {
+	*outbox.Outbox
+	ch chan *rtm2.StorageEvent
+}
+
+func newStorageBox() *storageBox {
+	return &storageBox{Outbox: outbox.New(), ch: make(chan *rtm2.StorageEvent)}
+}
+
+func (b *storageBox) send(e *rtm2.StorageEvent) {
+	b.Push(func(done <-chan struct{}) {
+		select {
+		case b.ch <- e:
+		case <-done:
+		}
+	})
+}
+
+type lockBox struct {
+	*outbox.Outbox
+	ch chan *rtm2.LockEvent
+}
+
+func newLockBox() *lockBox {
+	return &lockBox{Outbox: outbox.New(), ch: make(chan *rtm2.LockEvent)}
+}
+
+func (b *lockBox) send(e *rtm2.LockEvent) {
+	b.Push(func(done <-chan struct{}) {
+		select {
+		case b.ch <- e:
+		case <-done:
+		}
+	})
+}
+
+type presenceBox struct {
+	*outbox.Outbox
+	ch chan *rtm2.PresenceEvent
+}
+
+func newPresenceBox() *presenceBox {
+	return &presenceBox{Outbox: outbox.New(), ch: make(chan *rtm2.PresenceEvent)}
+}
+
+func (b *presenceBox) send(e *rtm2.PresenceEvent) {
+	b.Push(func(done <-chan struct{}) {
+		select {
+		case b.ch <- e:
+		case <-done:
+		}
+	})
+}
+
+type topicBox struct {
+	*outbox.Outbox
+	ch chan *rtm2.TopicEvent
+}
+
+func newTopicBox() *topicBox {
+	return &topicBox{Outbox: outbox.New(), ch: make(chan *rtm2.TopicEvent)}
+}
+
+func (b *topicBox) send(e *rtm2.TopicEvent) {
+	b.Push(func(done <-chan struct{}) {
+		select {
+		case b.ch <- e:
+		case <-done:
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package memrtm
+
+import (
+	"sort"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type presence struct {
+	c *client
+}
+
+func (p *presence) GetPresenceChan(channel string, channelType rtm2.ChannelType) (_ map[string]*rtm2.UserState, _ <-chan *rtm2.PresenceEvent, err error) {
+	defer wrapError(&err, "Presence.GetPresenceChan", channel, "")
+	h := p.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := p.c.check(); err != nil {
+		return nil, nil, err
+	}
+	m, ok := p.c.memberships[chanKey{name: channel, channelType: channelType}]
+	if !ok {
+		return nil, nil, rtm2.ERR_PRESENCE_OPERATION_WITHOUT_JOIN_CHANNEL
+	}
+	if !m.presence {
+		return nil, nil, rtm2.ERR_NOT_SUBSCRIBED
+	}
+	return m.ch.userStates(), m.presences.ch, nil
+}
+
+// WhoNow uses the first user id of the next page as the page index.
+func (p *presence) WhoNow(channel string, channelType rtm2.ChannelType, opts ...rtm2.PresenceOption) (_ map[string]*rtm2.UserState, _ string, err error) {
+	defer wrapError(&err, "Presence.WhoNow", channel, "")
+	o := &rtm2.PresenceOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	h := p.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := p.c.check(); err != nil {
+		return nil, "", err
+	}
+	users := make(map[string]*rtm2.UserState)
+	ch, ok := h.channels[chanKey{name: channel, channelType: channelType}]
+	if !ok {
+		return users, "", nil
+	}
+	userIds := ch.sortedMembers()
+	start := sort.SearchStrings(userIds, o.Page)
+	end := start + h.opts.WhoNowPageSize
+	next := ""
+	if end < len(userIds) {
+		next = userIds[end]
+	} else {
+		end = len(userIds)
+	}
+	for _, userId := range userIds[start:end] {
+		user := &rtm2.UserState{UserId: userId}
+		if o.State {
+			user.State = copyState(ch.members[userId].state)
+		}
+		users[userId] = user
+	}
+	return users, next, nil
+}
+
+func (p *presence) WhereNow(userId string) (_ []*rtm2.ChannelInfo, err error) {
+	defer wrapError(&err, "Presence.WhereNow", "", "")
+	h := p.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := p.c.check(); err != nil {
+		return nil, err
+	}
+	target, ok := h.clients[userId]
+	if !ok {
+		return nil, rtm2.ERR_PRESENCE_USER_NOT_EXIST
+	}
+	channels := make([]*rtm2.ChannelInfo, 0, len(target.memberships))
+	for key := range target.memberships {
+		channels = append(channels, &rtm2.ChannelInfo{Channel: key.name, Type: key.channelType})
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		if channels[i].Channel != channels[j].Channel {
+			return channels[i].Channel < channels[j].Channel
+		}
+		return channels[i].Type < channels[j].Type
+	})
+	return channels, nil
+}
+
+func (p *presence) SetState(channel string, channelType rtm2.ChannelType, data map[string]string) (err error) {
+	defer wrapError(&err, "Presence.SetState", channel, "")
+	h := p.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := p.c.check(); err != nil {
+		return err
+	}
+	key := chanKey{name: channel, channelType: channelType}
+	state := copyState(p.c.cached[key])
+	for k, v := range data {
+		if k == "" {
+			return rtm2.ERR_PRESENCE_STATE_INVALID_KEY
+		}
+		if len(k) > rtm2.PresenceStateKeyMaxSize {
+			return rtm2.ERR_PRESENCE_STATE_KEY_SIZE_OVERFLOW
+		}
+		if len(v) > rtm2.PresenceStateValueMaxSize {
+			return rtm2.ERR_PRESENCE_STATE_VALUE_SIZE_OVERFLOW
+		}
+		state[k] = v
+	}
+	size := 0
+	for k, v := range state {
+		size += len(k) + len(v)
+	}
+	if size > rtm2.PresenceStateMaxSize {
+		return rtm2.ERR_PRESENCE_STATE_SIZE_OVERFLOW
+	}
+	p.c.cached[key] = state
+	p.changeState(key, state)
+	return nil
+}
+
+func (p *presence) RemoveState(channel string, channelType rtm2.ChannelType, keys []string) (err error) {
+	defer wrapError(&err, "Presence.RemoveState", channel, "")
+	h := p.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := p.c.check(); err != nil {
+		return err
+	}
+	key := chanKey{name: channel, channelType: channelType}
+	state := copyState(p.c.cached[key])
+	for _, k := range keys {
+		delete(state, k)
+	}
+	p.c.cached[key] = state
+	p.changeState(key, state)
+	return nil
+}
+
+func (p *presence) changeState(key chanKey, state map[string]string) {
+	m, ok := p.c.memberships[key]
+	if !ok {
+		return
+	}
+	m.state = copyState(state)
+	userId := p.c.config.UserId
+	m.ch.notifyPresence(userId, &rtm2.PresenceEvent{Type: rtm2.PresenceTypeStateChange, UserId: userId, Items: copyState(state)})
+}
+
+func (p *presence) GetState(channel string, channelType rtm2.ChannelType, userId string) (_ map[string]string, err error) {
+	defer wrapError(&err, "Presence.GetState", channel, "")
+	h := p.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := p.c.check(); err != nil {
+		return nil, err
+	}
+	ch, ok := h.channels[chanKey{name: channel, channelType: channelType}]
+	if !ok {
+		return nil, rtm2.ERR_PRESENCE_USER_NOT_EXIST
+	}
+	m, ok := ch.members[userId]
+	if !ok {
+		return nil, rtm2.ERR_PRESENCE_USER_NOT_EXIST
+	}
+	return copyState(m.state), nil
+}
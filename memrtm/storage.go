@@ -0,0 +1,276 @@
+package memrtm
+
+import (
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+const (
+	opSet = iota
+	opUpdate
+	opRemove
+)
+
+// metaStore keeps metadata items of a channel or a user.
+// Every successful write increases the major revision by one,
+// and changed items take the new major revision as their own Revision.
+type metaStore struct {
+	rev   int64
+	items map[string]*rtm2.MetadataItem
+}
+
+func newMetaStore() *metaStore {
+	return &metaStore{items: make(map[string]*rtm2.MetadataItem)}
+}
+
+func (s *metaStore) snapshot() map[string]*rtm2.MetadataItem {
+	items := make(map[string]*rtm2.MetadataItem, len(s.items))
+	for k, item := range s.items {
+		cp := *item
+		items[k] = &cp
+	}
+	return items
+}
+
+// apply writes data into the store atomically. ch is used to check WithStorageLock, nil for user metadata.
+func (s *metaStore) apply(c *client, ch *channel, op int, data map[string]*rtm2.MetadataItem, opts []rtm2.StorageOption) error {
+	o := &rtm2.StorageOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Lock != "" {
+		if ch == nil {
+			return rtm2.ERR_METADATA_WITH_INVALID_LOCK
+		}
+		if l, ok := ch.locks[o.Lock]; !ok || l.owner != c.config.UserId {
+			return rtm2.ERR_METADATA_WITH_INVALID_LOCK
+		}
+	}
+	if o.MajorRev > 0 && o.MajorRev != s.rev {
+		return rtm2.ERR_METADATA_INVALID_REVISION
+	}
+	items := make(map[string]*rtm2.MetadataItem, len(s.items))
+	for k, item := range s.items {
+		items[k] = item
+	}
+	rev := s.rev + 1
+	now := time.Now().UnixMilli()
+	if op == opRemove && len(data) == 0 {
+		items = make(map[string]*rtm2.MetadataItem)
+	}
+	for k, item := range data {
+		if k == "" {
+			return rtm2.ERR_METADATA_INVALID_KEY
+		}
+		if len(k) > rtm2.MetadataKeyMaxSize {
+			return rtm2.ERR_METADATA_KEY_SIZE_OVERFLOW
+		}
+		old, exists := items[k]
+		if item != nil && item.Revision > 0 && (!exists || old.Revision != item.Revision) {
+			return rtm2.ERR_METADATA_INVALID_REVISION
+		}
+		switch op {
+		case opRemove:
+			delete(items, k)
+			continue
+		case opUpdate:
+			if !exists {
+				return rtm2.ERR_METADATA_INVALID_KEY
+			}
+		}
+		n := &rtm2.MetadataItem{Key: k, Revision: rev}
+		if item != nil {
+			n.Value = item.Value
+		}
+		if len(n.Value) > rtm2.MetadataValueMaxSize {
+			return rtm2.ERR_METADATA_VALUE_SIZE_OVERFLOW
+		}
+		if o.RecordAuthor {
+			n.Author = c.config.UserId
+		}
+		if o.RecordTs {
+			n.UpdateTs = now
+		}
+		items[k] = n
+	}
+	if len(items) > rtm2.MetadataItemMaxCount {
+		return rtm2.ERR_METADATA_ITEM_SIZE_OVERFLOW
+	}
+	size := 0
+	for k, item := range items {
+		size += len(k) + len(item.Value)
+	}
+	if size > rtm2.MetadataMaxSize {
+		return rtm2.ERR_METADATA_SIZE_OVERFLOW
+	}
+	s.rev = rev
+	s.items = items
+	return nil
+}
+
+type storage struct {
+	c *client
+}
+
+func (s *storage) GetChannelMetadataChan(channel string, channelType rtm2.ChannelType) (_ map[string]*rtm2.MetadataItem, _ <-chan *rtm2.StorageEvent, err error) {
+	defer wrapError(&err, "Storage.GetChannelMetadataChan", channel, "")
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := s.c.check(); err != nil {
+		return nil, nil, err
+	}
+	m, ok := s.c.memberships[chanKey{name: channel, channelType: channelType}]
+	if !ok || !m.metadata {
+		return nil, nil, rtm2.ERR_METADATA_NOT_SUBSCRIBED
+	}
+	return m.ch.meta.snapshot(), m.storages.ch, nil
+}
+
+func (s *storage) writeChannel(channel string, channelType rtm2.ChannelType, op int, data map[string]*rtm2.MetadataItem, opts []rtm2.StorageOption) error {
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := s.c.check(); err != nil {
+		return err
+	}
+	ch := h.channel(channel, channelType)
+	if err := ch.meta.apply(s.c, ch, op, data, opts); err != nil {
+		return err
+	}
+	for _, m := range ch.members {
+		if m.metadata {
+			m.storages.send(&rtm2.StorageEvent{MajorRevision: ch.meta.rev, Items: ch.meta.snapshot()})
+		}
+	}
+	return nil
+}
+
+func (s *storage) SetChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) (err error) {
+	defer wrapError(&err, "Storage.SetChannelMetadata", channel, "")
+	return s.writeChannel(channel, channelType, opSet, data, opts)
+}
+
+func (s *storage) UpdateChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) (err error) {
+	defer wrapError(&err, "Storage.UpdateChannelMetadata", channel, "")
+	return s.writeChannel(channel, channelType, opUpdate, data, opts)
+}
+
+// RemoveChannelMetadata removes all items if data is empty.
+func (s *storage) RemoveChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) (err error) {
+	defer wrapError(&err, "Storage.RemoveChannelMetadata", channel, "")
+	return s.writeChannel(channel, channelType, opRemove, data, opts)
+}
+
+func (s *storage) GetChannelMetadata(channel string, channelType rtm2.ChannelType) (_ int64, _ map[string]*rtm2.MetadataItem, err error) {
+	defer wrapError(&err, "Storage.GetChannelMetadata", channel, "")
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := s.c.check(); err != nil {
+		return 0, nil, err
+	}
+	ch, ok := h.channels[chanKey{name: channel, channelType: channelType}]
+	if !ok {
+		return 0, make(map[string]*rtm2.MetadataItem), nil
+	}
+	return ch.meta.rev, ch.meta.snapshot(), nil
+}
+
+func (s *storage) writeUser(userId string, op int, data map[string]*rtm2.MetadataItem, opts []rtm2.StorageOption) error {
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := s.c.check(); err != nil {
+		return err
+	}
+	store, ok := h.users[userId]
+	if !ok {
+		store = newMetaStore()
+		h.users[userId] = store
+	}
+	if err := store.apply(s.c, nil, op, data, opts); err != nil {
+		return err
+	}
+	for _, box := range h.userSubs[userId] {
+		box.send(&rtm2.StorageEvent{MajorRevision: store.rev, Items: store.snapshot()})
+	}
+	return nil
+}
+
+func (s *storage) SetUserMetadata(userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) (err error) {
+	defer wrapError(&err, "Storage.SetUserMetadata", "", "")
+	return s.writeUser(userId, opSet, data, opts)
+}
+
+func (s *storage) UpdateUserMetadata(userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) (err error) {
+	defer wrapError(&err, "Storage.UpdateUserMetadata", "", "")
+	return s.writeUser(userId, opUpdate, data, opts)
+}
+
+// RemoveUserMetadata removes all items if data is empty.
+func (s *storage) RemoveUserMetadata(userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) (err error) {
+	defer wrapError(&err, "Storage.RemoveUserMetadata", "", "")
+	return s.writeUser(userId, opRemove, data, opts)
+}
+
+func (s *storage) GetUserMetadata(userId string) (_ int64, _ map[string]*rtm2.MetadataItem, err error) {
+	defer wrapError(&err, "Storage.GetUserMetadata", "", "")
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := s.c.check(); err != nil {
+		return 0, nil, err
+	}
+	store, ok := h.users[userId]
+	if !ok {
+		return 0, make(map[string]*rtm2.MetadataItem), nil
+	}
+	return store.rev, store.snapshot(), nil
+}
+
+func (s *storage) SubscribeUserMetadata(userId string) (_ map[string]*rtm2.MetadataItem, _ <-chan *rtm2.StorageEvent, err error) {
+	defer wrapError(&err, "Storage.SubscribeUserMetadata", "", "")
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := s.c.check(); err != nil {
+		return nil, nil, err
+	}
+	subs, ok := h.userSubs[userId]
+	if !ok {
+		subs = make(map[*client]*storageBox)
+		h.userSubs[userId] = subs
+	}
+	if _, ok := subs[s.c]; ok {
+		return nil, nil, rtm2.ERR_METADATA_ALREADY_SUBSCRIBED
+	}
+	box := newStorageBox()
+	subs[s.c] = box
+	items := make(map[string]*rtm2.MetadataItem)
+	if store, ok := h.users[userId]; ok {
+		items = store.snapshot()
+	}
+	return items, box.ch, nil
+}
+
+func (s *storage) UnsubscribeUserMetadata(userId string) (err error) {
+	defer wrapError(&err, "Storage.UnsubscribeUserMetadata", "", "")
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := s.c.check(); err != nil {
+		return err
+	}
+	box, ok := h.userSubs[userId][s.c]
+	if !ok {
+		return rtm2.ERR_METADATA_NOT_SUBSCRIBED
+	}
+	box.Close()
+	delete(h.userSubs[userId], s.c)
+	if len(h.userSubs[userId]) == 0 {
+		delete(h.userSubs, userId)
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package memrtm
+
+import (
+	"sort"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type streamChannel struct {
+	c    *client
+	name string
+}
+
+func (s *streamChannel) key() chanKey {
+	return chanKey{name: s.name, channelType: rtm2.ChannelTypeStream}
+}
+
+// member must be called with hub locked.
+func (s *streamChannel) member() (*member, error) {
+	if err := s.c.check(); err != nil {
+		return nil, err
+	}
+	m, ok := s.c.memberships[s.key()]
+	if !ok {
+		return nil, rtm2.ERR_NOT_JOIN_CHANNEL
+	}
+	return m, nil
+}
+
+// Join returns the snapshot of topic publishers, the TopicEvent chan and the token expiry chan of this Stream Channel.
+func (s *streamChannel) Join(opts ...rtm2.StreamOption) (_ map[string][]string, _ <-chan *rtm2.TopicEvent, _ <-chan string, err error) {
+	defer wrapError(&err, "StreamChannel.Join", s.name, "")
+	o := &rtm2.StreamOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := s.c.check(); err != nil {
+		return nil, nil, nil, err
+	}
+	if _, ok := s.c.memberships[s.key()]; ok {
+		return nil, nil, nil, rtm2.ERR_ALREADY_JOIN_CHANNEL
+	}
+	if len(s.c.memberships) >= rtm2.ChannelMaxCount {
+		return nil, nil, nil, rtm2.ERR_EXCEED_CHANNEL_LIMITATION
+	}
+	m := h.join(s.c, s.key(), false, o.Metadata, o.Presence, o.Lock)
+	m.token = o.Token
+	return m.ch.topicSnapshot(), m.topicEvents.ch, m.tokens.ch, nil
+}
+
+func (s *streamChannel) Leave() (err error) {
+	defer wrapError(&err, "StreamChannel.Leave", s.name, "")
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, err := s.member()
+	if err != nil {
+		return err
+	}
+	h.leave(m)
+	return nil
+}
+
+func (s *streamChannel) ChannelName() string {
+	return s.name
+}
+
+func (s *streamChannel) JoinTopic(topic string, opts ...rtm2.StreamOption) (err error) {
+	defer wrapError(&err, "StreamChannel.JoinTopic", s.name, topic)
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, err := s.member()
+	if err != nil {
+		return err
+	}
+	if topic == "" {
+		return rtm2.ERR_INVALID_TOPIC_NAME
+	}
+	if m.topics[topic] {
+		return rtm2.ERR_TOPIC_ALREADY_JOINED
+	}
+	if len(m.topics) >= rtm2.TopicJoinMaxCount {
+		return rtm2.ERR_EXCEED_JOIN_TOPIC_LIMITATION
+	}
+	m.ch.joinTopic(m, topic)
+	return nil
+}
+
+func (s *streamChannel) PublishTopic(topic string, message []byte, opts ...rtm2.StreamOption) (err error) {
+	defer wrapError(&err, "StreamChannel.PublishTopic", s.name, topic)
+	o := &rtm2.StreamOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, err := s.member()
+	if err != nil {
+		return err
+	}
+	if !m.topics[topic] {
+		return rtm2.ERR_PUBLISH_TOPIC_MESSAGE_FAILED
+	}
+	publisher := s.c.config.UserId
+	for userId, other := range m.ch.members {
+		if userId == publisher || other.c.state != rtm2.ConnectionStateCONNECTED {
+			continue
+		}
+		if sub, ok := other.subs[topic]; ok && sub.users[publisher] {
+			sub.box.send(&rtm2.Message{UserId: publisher, Type: o.Type, Message: append([]byte(nil), message...)})
+		}
+	}
+	return nil
+}
+
+func (s *streamChannel) LeaveTopic(topic string) (err error) {
+	defer wrapError(&err, "StreamChannel.LeaveTopic", s.name, topic)
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, err := s.member()
+	if err != nil {
+		return err
+	}
+	if !m.topics[topic] {
+		return rtm2.ERR_INVALID_TOPIC_NAME
+	}
+	m.ch.leaveTopic(m, topic)
+	return nil
+}
+
+// SubscribeTopic with empty userIds follows every publisher of the topic, including those who join later.
+func (s *streamChannel) SubscribeTopic(topic string, userIds []string) (_ <-chan *rtm2.Message, err error) {
+	defer wrapError(&err, "StreamChannel.SubscribeTopic", s.name, topic)
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, err := s.member()
+	if err != nil {
+		return nil, err
+	}
+	if topic == "" {
+		return nil, rtm2.ERR_INVALID_TOPIC_NAME
+	}
+	sub, ok := m.subs[topic]
+	if !ok {
+		if len(m.subs) >= rtm2.TopicSubscribeMaxCount {
+			return nil, rtm2.ERR_EXCEED_SUBSCRIBE_TOPIC_LIMITATION
+		}
+		sub = &topicSub{users: make(map[string]bool), box: newMessageBox()}
+		m.subs[topic] = sub
+	}
+	users := append([]string(nil), userIds...)
+	if len(userIds) == 0 {
+		sub.all = true
+		for userId := range m.ch.topics[topic] {
+			if userId != s.c.config.UserId {
+				users = append(users, userId)
+			}
+		}
+	}
+	count := len(sub.users)
+	for _, userId := range users {
+		if !sub.users[userId] {
+			count++
+		}
+	}
+	if count > rtm2.TopicUserMaxCount {
+		return nil, rtm2.ERR_EXCEED_USER_LIMITATION
+	}
+	for _, userId := range users {
+		sub.users[userId] = true
+	}
+	return sub.box.ch, nil
+}
+
+func (s *streamChannel) UnsubscribeTopic(topic string, userIds []string) (err error) {
+	defer wrapError(&err, "StreamChannel.UnsubscribeTopic", s.name, topic)
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, err := s.member()
+	if err != nil {
+		return err
+	}
+	sub, ok := m.subs[topic]
+	if !ok {
+		return rtm2.ERR_NOT_SUBSCRIBED
+	}
+	if len(userIds) == 0 {
+		sub.all = false
+		sub.users = make(map[string]bool)
+		return nil
+	}
+	for _, userId := range userIds {
+		delete(sub.users, userId)
+	}
+	return nil
+}
+
+func (s *streamChannel) GetSubscribedUsers(topic string) (_ []string, err error) {
+	defer wrapError(&err, "StreamChannel.GetSubscribedUsers", s.name, topic)
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, err := s.member()
+	if err != nil {
+		return nil, err
+	}
+	sub, ok := m.subs[topic]
+	if !ok {
+		return nil, rtm2.ERR_NOT_SUBSCRIBED
+	}
+	users := make([]string, 0, len(sub.users))
+	for userId := range sub.users {
+		users = append(users, userId)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+func (s *streamChannel) RenewToken(token string) (err error) {
+	defer wrapError(&err, "StreamChannel.RenewToken", s.name, "")
+	h := s.c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, err := s.member()
+	if err != nil {
+		return err
+	}
+	if token == m.token {
+		return rtm2.ERR_DUPLICATE_TOKEN
+	}
+	m.token = token
+	return nil
+}
+
+func (ch *channel) topicSnapshot() map[string][]string {
+	snapshot := make(map[string][]string, len(ch.topics))
+	for topic, publishers := range ch.topics {
+		users := make([]string, 0, len(publishers))
+		for userId := range publishers {
+			users = append(users, userId)
+		}
+		sort.Strings(users)
+		snapshot[topic] = users
+	}
+	return snapshot
+}
+
+func (ch *channel) joinTopic(m *member, topic string) {
+	userId := m.c.config.UserId
+	m.topics[topic] = true
+	publishers, ok := ch.topics[topic]
+	if !ok {
+		publishers = make(map[string]bool)
+		ch.topics[topic] = publishers
+	}
+	publishers[userId] = true
+	for otherId, other := range ch.members {
+		if otherId == userId {
+			continue
+		}
+		if sub, ok := other.subs[topic]; ok && sub.all && len(sub.users) < rtm2.TopicUserMaxCount {
+			sub.users[userId] = true
+		}
+		other.topicEvents.send(&rtm2.TopicEvent{Type: rtm2.TopicEventJoin, Channel: ch.key.name, UserId: userId, Topic: topic})
+	}
+}
+
+func (ch *channel) leaveTopic(m *member, topic string) {
+	userId := m.c.config.UserId
+	delete(m.topics, topic)
+	if publishers, ok := ch.topics[topic]; ok {
+		delete(publishers, userId)
+		if len(publishers) == 0 {
+			delete(ch.topics, topic)
+		}
+	}
+	for otherId, other := range ch.members {
+		if otherId != userId {
+			other.topicEvents.send(&rtm2.TopicEvent{Type: rtm2.TopicEventLeave, Channel: ch.key.name, UserId: userId, Topic: topic})
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+	"github.com/tomasliu-agora/rtm2/internal/tagcodec"
+	"go.uber.org/zap"
+)
+
+// ErrNotStruct is returned by Bind if the type is not a struct.
+var ErrNotStruct = errors.New("metadata: bound type must be a struct")
+
+// FieldError is an error of a struct field bound to a metadata key.
+type FieldError struct {
+	Field string
+	Key   string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("metadata: field %s (key %q): %v", e.Field, e.Key, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Binding maps exported fields of struct T with `rtm:"key"` tags to metadata items.
+// Strings, booleans and numbers are encoded as their text, other types as JSON.
+// With `rtm:"key,omitempty"`, a zero value removes the item on Save. Fields without tag are ignored.
+type Binding[T any] struct {
+	storage rtm2.Storage
+	store   *Store
+	t       target
+	opts    []Option
+	fields  []tagcodec.Field
+
+	mu       sync.Mutex
+	cache    *Cache // shared by Watch calls
+	watchers int
+}
+
+// Bind binds T to the channel metadata. Keys are validated against rtm2.MetadataKeyMaxSize.
+func Bind[T any](storage rtm2.Storage, channel string, channelType rtm2.ChannelType, opts ...Option) (*Binding[T], error) {
+	return bind[T](storage, target{channel: channel, channelType: channelType}, opts)
+}
+
+// BindUser binds T to the user metadata of userId.
+func BindUser[T any](storage rtm2.Storage, userId string, opts ...Option) (*Binding[T], error) {
+	return bind[T](storage, target{userId: userId}, opts)
+}
+
+func bind[T any](storage rtm2.Storage, t target, opts []Option) (*Binding[T], error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+	b := &Binding[T]{storage: storage, store: New(storage, opts...), t: t, opts: opts}
+	keys := make(map[string]string)
+	var errs []error
+	b.fields = tagcodec.Fields(typ)
+	for _, f := range b.fields {
+		switch {
+		case f.Key == "":
+			errs = append(errs, &FieldError{Field: f.Name, Key: f.Key, Err: rtm2.ERR_METADATA_INVALID_KEY})
+		case len(f.Key) > rtm2.MetadataKeyMaxSize:
+			errs = append(errs, &FieldError{Field: f.Name, Key: f.Key, Err: rtm2.ERR_METADATA_KEY_SIZE_OVERFLOW})
+		case keys[f.Key] != "":
+			errs = append(errs, &FieldError{Field: f.Name, Key: f.Key, Err: fmt.Errorf("duplicate key of field %s", keys[f.Key])})
+		}
+		keys[f.Key] = f.Name
+	}
+	if len(b.fields) > rtm2.MetadataItemMaxCount {
+		errs = append(errs, rtm2.ERR_METADATA_ITEM_SIZE_OVERFLOW)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Load reads the metadata into v. Fields whose items do not exist are left untouched.
+func (b *Binding[T]) Load(ctx context.Context, v *T) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, items, err := b.t.get(b.storage)
+	if err != nil {
+		return err
+	}
+	return b.decode(items, v)
+}
+
+// Save writes all bound fields of v by Store.Update, retried on revision conflicts until ctx is done.
+// With WithStorageMajorRev in opts, v is only written over that major revision, and
+// ERR_METADATA_INVALID_REVISION is returned otherwise. Other opts are passed to every write.
+// Values are validated against rtm2.MetadataValueMaxSize and rtm2.MetadataMaxSize before sending.
+func (b *Binding[T]) Save(ctx context.Context, v T, opts ...rtm2.StorageOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sets, removes, err := b.encode(v)
+	if err != nil {
+		return err
+	}
+	o := &rtm2.StorageOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	expected := o.MajorRev
+	first := true
+	return b.store.update(ctx, b.t, func(rev int64, items map[string]*rtm2.MetadataItem) (map[string]*rtm2.MetadataItem, []string, error) {
+		// after a partial write, the sets of v are found applied over the expected revision
+		if expected > 0 && rev != expected && (first || !applied(items, sets)) {
+			return nil, nil, rtm2.ERR_METADATA_INVALID_REVISION
+		}
+		first = false
+		var removals []string
+		for k := range removes {
+			if _, ok := items[k]; ok {
+				removals = append(removals, k)
+			}
+		}
+		return sets, removals, nil
+	}, opts)
+}
+
+// applied returns whether items hold the values of sets.
+func applied(items, sets map[string]*rtm2.MetadataItem) bool {
+	for k, item := range sets {
+		if cur, ok := items[k]; !ok || cur.Value != item.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// Watch returns a golang chan receiving the current value, then every new value, until ctx is done.
+// Watch calls of a Binding share one Cache, which shares the StorageEvent golang chan, see Cache.
+func (b *Binding[T]) Watch(ctx context.Context) (<-chan T, error) {
+	c, err := b.watch()
+	if err != nil {
+		return nil, err
+	}
+	values := make(chan T)
+	box := outbox.New()
+	lg := newOptions(b.opts).Logger
+	var last *T
+	cancel := c.OnChange(func(items map[string]*rtm2.MetadataItem) {
+		var v T
+		if err := b.decode(items, &v); err != nil {
+			lg.Warn("decode metadata failed", zap.Error(err))
+			return
+		}
+		if last != nil && reflect.DeepEqual(*last, v) {
+			return
+		}
+		last = &v
+		box.Push(func(done <-chan struct{}) {
+			select {
+			case values <- v:
+			case <-done:
+			}
+		})
+	})
+	go func() {
+		<-ctx.Done()
+		cancel()
+		box.Close()
+		if err := b.unwatch(); err != nil {
+			lg.Warn("close metadata cache failed", zap.Error(err))
+		}
+	}()
+	return values, nil
+}
+
+// watch returns the shared Cache, created by the first watcher.
+func (b *Binding[T]) watch() (*Cache, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cache == nil {
+		var c *Cache
+		var err error
+		if b.t.userId != "" {
+			c, err = NewUserCache(b.storage, b.t.userId, b.opts...)
+		} else {
+			c, err = NewCache(b.storage, b.t.channel, b.t.channelType, b.opts...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.cache = c
+	}
+	b.watchers++
+	return b.cache, nil
+}
+
+// unwatch closes the shared Cache after the last watcher.
+func (b *Binding[T]) unwatch() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.watchers--
+	if b.watchers > 0 {
+		return nil
+	}
+	c := b.cache
+	b.cache = nil
+	return c.Close()
+}
+
+func (b *Binding[T]) decode(items map[string]*rtm2.MetadataItem, v *T) error {
+	rv := reflect.ValueOf(v).Elem()
+	var errs []error
+	for _, f := range b.fields {
+		item, ok := items[f.Key]
+		if !ok {
+			continue
+		}
+		if err := tagcodec.Decode(item.Value, rv.Field(f.Index)); err != nil {
+			errs = append(errs, &FieldError{Field: f.Name, Key: f.Key, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *Binding[T]) encode(v T) (map[string]*rtm2.MetadataItem, map[string]*rtm2.MetadataItem, error) {
+	rv := reflect.ValueOf(v)
+	sets := make(map[string]*rtm2.MetadataItem)
+	removes := make(map[string]*rtm2.MetadataItem)
+	var errs []error
+	size := 0
+	for _, f := range b.fields {
+		fv := rv.Field(f.Index)
+		if f.OmitEmpty && fv.IsZero() {
+			removes[f.Key] = &rtm2.MetadataItem{Key: f.Key}
+			continue
+		}
+		value, err := tagcodec.Encode(fv)
+		if err == nil && len(value) > rtm2.MetadataValueMaxSize {
+			err = rtm2.ERR_METADATA_VALUE_SIZE_OVERFLOW
+		}
+		if err != nil {
+			errs = append(errs, &FieldError{Field: f.Name, Key: f.Key, Err: err})
+			continue
+		}
+		size += len(f.Key) + len(value)
+		sets[f.Key] = &rtm2.MetadataItem{Key: f.Key, Value: value}
+	}
+	if size > rtm2.MetadataMaxSize {
+		errs = append(errs, rtm2.ERR_METADATA_SIZE_OVERFLOW)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	return sets, removes, nil
+}
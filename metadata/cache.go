@@ -0,0 +1,327 @@
+package metadata
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+	"go.uber.org/zap"
+)
+
+// Cache is a local view of channel or user metadata, safe for concurrent readers.
+// It starts from the snapshot of GetChannelMetadataChan or SubscribeUserMetadata and applies every StorageEvent:
+//   - Events not newer than the current major revision are dropped.
+//   - Events skipping a major revision, e.g. missed during reconnection, trigger a resync by GetChannelMetadata,
+//     read off the goroutine draining events. Events received meanwhile are queued, and applied in order
+//     after the metadata read. A failed resync is retried with backoff until the cache is closed.
+//   - Events without major revision never replace an item by one of a lower Revision.
+//
+// Caches of the same channel or user share one reader of the StorageEvent golang chan,
+// so do not read it elsewhere, use Watch or OnChange instead.
+type Cache struct {
+	storage rtm2.Storage
+	t       target
+	o       *Options
+	lg      *zap.Logger
+	src     *source // guarded by sources
+	stop    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+	// syncing tells a resync in flight, queue holds the events received meanwhile
+	syncing  bool
+	queue    []*rtm2.StorageEvent
+	rev      int64
+	items    map[string]*rtm2.MetadataItem
+	watchers map[string][]*itemWatcher
+	hooks    map[int]func(items map[string]*rtm2.MetadataItem)
+	nextHook int
+}
+
+type itemWatcher struct {
+	ch  chan *rtm2.MetadataItem
+	box *outbox.Outbox
+}
+
+// NewCache caches the channel metadata, which must be subscribed, e.g. by rtm2.WithMessageMetadata.
+// The StorageEvent chan of the channel is drained from the first cache until Detach, whether caches are open or not.
+func NewCache(storage rtm2.Storage, channel string, channelType rtm2.ChannelType, opts ...Option) (*Cache, error) {
+	o := newOptions(opts)
+	return newCache(storage, target{channel: channel, channelType: channelType}, o, o.Logger.With(zap.String("channel", channel)))
+}
+
+// NewUserCache subscribes the user metadata of userId and caches it. Caches of the same user share
+// the subscription, which is unsubscribed once all of them are closed, so do not subscribe it elsewhere.
+func NewUserCache(storage rtm2.Storage, userId string, opts ...Option) (*Cache, error) {
+	o := newOptions(opts)
+	return newCache(storage, target{userId: userId}, o, o.Logger.With(zap.String("user", userId)))
+}
+
+// newCache starts from a resync, as the snapshot of the subscription has no revision.
+func newCache(storage rtm2.Storage, t target, o *Options, lg *zap.Logger) (*Cache, error) {
+	c := &Cache{
+		storage:  storage,
+		t:        t,
+		o:        o,
+		lg:       lg,
+		stop:     make(chan struct{}),
+		items:    make(map[string]*rtm2.MetadataItem),
+		watchers: make(map[string][]*itemWatcher),
+		hooks:    make(map[int]func(items map[string]*rtm2.MetadataItem)),
+	}
+	if err := attach(c); err != nil {
+		return nil, err
+	}
+	rev, items, err := t.get(storage)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resynced(rev, items)
+	return c, nil
+}
+
+// apply runs on the goroutine draining events, so it never waits for the rtm service.
+func (c *Cache) apply(e *rtm2.StorageEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.closed:
+	case c.syncing:
+		c.queue = append(c.queue, e)
+	default:
+		c.applyLocked(e)
+	}
+}
+
+// applyLocked must be called with c.mu locked. A revision gap starts a resync, and queues e until it is done.
+func (c *Cache) applyLocked(e *rtm2.StorageEvent) {
+	switch {
+	case c.rev > 0 && e.MajorRevision > 0 && e.MajorRevision <= c.rev:
+		return
+	case c.rev > 0 && e.MajorRevision > c.rev+1:
+		c.lg.Debug("metadata revision gap, resync", zap.Int64("rev", c.rev), zap.Int64("event", e.MajorRevision))
+		c.queue = append(c.queue, e)
+		c.syncing = true
+		go c.resync()
+		return
+	}
+	c.replace(e.MajorRevision, e.Items)
+}
+
+// resync reads the metadata from Storage, retrying with backoff until it succeeds or the cache is closed,
+// then applies it if newer, followed by the queued events.
+func (c *Cache) resync() {
+	var backoff time.Duration
+	for {
+		rev, items, err := c.t.get(c.storage)
+		if err == nil {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.closed {
+				return
+			}
+			c.resynced(rev, items)
+			// the metadata read covers the gap, so queued events newer than it are applied as is
+			for _, e := range c.queue {
+				if c.rev <= 0 || e.MajorRevision <= 0 || e.MajorRevision > c.rev {
+					c.replace(e.MajorRevision, e.Items)
+				}
+			}
+			c.queue, c.syncing = nil, false
+			return
+		}
+		backoff = c.o.next(backoff)
+		c.lg.Warn("resync metadata failed", zap.Duration("backoff", backoff), zap.Error(err))
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-c.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// resynced must be called with c.mu locked. It applies the metadata read if newer.
+func (c *Cache) resynced(rev int64, items map[string]*rtm2.MetadataItem) {
+	if c.closed || rev > 0 && rev < c.rev {
+		return
+	}
+	c.replace(rev, items)
+}
+
+// replace must be called with c.mu locked.
+func (c *Cache) replace(rev int64, items map[string]*rtm2.MetadataItem) {
+	next := make(map[string]*rtm2.MetadataItem, len(items))
+	for k, item := range items {
+		cp := *item
+		if old, ok := c.items[k]; ok && rev <= 0 && old.Revision > cp.Revision {
+			cp = *old
+		}
+		next[k] = &cp
+	}
+	changed := false
+	for k, old := range c.items {
+		item, ok := next[k]
+		if !ok {
+			c.notify(k, nil)
+			changed = true
+		} else if item.Revision != old.Revision || item.Value != old.Value {
+			c.notify(k, item)
+			changed = true
+		}
+	}
+	for k, item := range next {
+		if _, ok := c.items[k]; !ok {
+			c.notify(k, item)
+			changed = true
+		}
+	}
+	c.items = next
+	if changed {
+		for _, h := range c.hooks {
+			h(next)
+		}
+	}
+	if rev > c.rev {
+		c.rev = rev
+	}
+}
+
+// notify must be called with c.mu locked to keep the order.
+func (c *Cache) notify(key string, item *rtm2.MetadataItem) {
+	for _, w := range c.watchers[key] {
+		w.send(item)
+	}
+}
+
+func (w *itemWatcher) send(item *rtm2.MetadataItem) {
+	if item != nil {
+		cp := *item
+		item = &cp
+	}
+	w.box.Push(func(done <-chan struct{}) {
+		select {
+		case w.ch <- item:
+		case <-done:
+		}
+	})
+}
+
+// Get returns a copy of the item of key.
+func (c *Cache) Get(key string) (*rtm2.MetadataItem, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	item, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	cp := *item
+	return &cp, true
+}
+
+// Range calls f on copies of items in the order of keys, until f returns false.
+// f runs on a snapshot, so it may call other methods of Cache.
+func (c *Cache) Range(f func(key string, item *rtm2.MetadataItem) bool) {
+	c.mu.RLock()
+	items := copyItems(c.items)
+	c.mu.RUnlock()
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !f(k, items[k]) {
+			return
+		}
+	}
+}
+
+// Revision returns the major revision of the cached metadata.
+func (c *Cache) Revision() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rev
+}
+
+// Watch returns a golang chan receiving the current item of key, then every change of it, until cancel is called.
+// nil means removed. The golang chan is left open, same as rtm sdk.
+func (c *Cache) Watch(key string) (items <-chan *rtm2.MetadataItem, cancel func()) {
+	w := &itemWatcher{ch: make(chan *rtm2.MetadataItem), box: outbox.New()}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		w.box.Close()
+		return w.ch, func() {}
+	}
+	c.watchers[key] = append(c.watchers[key], w)
+	w.send(c.items[key])
+	return w.ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		ws := c.watchers[key]
+		for i := range ws {
+			if ws[i] == w {
+				c.watchers[key] = append(ws[:i:i], ws[i+1:]...)
+				w.box.Close()
+				break
+			}
+		}
+		if len(c.watchers[key]) == 0 {
+			delete(c.watchers, key)
+		}
+	}
+}
+
+// OnChange calls h with the current items, then after every change, until cancel is called.
+// h runs with Cache locked, so it must not block, call methods of Cache or modify items.
+func (c *Cache) OnChange(h func(items map[string]*rtm2.MetadataItem)) (cancel func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.nextHook
+	c.nextHook++
+	c.hooks[id] = h
+	h(c.items)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.hooks, id)
+	}
+}
+
+// Close stops applying events, and unsubscribes the user metadata after the last cache of the user.
+// The StorageEvent chan of a channel is still drained, see Detach. Golang chans of Watch are left open, same as rtm sdk.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.stop)
+	c.queue = nil
+	c.hooks = make(map[int]func(items map[string]*rtm2.MetadataItem))
+	for _, ws := range c.watchers {
+		for _, w := range ws {
+			w.box.Close()
+		}
+	}
+	c.watchers = make(map[string][]*itemWatcher)
+	c.mu.Unlock()
+	return detach(c)
+}
+
+func copyItems(items map[string]*rtm2.MetadataItem) map[string]*rtm2.MetadataItem {
+	res := make(map[string]*rtm2.MetadataItem, len(items))
+	for k, item := range items {
+		cp := *item
+		res[k] = &cp
+	}
+	return res
+}
@@ -0,0 +1,146 @@
+package metadata
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type ChangeType string
+
+const (
+	ChangeAdded    ChangeType = "added"
+	ChangeRemoved  ChangeType = "removed"
+	ChangeModified ChangeType = "modified"
+)
+
+// Change is a difference of an item between two snapshots.
+// Channel is empty for user metadata of UserId.
+type Change struct {
+	Type        ChangeType
+	Channel     string
+	ChannelType rtm2.ChannelType
+	UserId      string
+	Key         string
+	Old         *Item // nil if added
+	New         *Item // nil if removed
+}
+
+func (c *Change) String() string {
+	scope := "user " + c.UserId
+	if c.Channel != "" {
+		scope = channelTypeName(c.ChannelType) + " channel " + c.Channel
+	}
+	switch c.Type {
+	case ChangeAdded:
+		return fmt.Sprintf("+ %s %s = %q", scope, c.Key, c.New.Value)
+	case ChangeRemoved:
+		return fmt.Sprintf("- %s %s = %q", scope, c.Key, c.Old.Value)
+	}
+	return fmt.Sprintf("~ %s %s = %q -> %q", scope, c.Key, c.Old.Value, c.New.Value)
+}
+
+// scope is the key of channel or user metadata in a snapshot.
+type scope struct {
+	channel     string
+	channelType rtm2.ChannelType
+	userId      string
+}
+
+func (s scope) less(o scope) bool {
+	if s.channel != o.channel {
+		// channels go first
+		if s.channel == "" || o.channel == "" {
+			return s.channel != ""
+		}
+		return s.channel < o.channel
+	}
+	if s.channelType != o.channelType {
+		return s.channelType < o.channelType
+	}
+	return s.userId < o.userId
+}
+
+// scopes indexes items of a snapshot by scope and key.
+func (s *Snapshot) scopes() (map[scope]map[string]*Item, error) {
+	res := make(map[scope]map[string]*Item)
+	for _, ch := range s.Channels {
+		t, err := ParseChannelType(ch.Type)
+		if err != nil {
+			return nil, err
+		}
+		res[scope{channel: ch.Channel, channelType: t}] = indexItems(ch.Items)
+	}
+	for _, u := range s.Users {
+		res[scope{userId: u.UserId}] = indexItems(u.Items)
+	}
+	return res, nil
+}
+
+func indexItems(items []*Item) map[string]*Item {
+	res := make(map[string]*Item, len(items))
+	for _, item := range items {
+		res[item.Key] = item
+	}
+	return res
+}
+
+// Diff returns changes from snapshot a to b, comparing item values. Channels or users in one snapshot only
+// are compared as empty in the other.
+func Diff(a, b *Snapshot) ([]*Change, error) {
+	as, err := a.scopes()
+	if err != nil {
+		return nil, err
+	}
+	bs, err := b.scopes()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]scope, 0, len(as)+len(bs))
+	for k := range as {
+		keys = append(keys, k)
+	}
+	for k := range bs {
+		if _, ok := as[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	var changes []*Change
+	for _, k := range keys {
+		changes = append(changes, diffItems(k, as[k], bs[k])...)
+	}
+	return changes, nil
+}
+
+func diffItems(s scope, a, b map[string]*Item) []*Change {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var changes []*Change
+	for _, k := range keys {
+		old, inA := a[k]
+		item, inB := b[k]
+		c := &Change{Channel: s.channel, ChannelType: s.channelType, UserId: s.userId, Key: k, Old: old, New: item}
+		switch {
+		case !inA:
+			c.Type = ChangeAdded
+		case !inB:
+			c.Type = ChangeRemoved
+		case old.Value != item.Value:
+			c.Type = ChangeModified
+		default:
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
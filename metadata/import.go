@@ -0,0 +1,206 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+// ErrConflict is returned by Import with ConflictFail, if an existing item of the target has another value.
+var ErrConflict = errors.New("metadata: conflicting item")
+
+// ConflictPolicy decides how Import treats items existing with another value.
+type ConflictPolicy int
+
+const (
+	// ConflictOverwrite sets all items.
+	ConflictOverwrite ConflictPolicy = iota
+	// ConflictSkip keeps existing items.
+	ConflictSkip
+	// ConflictFail fails before any write if an existing item of the target has another value when planned,
+	// so only items missing in the target are written. Exported revisions are not compared,
+	// as they differ across app ids. Writes are checked against the major revision of the target read
+	// when planned, then read back after each batch, so a write in between fails the import with ErrConflict.
+	// Metadata never written has no major revision, and is not checked.
+	ConflictFail
+)
+
+// ParseConflictPolicy parses "overwrite", "skip" or "fail".
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch name {
+	case "overwrite":
+		return ConflictOverwrite, nil
+	case "skip":
+		return ConflictSkip, nil
+	case "fail":
+		return ConflictFail, nil
+	}
+	return 0, fmt.Errorf("metadata: unknown conflict policy %q", name)
+}
+
+type ImportOptions struct {
+	DryRun bool
+	Policy ConflictPolicy
+	// BatchItems and BatchBytes bound the items and the sum of keys and values of a single write,
+	// 64 items and 16 KB by default. An item larger than BatchBytes is written alone.
+	BatchItems int
+	BatchBytes int
+}
+
+type ImportOption func(*ImportOptions)
+
+// WithDryRun plans the import without any write.
+func WithDryRun(enabled bool) ImportOption {
+	return func(o *ImportOptions) {
+		o.DryRun = enabled
+	}
+}
+
+func WithConflictPolicy(policy ConflictPolicy) ImportOption {
+	return func(o *ImportOptions) {
+		o.Policy = policy
+	}
+}
+
+func WithBatchSize(items, bytes int) ImportOption {
+	return func(o *ImportOptions) {
+		o.BatchItems = items
+		o.BatchBytes = bytes
+	}
+}
+
+// ImportResult lists changes applied, or to be applied with WithDryRun, and changes skipped by ConflictSkip.
+// On a failed write, changes applied by previous batches are returned along with the error.
+type ImportResult struct {
+	Applied []*Change
+	Skipped []*Change
+	Batches int
+}
+
+type importPlan struct {
+	t       target
+	rev     int64 // of the target when planned
+	changes []*Change
+}
+
+// Import writes items of the snapshot through SetChannelMetadata and SetUserMetadata.
+// Items with the same value are left untouched, and items missing in the snapshot are not removed.
+// Author and UpdateTs are not restored, as they are recorded by the rtm service.
+func Import(storage rtm2.Storage, snapshot *Snapshot, opts ...ImportOption) (*ImportResult, error) {
+	o := &ImportOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.BatchItems <= 0 {
+		o.BatchItems = 64
+	}
+	if o.BatchBytes <= 0 {
+		o.BatchBytes = 16 * 1024
+	}
+	scopes, err := snapshot.scopes()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]scope, 0, len(scopes))
+	for k := range scopes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+
+	// plan every scope first, so that ConflictFail fails before any write
+	res := &ImportResult{}
+	var plans []*importPlan
+	for _, k := range keys {
+		t := target{channel: k.channel, channelType: k.channelType, userId: k.userId}
+		rev, items, err := t.get(storage)
+		if err != nil {
+			return nil, err
+		}
+		p := &importPlan{t: t, rev: rev}
+		for _, c := range diffItems(k, exportedMap(items), scopes[k]) {
+			switch c.Type {
+			case ChangeRemoved:
+				continue
+			case ChangeModified:
+				if o.Policy == ConflictSkip {
+					res.Skipped = append(res.Skipped, c)
+					continue
+				}
+				if o.Policy == ConflictFail {
+					return nil, fmt.Errorf("%w: %s", ErrConflict, c)
+				}
+			}
+			p.changes = append(p.changes, c)
+		}
+		plans = append(plans, p)
+	}
+	for _, p := range plans {
+		bs := batches(p.changes, o.BatchItems, o.BatchBytes)
+		rev := p.rev
+		for i, batch := range bs {
+			if o.DryRun {
+				res.Applied = append(res.Applied, batch...)
+				res.Batches++
+				continue
+			}
+			data := make(map[string]*rtm2.MetadataItem, len(batch))
+			for _, c := range batch {
+				data[c.Key] = &rtm2.MetadataItem{Key: c.Key, Value: c.New.Value}
+			}
+			var wopts []rtm2.StorageOption
+			if o.Policy == ConflictFail {
+				wopts = append(wopts, rtm2.WithStorageMajorRev(rev))
+			}
+			if err := p.t.set(storage, data, wopts...); err != nil {
+				if o.Policy == ConflictFail && errors.Is(err, rtm2.ERR_METADATA_INVALID_REVISION) {
+					return res, fmt.Errorf("%w: import %s: %w", ErrConflict, batch[0], err)
+				}
+				return res, fmt.Errorf("import %s: %w", batch[0], err)
+			}
+			res.Applied = append(res.Applied, batch...)
+			res.Batches++
+			if o.Policy != ConflictFail || rev == 0 || i == len(bs)-1 {
+				continue
+			}
+			// the Storage does not tell the major revision of a write, so read it back,
+			// and check the items left are still as planned
+			var items map[string]*rtm2.MetadataItem
+			if rev, items, err = p.t.get(storage); err != nil {
+				return res, fmt.Errorf("import %s: %w", bs[i+1][0], err)
+			}
+			for _, next := range bs[i+1:] {
+				for _, c := range next {
+					if _, ok := items[c.Key]; ok {
+						return res, fmt.Errorf("%w: %s", ErrConflict, c)
+					}
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+func exportedMap(items map[string]*rtm2.MetadataItem) map[string]*Item {
+	return indexItems(exportItems(items))
+}
+
+func batches(changes []*Change, maxItems, maxBytes int) [][]*Change {
+	var res [][]*Change
+	var cur []*Change
+	size := 0
+	for _, c := range changes {
+		n := len(c.Key) + len(c.New.Value)
+		if len(cur) > 0 && (len(cur) >= maxItems || size+n > maxBytes) {
+			res = append(res, cur)
+			cur, size = nil, 0
+		}
+		cur = append(cur, c)
+		size += n
+	}
+	if len(cur) > 0 {
+		res = append(res, cur)
+	}
+	return res
+}
@@ -0,0 +1,235 @@
+// Package metadata provides helpers on rtm2.Storage for channel and user metadata:
+// compare-and-swap updates retried on revision conflicts, transactions of set and remove,
+// and Cache, a local view kept up to date by StorageEvents.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+type Options struct {
+	// MinBackoff and MaxBackoff bound the delay between retries of Update and of resyncs of Cache,
+	// 50 milliseconds and 2 seconds by default.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Logger     *zap.Logger
+}
+
+type Option func(*Options)
+
+func WithLogger(lg *zap.Logger) Option {
+	return func(o *Options) {
+		o.Logger = lg
+	}
+}
+
+// WithBackoff sets the delay between retries, doubled on each conflict from min up to max.
+func WithBackoff(min, max time.Duration) Option {
+	return func(o *Options) {
+		o.MinBackoff = min
+		o.MaxBackoff = max
+	}
+}
+
+// SentinelKey is the item written by Update, CompareAndSetItem and Txn on metadata without a major revision.
+// WithStorageMajorRev(0) skips the revision check, so metadata never written can not be compared and swapped,
+// and the sentinel gives it a revision first. The item is kept, and may be ignored by readers.
+const SentinelKey = "metadata.rev"
+
+// UpdateFunc computes changes from the current major revision and items.
+// Items in changes are set, keys in removals are removed. Returning an error aborts the update.
+type UpdateFunc func(rev int64, items map[string]*rtm2.MetadataItem) (changes map[string]*rtm2.MetadataItem, removals []string, err error)
+
+// Store wraps an rtm2.Storage with compare-and-swap helpers.
+type Store struct {
+	storage rtm2.Storage
+	opts    Options
+}
+
+func New(storage rtm2.Storage, opts ...Option) *Store {
+	return &Store{storage: storage, opts: *newOptions(opts)}
+}
+
+func newOptions(opts []Option) *Options {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 50 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 2 * time.Second
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = o.MinBackoff
+	}
+	return o
+}
+
+// Storage returns the underlying rtm2.Storage.
+func (s *Store) Storage() rtm2.Storage {
+	return s.storage
+}
+
+// target is the channel metadata of Channel, or the user metadata of UserId.
+type target struct {
+	channel     string
+	channelType rtm2.ChannelType
+	userId      string
+}
+
+func (t target) get(s rtm2.Storage) (int64, map[string]*rtm2.MetadataItem, error) {
+	if t.userId != "" {
+		return s.GetUserMetadata(t.userId)
+	}
+	return s.GetChannelMetadata(t.channel, t.channelType)
+}
+
+func (t target) set(s rtm2.Storage, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	if t.userId != "" {
+		return s.SetUserMetadata(t.userId, data, opts...)
+	}
+	return s.SetChannelMetadata(t.channel, t.channelType, data, opts...)
+}
+
+func (t target) remove(s rtm2.Storage, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	if t.userId != "" {
+		return s.RemoveUserMetadata(t.userId, data, opts...)
+	}
+	return s.RemoveChannelMetadata(t.channel, t.channelType, data, opts...)
+}
+
+// Update reads the channel metadata, applies fn and writes the result under the major revision read,
+// until no other write happens in between or ctx is done. fn may be called multiple times.
+// Sets and removals are separate writes: if removals keep failing after the sets are applied,
+// Update gives up with ErrPartialCommit after a few attempts.
+// opts are appended to every write, e.g. WithStorageRecordAuthor.
+func (s *Store) Update(ctx context.Context, channel string, channelType rtm2.ChannelType, fn UpdateFunc, opts ...rtm2.StorageOption) error {
+	return s.update(ctx, target{channel: channel, channelType: channelType}, fn, opts)
+}
+
+// UpdateUser is Update on the user metadata of userId.
+func (s *Store) UpdateUser(ctx context.Context, userId string, fn UpdateFunc, opts ...rtm2.StorageOption) error {
+	return s.update(ctx, target{userId: userId}, fn, opts)
+}
+
+// maxPartialCommits bounds the partial commits in a row of Update, which keep rewriting the sets otherwise.
+const maxPartialCommits = 3
+
+func (s *Store) update(ctx context.Context, t target, fn UpdateFunc, opts []rtm2.StorageOption) error {
+	var backoff time.Duration
+	var partials int
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rev, items, err := t.get(s.storage)
+		if err != nil {
+			return err
+		}
+		if rev == 0 {
+			if err := s.sentinel(t); err != nil {
+				return err
+			}
+			continue
+		}
+		changes, removals, err := fn(rev, items)
+		if err != nil {
+			return err
+		}
+		txn := &Txn{s: s, t: t, rev: rev, opts: opts}
+		for k, item := range changes {
+			txn.SetItem(k, item)
+		}
+		for _, k := range removals {
+			txn.Remove(k)
+		}
+		// a partial commit is retried as any other conflict, fn then sees the sets applied,
+		// up to maxPartialCommits times in a row
+		err = txn.commit(true)
+		if errors.Is(err, ErrPartialCommit) {
+			partials++
+			if partials >= maxPartialCommits {
+				return err
+			}
+		} else {
+			partials = 0
+		}
+		if !errors.Is(err, rtm2.ERR_METADATA_INVALID_REVISION) {
+			return err
+		}
+		backoff = s.next(backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// sentinel writes SentinelKey, so that the metadata of t has a major revision to compare.
+func (s *Store) sentinel(t target) error {
+	return t.set(s.storage, map[string]*rtm2.MetadataItem{SentinelKey: {Key: SentinelKey}})
+}
+
+func (s *Store) next(backoff time.Duration) time.Duration {
+	return s.opts.next(backoff)
+}
+
+func (o *Options) next(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff < o.MinBackoff {
+		backoff = o.MinBackoff
+	}
+	if backoff > o.MaxBackoff {
+		backoff = o.MaxBackoff
+	}
+	return backoff
+}
+
+// CompareAndSetItem sets item only if its current Revision equals item.Revision.
+// Revision 0 means the item must not exist yet, checked under the major revision read, see SentinelKey.
+// Returns ERR_METADATA_INVALID_REVISION on mismatch.
+func (s *Store) CompareAndSetItem(ctx context.Context, channel string, channelType rtm2.ChannelType, item *rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	return s.compareAndSet(ctx, target{channel: channel, channelType: channelType}, item, opts)
+}
+
+// CompareAndSetUserItem is CompareAndSetItem on the user metadata of userId.
+func (s *Store) CompareAndSetUserItem(ctx context.Context, userId string, item *rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	return s.compareAndSet(ctx, target{userId: userId}, item, opts)
+}
+
+func (s *Store) compareAndSet(ctx context.Context, t target, item *rtm2.MetadataItem, opts []rtm2.StorageOption) error {
+	data := map[string]*rtm2.MetadataItem{item.Key: item}
+	if item.Revision > 0 {
+		return t.set(s.storage, data, opts...)
+	}
+	// the item is checked for absence, and created under the major revision read
+	return s.update(ctx, t, func(rev int64, items map[string]*rtm2.MetadataItem) (map[string]*rtm2.MetadataItem, []string, error) {
+		if _, ok := items[item.Key]; ok {
+			return nil, nil, rtm2.ERR_METADATA_INVALID_REVISION
+		}
+		return data, nil, nil
+	}, opts)
+}
+
+// Txn starts a transaction on the channel metadata read at major revision rev.
+func (s *Store) Txn(channel string, channelType rtm2.ChannelType, rev int64, opts ...rtm2.StorageOption) *Txn {
+	return &Txn{s: s, t: target{channel: channel, channelType: channelType}, rev: rev, opts: opts}
+}
+
+// UserTxn starts a transaction on the user metadata of userId read at major revision rev.
+func (s *Store) UserTxn(userId string, rev int64, opts ...rtm2.StorageOption) *Txn {
+	return &Txn{s: s, t: target{userId: userId}, rev: rev, opts: opts}
+}
@@ -0,0 +1,142 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tomasliu-agora/rtm2"
+	"gopkg.in/yaml.v3"
+)
+
+// Format of an encoded Snapshot.
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+)
+
+// FormatOf returns the format by the extension of path, FormatJSON by default.
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+	return FormatJSON
+}
+
+// Snapshot is the exported metadata of channels and users.
+type Snapshot struct {
+	Channels []*ChannelSnapshot `json:"channels,omitempty" yaml:"channels,omitempty"`
+	Users    []*UserSnapshot    `json:"users,omitempty" yaml:"users,omitempty"`
+}
+
+type ChannelSnapshot struct {
+	Channel string `json:"channel" yaml:"channel"`
+	// Type is "message" or "stream".
+	Type          string  `json:"type" yaml:"type"`
+	MajorRevision int64   `json:"majorRevision" yaml:"majorRevision"`
+	Items         []*Item `json:"items" yaml:"items"`
+}
+
+type UserSnapshot struct {
+	UserId        string  `json:"userId" yaml:"userId"`
+	MajorRevision int64   `json:"majorRevision" yaml:"majorRevision"`
+	Items         []*Item `json:"items" yaml:"items"`
+}
+
+// Item is an exported rtm2.MetadataItem.
+type Item struct {
+	Key      string `json:"key" yaml:"key"`
+	Value    string `json:"value" yaml:"value"`
+	Author   string `json:"author,omitempty" yaml:"author,omitempty"`
+	Revision int64  `json:"revision" yaml:"revision"`
+	UpdateTs int64  `json:"updateTs,omitempty" yaml:"updateTs,omitempty"`
+}
+
+func channelTypeName(t rtm2.ChannelType) string {
+	if t == rtm2.ChannelTypeStream {
+		return "stream"
+	}
+	return "message"
+}
+
+// ParseChannelType parses "message" or "stream".
+func ParseChannelType(name string) (rtm2.ChannelType, error) {
+	switch name {
+	case "message", "":
+		return rtm2.ChannelTypeMessage, nil
+	case "stream":
+		return rtm2.ChannelTypeStream, nil
+	}
+	return 0, fmt.Errorf("metadata: unknown channel type %q", name)
+}
+
+// Export reads the metadata of channels and users. Items are sorted by key.
+func Export(storage rtm2.Storage, channels []*rtm2.ChannelInfo, userIds []string) (*Snapshot, error) {
+	s := &Snapshot{}
+	for _, ch := range channels {
+		rev, items, err := storage.GetChannelMetadata(ch.Channel, ch.Type)
+		if err != nil {
+			return nil, fmt.Errorf("export channel %s: %w", ch.Channel, err)
+		}
+		s.Channels = append(s.Channels, &ChannelSnapshot{Channel: ch.Channel, Type: channelTypeName(ch.Type), MajorRevision: rev, Items: exportItems(items)})
+	}
+	for _, userId := range userIds {
+		rev, items, err := storage.GetUserMetadata(userId)
+		if err != nil {
+			return nil, fmt.Errorf("export user %s: %w", userId, err)
+		}
+		s.Users = append(s.Users, &UserSnapshot{UserId: userId, MajorRevision: rev, Items: exportItems(items)})
+	}
+	return s, nil
+}
+
+func exportItems(items map[string]*rtm2.MetadataItem) []*Item {
+	res := make([]*Item, 0, len(items))
+	for k, item := range items {
+		res = append(res, &Item{Key: k, Value: item.Value, Author: item.Author, Revision: item.Revision, UpdateTs: item.UpdateTs})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// Encode writes the snapshot in format.
+func (s *Snapshot) Encode(w io.Writer, format Format) error {
+	switch format {
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+		return enc.Close()
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	return fmt.Errorf("metadata: unknown format %q", format)
+}
+
+// DecodeSnapshot reads a snapshot in format.
+func DecodeSnapshot(r io.Reader, format Format) (*Snapshot, error) {
+	s := &Snapshot{}
+	var err error
+	switch format {
+	case FormatYAML:
+		err = yaml.NewDecoder(r).Decode(s)
+	case FormatJSON:
+		err = json.NewDecoder(r).Decode(s)
+	default:
+		err = fmt.Errorf("metadata: unknown format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,107 @@
+package metadata
+
+import (
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type sourceKey struct {
+	storage rtm2.Storage
+	t       target
+}
+
+// source reads the StorageEvent chan of a channel or a user once, and fans events out to all caches of it.
+// The chan of a channel is drained without caches, since the rtm sdk blocks on an unread chan, until Detach.
+// The chan of a user is subscribed by the first cache, and unsubscribed after the last one is closed.
+type source struct {
+	key    sourceKey
+	stop   chan struct{}
+	caches map[*Cache]bool // guarded by sources
+}
+
+var sources = struct {
+	sync.Mutex
+	m map[sourceKey]*source
+}{m: make(map[sourceKey]*source)}
+
+// attach registers c on the source of its target, subscribing the source first if needed.
+// The snapshot is left to the resync of the cache.
+func attach(c *Cache) error {
+	key := sourceKey{storage: c.storage, t: c.t}
+	sources.Lock()
+	defer sources.Unlock()
+	s, ok := sources.m[key]
+	if !ok {
+		var events <-chan *rtm2.StorageEvent
+		var err error
+		if c.t.userId != "" {
+			_, events, err = c.storage.SubscribeUserMetadata(c.t.userId)
+		} else {
+			_, events, err = c.storage.GetChannelMetadataChan(c.t.channel, c.t.channelType)
+		}
+		if err != nil {
+			return err
+		}
+		s = &source{key: key, stop: make(chan struct{}), caches: make(map[*Cache]bool)}
+		sources.m[key] = s
+		go s.run(events)
+	}
+	s.caches[c] = true
+	c.src = s
+	return nil
+}
+
+// detach unregisters c, and unsubscribes the user metadata after the last cache of a user.
+func detach(c *Cache) error {
+	sources.Lock()
+	defer sources.Unlock()
+	s := c.src
+	if s == nil || !s.caches[c] {
+		return nil
+	}
+	delete(s.caches, c)
+	if len(s.caches) > 0 || c.t.userId == "" {
+		return nil
+	}
+	s.close()
+	return c.storage.UnsubscribeUserMetadata(c.t.userId)
+}
+
+// close must be called with sources locked.
+func (s *source) close() {
+	if sources.m[s.key] == s {
+		delete(sources.m, s.key)
+	}
+	close(s.stop)
+}
+
+// Detach stops draining the StorageEvent chan of the channel, and stops all its caches from applying events.
+// Call it once Metadata of the channel is unsubscribed, e.g. after Unsubscribe, so that a later cache
+// reads the chan of the new subscription.
+func Detach(storage rtm2.Storage, channel string, channelType rtm2.ChannelType) {
+	sources.Lock()
+	defer sources.Unlock()
+	if s, ok := sources.m[sourceKey{storage: storage, t: target{channel: channel, channelType: channelType}}]; ok {
+		s.close()
+	}
+}
+
+func (s *source) run(events <-chan *rtm2.StorageEvent) {
+	for {
+		select {
+		case e := <-events:
+			sources.Lock()
+			caches := make([]*Cache, 0, len(s.caches))
+			for c := range s.caches {
+				caches = append(caches, c)
+			}
+			sources.Unlock()
+			for _, c := range caches {
+				c.apply(e)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
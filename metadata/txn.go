@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+// ErrMixedTxn is returned by Txn.Commit with both sets and removals, which the Storage can not write at once.
+// Use Store.Update instead, which retries a partial write.
+var ErrMixedTxn = errors.New("metadata: transaction both sets and removes items")
+
+// ErrPartialCommit is returned by a write of Update if items are set but not removed, because of a write in between.
+var ErrPartialCommit = errors.New("metadata: partial commit")
+
+// Txn sets and removes multiple items under one major revision.
+type Txn struct {
+	s       *Store
+	t       target
+	rev     int64
+	opts    []rtm2.StorageOption
+	sets    map[string]*rtm2.MetadataItem
+	removes map[string]bool
+}
+
+// Set sets key to value.
+func (t *Txn) Set(key string, value string) *Txn {
+	return t.SetItem(key, &rtm2.MetadataItem{Key: key, Value: value})
+}
+
+// SetItem sets item of key. A non-zero item.Revision is compared as well.
+func (t *Txn) SetItem(key string, item *rtm2.MetadataItem) *Txn {
+	if t.sets == nil {
+		t.sets = make(map[string]*rtm2.MetadataItem)
+	}
+	cp := *item
+	cp.Key = key
+	t.sets[key] = &cp
+	delete(t.removes, key)
+	return t
+}
+
+// Remove removes key.
+func (t *Txn) Remove(key string) *Txn {
+	if t.removes == nil {
+		t.removes = make(map[string]bool)
+	}
+	t.removes[key] = true
+	delete(t.sets, key)
+	return t
+}
+
+// Commit writes the transaction in one write, failing with ERR_METADATA_INVALID_REVISION
+// if the major revision is changed. Sets and removals are separate writes of the Storage,
+// so a transaction of both fails with ErrMixedTxn without writing, use Store.Update instead.
+// Metadata without a major revision gets SentinelKey written, then fails with ERR_METADATA_INVALID_REVISION
+// to be read again.
+func (t *Txn) Commit() error {
+	return t.commit(false)
+}
+
+// commit writes sets, then removals under the major revision read back after the sets, if mixed.
+// A write after the read fails the removals after the sets are applied, reported as ErrPartialCommit
+// wrapping ERR_METADATA_INVALID_REVISION, and so does a write between the sets and the read changing
+// an item of the transaction.
+func (t *Txn) commit(mixed bool) error {
+	if t.rev == 0 {
+		if err := t.s.sentinel(t.t); err != nil {
+			return err
+		}
+		return rtm2.ERR_METADATA_INVALID_REVISION
+	}
+	if !mixed && len(t.sets) > 0 && len(t.removes) > 0 {
+		return ErrMixedTxn
+	}
+	if len(t.sets) > 0 {
+		if err := t.t.set(t.s.storage, t.sets, append(t.opts[:len(t.opts):len(t.opts)], rtm2.WithStorageMajorRev(t.rev))...); err != nil {
+			return err
+		}
+	}
+	if len(t.removes) == 0 {
+		return nil
+	}
+	rev := t.rev
+	if len(t.sets) > 0 {
+		// the Storage does not tell the major revision of a write, so read it back
+		var items map[string]*rtm2.MetadataItem
+		var err error
+		rev, items, err = t.t.get(t.s.storage)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrPartialCommit, err)
+		}
+		for k, item := range t.sets {
+			if cur, ok := items[k]; !ok || cur.Value != item.Value {
+				return fmt.Errorf("%w: %w", ErrPartialCommit, rtm2.ERR_METADATA_INVALID_REVISION)
+			}
+		}
+	}
+	data := make(map[string]*rtm2.MetadataItem, len(t.removes))
+	for k := range t.removes {
+		data[k] = &rtm2.MetadataItem{Key: k}
+	}
+	err := t.t.remove(t.s.storage, data, append(t.opts[:len(t.opts):len(t.opts)], rtm2.WithStorageMajorRev(rev))...)
+	if err != nil && len(t.sets) > 0 {
+		return fmt.Errorf("%w: %w", ErrPartialCommit, err)
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type instrumentedClient struct {
+	rtm2.RTMClient
+	m *Metrics
+
+	mu      sync.Mutex
+	streams map[string]*stream
+	conn    *forwarder[*rtm2.ConnectionEvent]
+	subs    map[string]*forwarder[*rtm2.Message] // by channel
+}
+
+func (c *instrumentedClient) Login(token string) (<-chan *rtm2.ConnectionEvent, <-chan string, error) {
+	events, tokens, err := c.RTMClient.Login(token)
+	if c.m.observe("Login", err) != nil {
+		return nil, nil, err
+	}
+	m := c.m
+	f := forward(events, func(e *rtm2.ConnectionEvent) {
+		m.connectionState.WithLabelValues(m.channel(e.Channel)).Set(float64(e.State))
+		m.connectionEvents.WithLabelValues(strconv.Itoa(int(e.State)), strconv.Itoa(int(e.Reason))).Inc()
+	}, nop[*rtm2.ConnectionEvent])
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.close()
+	}
+	c.conn = f
+	c.mu.Unlock()
+	return f.out, tokens, nil
+}
+
+// Logout stops relaying the ConnectionEvents and the messages of all subscriptions, dropping their backlogs.
+func (c *instrumentedClient) Logout() error {
+	err := c.RTMClient.Logout()
+	if c.m.observe("Logout", err) != nil {
+		return err
+	}
+	c.m.connectionState.WithLabelValues(c.m.channel("")).Set(rtm2.ConnectionStateDISCONNECTED)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.close()
+		c.conn = nil
+	}
+	for channel, f := range c.subs {
+		f.close()
+		c.m.backlog.DeleteLabelValues(c.m.channel(channel), "")
+		delete(c.subs, channel)
+	}
+	for _, s := range c.streams {
+		s.closeTopics()
+	}
+	return nil
+}
+
+func (c *instrumentedClient) SetParameters(params map[string]interface{}) error {
+	return c.m.observe("SetParameters", c.RTMClient.SetParameters(params))
+}
+
+func (c *instrumentedClient) RenewToken(token string) error {
+	return c.m.observe("RenewToken", c.RTMClient.RenewToken(token))
+}
+
+func (c *instrumentedClient) Storage() rtm2.Storage {
+	return &storage{Storage: c.RTMClient.Storage(), m: c.m}
+}
+
+func (c *instrumentedClient) Lock() rtm2.Lock {
+	return &lock{Lock: c.RTMClient.Lock(), m: c.m}
+}
+
+func (c *instrumentedClient) Presence() rtm2.Presence {
+	return &presence{Presence: c.RTMClient.Presence(), m: c.m}
+}
+
+func (c *instrumentedClient) Publish(channel string, message []byte, opts ...rtm2.MessageOption) error {
+	err := c.m.observe("Publish", c.RTMClient.Publish(channel, message, opts...))
+	if err == nil {
+		c.m.published(channel, "", len(message))
+	}
+	return err
+}
+
+func (c *instrumentedClient) Subscribe(channel string, opts ...rtm2.MessageOption) (chan *rtm2.Message, error) {
+	messages, err := c.RTMClient.Subscribe(channel, opts...)
+	if c.m.observe("Subscribe", err) != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.subs[channel]; ok {
+		if f.in == messages {
+			return f.out, nil
+		}
+		f.close()
+	}
+	f := c.m.messages(messages, channel, "")
+	c.subs[channel] = f
+	return f.out, nil
+}
+
+// Unsubscribe stops relaying the messages of channel, and drops its backlog.
+func (c *instrumentedClient) Unsubscribe(channel string) error {
+	err := c.m.observe("Unsubscribe", c.RTMClient.Unsubscribe(channel))
+	if err != nil {
+		return err
+	}
+	c.m.connectionState.DeleteLabelValues(c.m.channel(channel))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.subs[channel]; ok {
+		f.close()
+		delete(c.subs, channel)
+	}
+	c.m.backlog.DeleteLabelValues(c.m.channel(channel), "")
+	return nil
+}
+
+func (c *instrumentedClient) StreamChannel(channel string) rtm2.StreamChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.streams[channel]
+	if !ok {
+		s = &stream{StreamChannel: c.RTMClient.StreamChannel(channel), m: c.m, topics: make(map[string]bool), subs: make(map[string]*forwarder[*rtm2.Message])}
+		c.streams[channel] = s
+	}
+	return s
+}
+
+// messages counts received messages and the backlog of a subscription.
+func (m *Metrics) messages(in <-chan *rtm2.Message, channel string, topic string) *forwarder[*rtm2.Message] {
+	labels := []string{m.channel(channel), topic}
+	received, size, backlog := m.receiveMessages.WithLabelValues(labels...), m.receiveBytes.WithLabelValues(labels...), m.backlog.WithLabelValues(labels...)
+	return forward(in, func(msg *rtm2.Message) {
+		received.Inc()
+		size.Add(float64(len(msg.Message)))
+		backlog.Inc()
+	}, func(*rtm2.Message) {
+		backlog.Dec()
+	})
+}
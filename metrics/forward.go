@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+)
+
+// forwarder relays events from in to out, calling received once an event arrives and delivered once it is read.
+type forwarder[T any] struct {
+	in   <-chan T
+	out  chan T
+	box  *outbox.Outbox
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func forward[T any](in <-chan T, received func(T), delivered func(T)) *forwarder[T] {
+	f := &forwarder[T]{in: in, out: make(chan T), box: outbox.New(), stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		for {
+			select {
+			case e, ok := <-in:
+				if !ok {
+					return
+				}
+				received(e)
+				f.box.Push(func(done <-chan struct{}) {
+					select {
+					case f.out <- e:
+						delivered(e)
+					case <-done:
+					}
+				})
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+	return f
+}
+
+// close stops relaying and drops the events not read yet. Once it returns, received is not called anymore.
+// The golang chan out is left open, same as rtm sdk.
+func (f *forwarder[T]) close() {
+	f.once.Do(func() {
+		close(f.stop)
+		<-f.done
+		f.box.Close()
+	})
+}
+
+func nop[T any](T) {}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type lock struct {
+	rtm2.Lock
+	m *Metrics
+}
+
+func (l *lock) GetLockChan(channel string, channelType rtm2.ChannelType) (map[string]*rtm2.LockDetail, <-chan *rtm2.LockEvent, error) {
+	details, events, err := l.Lock.GetLockChan(channel, channelType)
+	return details, events, l.m.observe("Lock.GetLockChan", err)
+}
+
+func (l *lock) Set(channel string, channelType rtm2.ChannelType, name string, ttl uint32) error {
+	return l.m.observe("Lock.Set", l.Lock.Set(channel, channelType, name, ttl))
+}
+
+func (l *lock) Get(channel string, channelType rtm2.ChannelType) (map[string]*rtm2.LockDetail, error) {
+	details, err := l.Lock.Get(channel, channelType)
+	return details, l.m.observe("Lock.Get", err)
+}
+
+func (l *lock) Remove(channel string, channelType rtm2.ChannelType, name string) error {
+	return l.m.observe("Lock.Remove", l.Lock.Remove(channel, channelType, name))
+}
+
+// Acquire records the latency until the result arrives.
+// A pending Acquire canceled by Release is counted with code "canceled".
+func (l *lock) Acquire(channel string, channelType rtm2.ChannelType, name string, retry bool) <-chan error {
+	start := time.Now()
+	result := l.Lock.Acquire(channel, channelType, name, retry)
+	out := make(chan error, 1)
+	go func() {
+		err, ok := <-result
+		if !ok {
+			l.m.operations.WithLabelValues("Lock.Acquire", "canceled").Inc()
+			close(out)
+			return
+		}
+		l.m.observe("Lock.Acquire", err)
+		l.m.acquired(channel, start, err)
+		out <- err
+	}()
+	return out
+}
+
+func (l *lock) Release(channel string, channelType rtm2.ChannelType, name string) error {
+	return l.m.observe("Lock.Release", l.Lock.Release(channel, channelType, name))
+}
+
+func (l *lock) Revoke(channel string, channelType rtm2.ChannelType, name string, owner string) error {
+	return l.m.observe("Lock.Revoke", l.Lock.Revoke(channel, channelType, name, owner))
+}
@@ -0,0 +1,160 @@
+// Package metrics instruments an RTMClient with Prometheus metrics.
+//
+//	m, err := metrics.New(prometheus.DefaultRegisterer)
+//	client := m.Instrument(rtm2.CreateRTMClient(config))
+//
+// Every call is counted by operation and errno. Received messages are counted when the sdk delivers them,
+// and stay in the backlog gauge until they are read from the golang chan.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type Options struct {
+	Namespace string
+	// ChannelLabel maps a channel name to the value of the "channel" label, e.g. to bound the cardinality.
+	ChannelLabel func(channel string) string
+	// LockBuckets are the buckets of the lock acquire latency histogram.
+	LockBuckets []float64
+}
+
+type Option func(*Options)
+
+// WithNamespace sets the namespace of all metrics, "rtm" by default.
+func WithNamespace(namespace string) Option {
+	return func(o *Options) {
+		o.Namespace = namespace
+	}
+}
+
+// WithChannelLabel maps channel names to label values. Channel names are used as is by default.
+func WithChannelLabel(f func(channel string) string) Option {
+	return func(o *Options) {
+		o.ChannelLabel = f
+	}
+}
+
+// WithLockBuckets sets the buckets of the lock acquire latency histogram, in seconds.
+func WithLockBuckets(buckets []float64) Option {
+	return func(o *Options) {
+		o.LockBuckets = buckets
+	}
+}
+
+// Metrics holds the collectors. Gauges are shared by all instrumented clients,
+// use prometheus.WrapRegistererWith to create one Metrics per client with distinct labels.
+type Metrics struct {
+	opts *Options
+
+	operations       *prometheus.CounterVec
+	publishMessages  *prometheus.CounterVec
+	publishBytes     *prometheus.CounterVec
+	receiveMessages  *prometheus.CounterVec
+	receiveBytes     *prometheus.CounterVec
+	backlog          *prometheus.GaugeVec
+	lockAcquire      *prometheus.HistogramVec
+	connectionState  *prometheus.GaugeVec
+	joinedTopics     *prometheus.GaugeVec
+	subscribedUsers  *prometheus.GaugeVec
+	connectionEvents *prometheus.CounterVec
+}
+
+// New creates the collectors and registers them to reg.
+func New(reg prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	o := &Options{Namespace: "rtm", LockBuckets: prometheus.ExponentialBuckets(0.005, 2, 14)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.ChannelLabel == nil {
+		o.ChannelLabel = func(channel string) string {
+			return channel
+		}
+	}
+	ns := o.Namespace
+	m := &Metrics{
+		opts: o,
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "operations_total", Help: "Calls by operation and errno, 0 for success.",
+		}, []string{"op", "code"}),
+		publishMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "publish_messages_total", Help: "Messages published successfully.",
+		}, []string{"channel", "topic"}),
+		publishBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "publish_bytes_total", Help: "Payload bytes published successfully.",
+		}, []string{"channel", "topic"}),
+		receiveMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "receive_messages_total", Help: "Messages received from the sdk.",
+		}, []string{"channel", "topic"}),
+		receiveBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "receive_bytes_total", Help: "Payload bytes received from the sdk.",
+		}, []string{"channel", "topic"}),
+		backlog: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns, Name: "receive_backlog", Help: "Messages received but not read from the golang chan yet.",
+		}, []string{"channel", "topic"}),
+		lockAcquire: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns, Name: "lock_acquire_duration_seconds", Help: "Latency of Lock.Acquire by errno, 0 for acquired.",
+			Buckets: o.LockBuckets,
+		}, []string{"channel", "code"}),
+		connectionState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns, Name: "connection_state", Help: "State of the last ConnectionEvent, empty channel for the client.",
+		}, []string{"channel"}),
+		connectionEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "connection_events_total", Help: "ConnectionEvents by state and reason.",
+		}, []string{"state", "reason"}),
+		joinedTopics: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns, Name: "joined_topics", Help: "Topics joined as publisher.",
+		}, []string{"channel"}),
+		subscribedUsers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns, Name: "subscribed_users", Help: "Users subscribed on a topic.",
+		}, []string{"channel", "topic"}),
+	}
+	for _, c := range []prometheus.Collector{
+		m.operations, m.publishMessages, m.publishBytes, m.receiveMessages, m.receiveBytes, m.backlog,
+		m.lockAcquire, m.connectionState, m.connectionEvents, m.joinedTopics, m.subscribedUsers,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Instrument returns an RTMClient recording metrics of client.
+func (m *Metrics) Instrument(client rtm2.RTMClient) rtm2.RTMClient {
+	return &instrumentedClient{RTMClient: client, m: m, streams: make(map[string]*stream), subs: make(map[string]*forwarder[*rtm2.Message])}
+}
+
+func (m *Metrics) channel(channel string) string {
+	return m.opts.ChannelLabel(channel)
+}
+
+// observe counts an operation, and returns err as is.
+func (m *Metrics) observe(op string, err error) error {
+	m.operations.WithLabelValues(op, code(err)).Inc()
+	return err
+}
+
+func (m *Metrics) published(channel string, topic string, size int) {
+	labels := []string{m.channel(channel), topic}
+	m.publishMessages.WithLabelValues(labels...).Inc()
+	m.publishBytes.WithLabelValues(labels...).Add(float64(size))
+}
+
+func (m *Metrics) acquired(channel string, start time.Time, err error) {
+	m.lockAcquire.WithLabelValues(m.channel(channel), code(err)).Observe(time.Since(start).Seconds())
+}
+
+func code(err error) string {
+	if err == nil {
+		return "0"
+	}
+	if c := rtm2.ErrorCode(err); c != 0 {
+		return strconv.Itoa(c)
+	}
+	return "unknown"
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type presence struct {
+	rtm2.Presence
+	m *Metrics
+}
+
+func (p *presence) GetPresenceChan(channel string, channelType rtm2.ChannelType) (map[string]*rtm2.UserState, <-chan *rtm2.PresenceEvent, error) {
+	users, events, err := p.Presence.GetPresenceChan(channel, channelType)
+	return users, events, p.m.observe("Presence.GetPresenceChan", err)
+}
+
+func (p *presence) WhoNow(channel string, channelType rtm2.ChannelType, opts ...rtm2.PresenceOption) (map[string]*rtm2.UserState, string, error) {
+	users, next, err := p.Presence.WhoNow(channel, channelType, opts...)
+	return users, next, p.m.observe("Presence.WhoNow", err)
+}
+
+func (p *presence) WhereNow(userId string) ([]*rtm2.ChannelInfo, error) {
+	channels, err := p.Presence.WhereNow(userId)
+	return channels, p.m.observe("Presence.WhereNow", err)
+}
+
+func (p *presence) SetState(channel string, channelType rtm2.ChannelType, data map[string]string) error {
+	return p.m.observe("Presence.SetState", p.Presence.SetState(channel, channelType, data))
+}
+
+func (p *presence) RemoveState(channel string, channelType rtm2.ChannelType, keys []string) error {
+	return p.m.observe("Presence.RemoveState", p.Presence.RemoveState(channel, channelType, keys))
+}
+
+func (p *presence) GetState(channel string, channelType rtm2.ChannelType, userId string) (map[string]string, error) {
+	state, err := p.Presence.GetState(channel, channelType, userId)
+	return state, p.m.observe("Presence.GetState", err)
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type storage struct {
+	rtm2.Storage
+	m *Metrics
+}
+
+func (s *storage) GetChannelMetadataChan(channel string, channelType rtm2.ChannelType) (map[string]*rtm2.MetadataItem, <-chan *rtm2.StorageEvent, error) {
+	items, events, err := s.Storage.GetChannelMetadataChan(channel, channelType)
+	return items, events, s.m.observe("Storage.GetChannelMetadataChan", err)
+}
+
+func (s *storage) SetChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	return s.m.observe("Storage.SetChannelMetadata", s.Storage.SetChannelMetadata(channel, channelType, data, opts...))
+}
+
+func (s *storage) UpdateChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	return s.m.observe("Storage.UpdateChannelMetadata", s.Storage.UpdateChannelMetadata(channel, channelType, data, opts...))
+}
+
+func (s *storage) RemoveChannelMetadata(channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	return s.m.observe("Storage.RemoveChannelMetadata", s.Storage.RemoveChannelMetadata(channel, channelType, data, opts...))
+}
+
+func (s *storage) GetChannelMetadata(channel string, channelType rtm2.ChannelType) (int64, map[string]*rtm2.MetadataItem, error) {
+	rev, items, err := s.Storage.GetChannelMetadata(channel, channelType)
+	return rev, items, s.m.observe("Storage.GetChannelMetadata", err)
+}
+
+func (s *storage) SetUserMetadata(userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	return s.m.observe("Storage.SetUserMetadata", s.Storage.SetUserMetadata(userId, data, opts...))
+}
+
+func (s *storage) UpdateUserMetadata(userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	return s.m.observe("Storage.UpdateUserMetadata", s.Storage.UpdateUserMetadata(userId, data, opts...))
+}
+
+func (s *storage) RemoveUserMetadata(userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	return s.m.observe("Storage.RemoveUserMetadata", s.Storage.RemoveUserMetadata(userId, data, opts...))
+}
+
+func (s *storage) GetUserMetadata(userId string) (int64, map[string]*rtm2.MetadataItem, error) {
+	rev, items, err := s.Storage.GetUserMetadata(userId)
+	return rev, items, s.m.observe("Storage.GetUserMetadata", err)
+}
+
+func (s *storage) SubscribeUserMetadata(userId string) (map[string]*rtm2.MetadataItem, <-chan *rtm2.StorageEvent, error) {
+	items, events, err := s.Storage.SubscribeUserMetadata(userId)
+	return items, events, s.m.observe("Storage.SubscribeUserMetadata", err)
+}
+
+func (s *storage) UnsubscribeUserMetadata(userId string) error {
+	return s.m.observe("Storage.UnsubscribeUserMetadata", s.Storage.UnsubscribeUserMetadata(userId))
+}
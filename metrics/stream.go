@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type stream struct {
+	rtm2.StreamChannel
+	m *Metrics
+
+	mu     sync.Mutex
+	topics map[string]bool
+	subs   map[string]*forwarder[*rtm2.Message] // by topic
+}
+
+func (s *stream) Join(opts ...rtm2.StreamOption) (map[string][]string, <-chan *rtm2.TopicEvent, <-chan string, error) {
+	snapshot, events, tokens, err := s.StreamChannel.Join(opts...)
+	if s.m.observe("StreamChannel.Join", err) != nil {
+		return nil, nil, nil, err
+	}
+	return snapshot, events, tokens, nil
+}
+
+func (s *stream) Leave() error {
+	err := s.m.observe("StreamChannel.Leave", s.StreamChannel.Leave())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel := s.m.channel(s.ChannelName())
+	s.m.joinedTopics.DeleteLabelValues(channel)
+	s.m.subscribedUsers.DeletePartialMatch(map[string]string{"channel": channel})
+	s.m.connectionState.DeleteLabelValues(channel)
+	s.topics = make(map[string]bool)
+	s.closeTopicsLocked()
+	return nil
+}
+
+func (s *stream) closeTopics() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeTopicsLocked()
+}
+
+// closeTopicsLocked stops relaying the messages of all topics, and drops their backlogs.
+func (s *stream) closeTopicsLocked() {
+	for topic, f := range s.subs {
+		f.close()
+		delete(s.subs, topic)
+	}
+	s.m.backlog.DeletePartialMatch(map[string]string{"channel": s.m.channel(s.ChannelName())})
+}
+
+func (s *stream) JoinTopic(topic string, opts ...rtm2.StreamOption) error {
+	err := s.m.observe("StreamChannel.JoinTopic", s.StreamChannel.JoinTopic(topic, opts...))
+	if err == nil {
+		s.mu.Lock()
+		s.topics[topic] = true
+		s.m.joinedTopics.WithLabelValues(s.m.channel(s.ChannelName())).Set(float64(len(s.topics)))
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *stream) PublishTopic(topic string, message []byte, opts ...rtm2.StreamOption) error {
+	err := s.m.observe("StreamChannel.PublishTopic", s.StreamChannel.PublishTopic(topic, message, opts...))
+	if err == nil {
+		s.m.published(s.ChannelName(), topic, len(message))
+	}
+	return err
+}
+
+func (s *stream) LeaveTopic(topic string) error {
+	err := s.m.observe("StreamChannel.LeaveTopic", s.StreamChannel.LeaveTopic(topic))
+	if err == nil {
+		s.mu.Lock()
+		delete(s.topics, topic)
+		s.m.joinedTopics.WithLabelValues(s.m.channel(s.ChannelName())).Set(float64(len(s.topics)))
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// SubscribeTopic returns the same golang chan for the same topic, as long as the sdk does.
+func (s *stream) SubscribeTopic(topic string, userIds []string) (<-chan *rtm2.Message, error) {
+	messages, err := s.StreamChannel.SubscribeTopic(topic, userIds)
+	if s.m.observe("StreamChannel.SubscribeTopic", err) != nil {
+		return nil, err
+	}
+	s.updateUsers(topic)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.subs[topic]; ok {
+		if f.in == messages {
+			return f.out, nil
+		}
+		f.close()
+	}
+	f := s.m.messages(messages, s.ChannelName(), topic)
+	s.subs[topic] = f
+	return f.out, nil
+}
+
+// UnsubscribeTopic without userIds unsubscribes the whole topic, so it stops relaying its messages and drops its backlog.
+func (s *stream) UnsubscribeTopic(topic string, userIds []string) error {
+	err := s.m.observe("StreamChannel.UnsubscribeTopic", s.StreamChannel.UnsubscribeTopic(topic, userIds))
+	if err != nil {
+		return err
+	}
+	s.updateUsers(topic)
+	if len(userIds) > 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.subs[topic]; ok {
+		f.close()
+		delete(s.subs, topic)
+	}
+	s.m.backlog.DeleteLabelValues(s.m.channel(s.ChannelName()), topic)
+	return nil
+}
+
+func (s *stream) GetSubscribedUsers(topic string) ([]string, error) {
+	users, err := s.StreamChannel.GetSubscribedUsers(topic)
+	return users, s.m.observe("StreamChannel.GetSubscribedUsers", err)
+}
+
+func (s *stream) RenewToken(token string) error {
+	return s.m.observe("StreamChannel.RenewToken", s.StreamChannel.RenewToken(token))
+}
+
+func (s *stream) updateUsers(topic string) {
+	if users, err := s.StreamChannel.GetSubscribedUsers(topic); err == nil {
+		s.m.subscribedUsers.WithLabelValues(s.m.channel(s.ChannelName()), topic).Set(float64(len(users)))
+	}
+}
@@ -0,0 +1,428 @@
+// Package presence provides helpers on rtm2.Presence: Roster, a materialized view of the users of a channel,
+// paginated and bulk queries, and StateBinding, typed presence states.
+package presence
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+	"go.uber.org/zap"
+)
+
+type Options struct {
+	Logger *zap.Logger
+}
+
+type Option func(*Options)
+
+func WithLogger(lg *zap.Logger) Option {
+	return func(o *Options) {
+		o.Logger = lg
+	}
+}
+
+func newOptions(opts []Option) *Options {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+	return o
+}
+
+type ChangeType int
+
+const (
+	ChangeJoined ChangeType = iota
+	ChangeLeft
+	ChangeTimeout
+	ChangeState
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeJoined:
+		return "joined"
+	case ChangeLeft:
+		return "left"
+	case ChangeTimeout:
+		return "timeout"
+	case ChangeState:
+		return "state"
+	}
+	return "unknown"
+}
+
+// Change is a difference of the roster. State is the new state of the user, nil if left or timed out.
+type Change struct {
+	Type   ChangeType
+	UserId string
+	State  map[string]string
+}
+
+// Roster keeps the users of a channel and their states, merged from all shapes of PresenceEvent.
+// Snapshots and resyncs by WhoNowAll are applied as the changes from the current view.
+//
+// Roster consumes the PresenceEvent golang chan of the channel, so do not read it elsewhere.
+// ConnectionEvents are returned by Login only, so forward them through HandleConnectionEvent to resync after reconnection.
+type Roster struct {
+	presence    rtm2.Presence
+	channel     string
+	channelType rtm2.ChannelType
+	lg          *zap.Logger
+	ctx         context.Context
+	cancel      context.CancelFunc
+	resyncs     chan struct{}
+	stop        chan struct{}
+	detach      sync.Once
+
+	mu       sync.RWMutex
+	users    map[string]map[string]string
+	handlers map[int]func(*Change)
+	nextId   int
+	changes  chan *Change
+	box      *outbox.Outbox
+}
+
+// NewRoster starts from the snapshot of GetPresenceChan, so Presence of the channel must be subscribed,
+// e.g. by rtm2.WithMessagePresence.
+func NewRoster(presence rtm2.Presence, channel string, channelType rtm2.ChannelType, opts ...Option) (*Roster, error) {
+	snapshot, events, err := presence.GetPresenceChan(channel, channelType)
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Roster{
+		presence:    presence,
+		channel:     channel,
+		channelType: channelType,
+		lg:          o.Logger.With(zap.String("channel", channel)),
+		ctx:         ctx,
+		cancel:      cancel,
+		resyncs:     make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		users:       make(map[string]map[string]string, len(snapshot)),
+		handlers:    make(map[int]func(*Change)),
+		changes:     make(chan *Change),
+		box:         outbox.New(),
+	}
+	for userId, user := range snapshot {
+		r.users[userId] = copyState(user.State)
+	}
+	go r.run(events)
+	return r, nil
+}
+
+// run reads events until Detach. While a resync fetches users on another goroutine, events are queued,
+// then applied in order after the fetched users. After Close, events are drained and discarded.
+func (r *Roster) run(events <-chan *rtm2.PresenceEvent) {
+	var (
+		syncing bool // a resync is fetching
+		pending bool // another resync is requested while fetching
+		queue   []*rtm2.PresenceEvent
+	)
+	results := make(chan map[string]map[string]string)
+	closed := r.ctx.Done()
+	resync := func() {
+		if syncing {
+			pending = true
+			return
+		}
+		syncing = true
+		go r.fetch(results)
+	}
+	for {
+		select {
+		case e := <-events:
+			switch {
+			case r.ctx.Err() != nil:
+			case e.Type == rtm2.PresenceTypeOutOfService:
+				r.lg.Info("presence out of service, resync")
+				resync()
+			case syncing:
+				queue = append(queue, e)
+			default:
+				r.apply(e)
+			}
+		case <-r.resyncs:
+			if r.ctx.Err() == nil {
+				resync()
+			}
+		case states := <-results:
+			r.replace(states)
+			for _, e := range queue {
+				r.apply(e)
+			}
+			queue, syncing = nil, false
+			if pending {
+				pending = false
+				resync()
+			}
+		case <-closed:
+			// a fetch in flight gives up, drop its queue
+			closed = nil
+			queue, syncing, pending = nil, false, false
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *Roster) apply(e *rtm2.PresenceEvent) {
+	switch e.Type {
+	case rtm2.PresenceTypeSnapshot:
+		r.replace(e.States)
+	case rtm2.PresenceTypeInterval:
+		// users in States are present after the interval, even if they have left and joined again.
+		// A user both joined and left without states may be either, so the user is kept and the roster resyncs.
+		var changes []*Change
+		joined := make(map[string]bool, len(e.Joined))
+		ambiguous := false
+		r.mu.Lock()
+		for _, userId := range e.Joined {
+			joined[userId] = true
+			changes = r.set(changes, userId, e.States[userId])
+		}
+		for _, userId := range e.Left {
+			if _, ok := e.States[userId]; ok {
+				continue
+			}
+			if joined[userId] {
+				ambiguous = true
+				continue
+			}
+			changes = r.remove(changes, ChangeLeft, userId)
+		}
+		for _, userId := range e.Timeout {
+			if _, ok := e.States[userId]; ok {
+				continue
+			}
+			if joined[userId] {
+				ambiguous = true
+				continue
+			}
+			changes = r.remove(changes, ChangeTimeout, userId)
+		}
+		for _, userId := range sortedKeys(e.States) {
+			changes = r.set(changes, userId, e.States[userId])
+		}
+		r.mu.Unlock()
+		r.notify(changes)
+		if ambiguous {
+			r.lg.Info("users both joined and left in an interval, resync")
+			r.Resync()
+		}
+	case rtm2.PresenceTypeJoinChannel, rtm2.PresenceTypeStateChange:
+		r.mu.Lock()
+		changes := r.set(nil, e.UserId, e.Items)
+		r.mu.Unlock()
+		r.notify(changes)
+	case rtm2.PresenceTypeLeaveChannel:
+		r.mu.Lock()
+		changes := r.remove(nil, ChangeLeft, e.UserId)
+		r.mu.Unlock()
+		r.notify(changes)
+	case rtm2.PresenceTypeTimeout:
+		r.mu.Lock()
+		changes := r.remove(nil, ChangeTimeout, e.UserId)
+		r.mu.Unlock()
+		r.notify(changes)
+	}
+}
+
+// set must be called with r.mu locked.
+func (r *Roster) set(changes []*Change, userId string, state map[string]string) []*Change {
+	old, ok := r.users[userId]
+	if ok && maps.Equal(old, state) {
+		return changes
+	}
+	state = copyState(state)
+	r.users[userId] = state
+	t := ChangeState
+	if !ok {
+		t = ChangeJoined
+	}
+	return append(changes, &Change{Type: t, UserId: userId, State: copyState(state)})
+}
+
+// remove must be called with r.mu locked.
+func (r *Roster) remove(changes []*Change, t ChangeType, userId string) []*Change {
+	if _, ok := r.users[userId]; !ok {
+		return changes
+	}
+	delete(r.users, userId)
+	return append(changes, &Change{Type: t, UserId: userId})
+}
+
+// replace applies the full states of a snapshot or resync as changes.
+func (r *Roster) replace(states map[string]map[string]string) {
+	var changes []*Change
+	r.mu.Lock()
+	for _, userId := range sortedKeys(r.users) {
+		if _, ok := states[userId]; !ok {
+			changes = r.remove(changes, ChangeLeft, userId)
+		}
+	}
+	for _, userId := range sortedKeys(states) {
+		changes = r.set(changes, userId, states[userId])
+	}
+	r.mu.Unlock()
+	r.notify(changes)
+}
+
+// fetch reads all users by WhoNowAll and sends their states to results,
+// retrying with backoff until it succeeds or the roster is closed.
+func (r *Roster) fetch(results chan<- map[string]map[string]string) {
+	o := newScanOptions(nil)
+	backoff := o.MinBackoff
+	for {
+		states := make(map[string]map[string]string)
+		it := WhoNowAll(r.ctx, r.presence, r.channel, r.channelType, WithState(true))
+		for it.Next() {
+			states[it.User().UserId] = it.User().State
+		}
+		err := it.Err()
+		if err == nil {
+			select {
+			case results <- states:
+			case <-r.ctx.Done():
+			case <-r.stop:
+			}
+			return
+		}
+		r.lg.Warn("resync presence failed", zap.Error(err), zap.Duration("backoff", backoff))
+		if sleep(r.ctx, backoff) != nil {
+			return
+		}
+		backoff = min(backoff*2, o.MaxBackoff)
+	}
+}
+
+// notify runs handlers in the order of changes, then sends changes to the golang chan of Changes.
+func (r *Roster) notify(changes []*Change) {
+	if len(changes) == 0 {
+		return
+	}
+	r.mu.RLock()
+	handlers := make([]func(*Change), 0, len(r.handlers))
+	for _, id := range sortedKeys(r.handlers) {
+		handlers = append(handlers, r.handlers[id])
+	}
+	r.mu.RUnlock()
+	for _, c := range changes {
+		for _, h := range handlers {
+			h(c)
+		}
+		c := c
+		r.box.Push(func(done <-chan struct{}) {
+			select {
+			case r.changes <- c:
+			case <-done:
+			}
+		})
+	}
+}
+
+// HandleConnectionEvent resyncs the roster after ConnectionChangedReasonRejoinSuccess of the client or the channel.
+func (r *Roster) HandleConnectionEvent(e *rtm2.ConnectionEvent) {
+	if e.State != rtm2.ConnectionStateCONNECTED || e.Reason != rtm2.ConnectionChangedReasonRejoinSuccess {
+		return
+	}
+	if e.Channel != "" && e.Channel != r.channel {
+		return
+	}
+	r.Resync()
+}
+
+// Resync schedules a resync through paginated WhoNow, applied in order with PresenceEvents.
+// PresenceEvents received meanwhile are applied after the users read, and a failed resync is retried with backoff.
+func (r *Roster) Resync() {
+	select {
+	case r.resyncs <- struct{}{}:
+	default:
+	}
+}
+
+// Users returns the user ids in order.
+func (r *Roster) Users() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return sortedKeys(r.users)
+}
+
+// State returns a copy of the state of userId, and false if the user is not in the channel.
+func (r *Roster) State(userId string) (map[string]string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	state, ok := r.users[userId]
+	if !ok {
+		return nil, false
+	}
+	return copyState(state), true
+}
+
+// Len returns the number of users.
+func (r *Roster) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.users)
+}
+
+// OnChange registers h, called on every change until cancel is called.
+// Handlers run in order on the goroutine applying events, so they must not block, but may call methods of Roster.
+func (r *Roster) OnChange(h func(*Change)) (cancel func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.nextId
+	r.nextId++
+	r.handlers[id] = h
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.handlers, id)
+	}
+}
+
+// Changes returns the golang chan receiving every change, which never blocks applying events.
+func (r *Roster) Changes() <-chan *Change {
+	return r.changes
+}
+
+// Close stops applying events. The golang chan of Changes is left open, same as rtm sdk.
+// PresenceEvents are still drained, since the rtm sdk blocks on an unread chan, until Detach.
+func (r *Roster) Close() {
+	r.cancel()
+	r.box.Close()
+}
+
+// Detach stops draining the PresenceEvent golang chan. Call it after Close once Presence of the channel is unsubscribed,
+// e.g. after Unsubscribe, so that a later Roster reads the chan of the new subscription.
+func (r *Roster) Detach() {
+	r.detach.Do(func() {
+		close(r.stop)
+	})
+}
+
+func copyState(state map[string]string) map[string]string {
+	res := make(map[string]string, len(state))
+	for k, v := range state {
+		res[k] = v
+	}
+	return res
+}
+
+func sortedKeys[K ~string | ~int, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -0,0 +1,273 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+)
+
+type ScanOptions struct {
+	// State includes user states in WhoNow results.
+	State bool
+	// MinBackoff and MaxBackoff bound the delay between retries of transient errors, e.g. ERR_PRESENCE_SERVICE_NOT_READY,
+	// 100 milliseconds and 5 seconds by default. MaxRetries is 5 by default, negative for none.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	MaxRetries int
+	// Interval is the minimal delay between calls, to stay under the rate limit of the rtm service. No limit by default.
+	Interval time.Duration
+	// Parallelism bounds concurrent calls of WhereNowAll, 4 by default.
+	Parallelism int
+}
+
+type ScanOption func(*ScanOptions)
+
+func WithState(enabled bool) ScanOption {
+	return func(o *ScanOptions) {
+		o.State = enabled
+	}
+}
+
+// WithBackoff sets the delay between retries, doubled on each transient error from min up to max.
+func WithBackoff(min, max time.Duration) ScanOption {
+	return func(o *ScanOptions) {
+		o.MinBackoff = min
+		o.MaxBackoff = max
+	}
+}
+
+func WithMaxRetries(n int) ScanOption {
+	return func(o *ScanOptions) {
+		o.MaxRetries = n
+	}
+}
+
+// WithRateLimit spaces calls by interval, shared by all workers of WhereNowAll.
+func WithRateLimit(interval time.Duration) ScanOption {
+	return func(o *ScanOptions) {
+		o.Interval = interval
+	}
+}
+
+func WithParallelism(n int) ScanOption {
+	return func(o *ScanOptions) {
+		o.Parallelism = n
+	}
+}
+
+func newScanOptions(opts []ScanOption) *ScanOptions {
+	o := &ScanOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 100 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 5 * time.Second
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = o.MinBackoff
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 5
+	}
+	if o.Parallelism <= 0 {
+		o.Parallelism = 4
+	}
+	return o
+}
+
+// scanner paces and retries calls.
+type scanner struct {
+	o *ScanOptions
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait reserves the next slot of the rate limit.
+func (s *scanner) wait(ctx context.Context) error {
+	if s.o.Interval <= 0 {
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	now := time.Now()
+	at := s.next
+	if at.Before(now) {
+		at = now
+	}
+	s.next = at.Add(s.o.Interval)
+	s.mu.Unlock()
+	return sleep(ctx, at.Sub(now))
+}
+
+// call runs f within the rate limit, and retries it on transient errors.
+func (s *scanner) call(ctx context.Context, f func() error) error {
+	backoff := s.o.MinBackoff
+	for attempt := 0; ; attempt++ {
+		if err := s.wait(ctx); err != nil {
+			return err
+		}
+		err := f()
+		if err == nil || !rtm2.IsRetryable(err) || attempt >= s.o.MaxRetries {
+			return err
+		}
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff = min(backoff*2, s.o.MaxBackoff)
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// UserIterator iterates over users of a channel page by page of WhoNow:
+//
+//	it := presence.WhoNowAll(ctx, client.Presence(), channel, rtm2.ChannelTypeMessage)
+//	for it.Next() {
+//		user := it.User()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+//
+// Users joining or leaving during the iteration may be missed or repeated, as pages are read at different times.
+type UserIterator struct {
+	ctx         context.Context
+	presence    rtm2.Presence
+	channel     string
+	channelType rtm2.ChannelType
+	s           *scanner
+
+	page  []*rtm2.UserState
+	user  *rtm2.UserState
+	next  string
+	done  bool
+	err   error
+	pages int
+}
+
+// WhoNowAll returns an iterator over all users of the channel. Pages are requested lazily by Next.
+func WhoNowAll(ctx context.Context, presence rtm2.Presence, channel string, channelType rtm2.ChannelType, opts ...ScanOption) *UserIterator {
+	return &UserIterator{ctx: ctx, presence: presence, channel: channel, channelType: channelType, s: &scanner{o: newScanOptions(opts)}}
+}
+
+// Next advances to the next user, and returns false at the end or on error.
+func (it *UserIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			it.user = nil
+			return false
+		}
+		it.fetch()
+	}
+	it.user, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+func (it *UserIterator) fetch() {
+	opts := []rtm2.PresenceOption{rtm2.WithPresenceUserId(true), rtm2.WithPresenceState(it.s.o.State)}
+	if it.next != "" {
+		opts = append(opts, rtm2.WithPage(it.next))
+	}
+	var users map[string]*rtm2.UserState
+	var next string
+	it.err = it.s.call(it.ctx, func() error {
+		var err error
+		users, next, err = it.presence.WhoNow(it.channel, it.channelType, opts...)
+		return err
+	})
+	if it.err != nil {
+		it.err = fmt.Errorf("who now %s page %d: %w", it.channel, it.pages, it.err)
+		return
+	}
+	it.pages++
+	it.page = make([]*rtm2.UserState, 0, len(users))
+	for userId, user := range users {
+		if user.UserId == "" {
+			user.UserId = userId
+		}
+		it.page = append(it.page, user)
+	}
+	sort.Slice(it.page, func(i, j int) bool { return it.page[i].UserId < it.page[j].UserId })
+	it.next = next
+	it.done = next == ""
+}
+
+// User returns the current user.
+func (it *UserIterator) User() *rtm2.UserState {
+	return it.user
+}
+
+// Err returns the error stopping the iteration, nil if all users are iterated.
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+// WhereNowAll runs WhereNow for each of userIds, with bounded parallelism and retries of transient errors.
+// Users not logged in, reported by ERR_PRESENCE_USER_NOT_EXIST, have no channels.
+// On errors, the channels of other users are returned along with the joined errors.
+func WhereNowAll(ctx context.Context, presence rtm2.Presence, userIds []string, opts ...ScanOption) (map[string][]*rtm2.ChannelInfo, error) {
+	s := &scanner{o: newScanOptions(opts)}
+	res := make(map[string][]*rtm2.ChannelInfo, len(userIds))
+	var errs []error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan string)
+	for i := 0; i < min(s.o.Parallelism, len(userIds)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userId := range work {
+				var channels []*rtm2.ChannelInfo
+				err := s.call(ctx, func() error {
+					var err error
+					channels, err = presence.WhereNow(userId)
+					return err
+				})
+				if errors.Is(err, rtm2.ERR_PRESENCE_USER_NOT_EXIST) {
+					channels, err = nil, nil
+				}
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("where now %s: %w", userId, err))
+				} else {
+					res[userId] = channels
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, userId := range userIds {
+		select {
+		case work <- userId:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	// users never tried
+	if err := ctx.Err(); err != nil && len(res)+len(errs) < len(userIds) {
+		errs = append(errs, err)
+	}
+	return res, errors.Join(errs...)
+}
@@ -0,0 +1,210 @@
+package presence
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/internal/tagcodec"
+)
+
+// ErrNotStruct is returned by BindState if the type is not a struct.
+var ErrNotStruct = errors.New("presence: bound type must be a struct")
+
+// FieldError is an error of a struct field bound to a state key.
+type FieldError struct {
+	Field string
+	Key   string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("presence: field %s (key %q): %v", e.Field, e.Key, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// StateBinding maps exported fields of struct T with `rtm:"key"` tags to presence state keys of a channel.
+// Strings, booleans and numbers are encoded as their text, other types as JSON.
+// With `rtm:"key,omitempty"`, a zero value removes the key. Fields without tag are ignored.
+type StateBinding[T any] struct {
+	presence    rtm2.Presence
+	channel     string
+	channelType rtm2.ChannelType
+	fields      []tagcodec.Field
+}
+
+// BindState binds T to the presence state of the channel. Keys are validated against rtm2.PresenceStateKeyMaxSize.
+func BindState[T any](presence rtm2.Presence, channel string, channelType rtm2.ChannelType) (*StateBinding[T], error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+	b := &StateBinding[T]{presence: presence, channel: channel, channelType: channelType, fields: tagcodec.Fields(typ)}
+	keys := make(map[string]bool)
+	var errs []error
+	for _, f := range b.fields {
+		switch {
+		case f.Key == "":
+			errs = append(errs, &FieldError{Field: f.Name, Key: f.Key, Err: rtm2.ERR_PRESENCE_STATE_INVALID_KEY})
+		case len(f.Key) > rtm2.PresenceStateKeyMaxSize:
+			errs = append(errs, &FieldError{Field: f.Name, Key: f.Key, Err: rtm2.ERR_PRESENCE_STATE_KEY_SIZE_OVERFLOW})
+		case keys[f.Key]:
+			errs = append(errs, &FieldError{Field: f.Name, Key: f.Key, Err: rtm2.ERR_PRESENCE_STATE_DUPLICATE_KEY})
+		}
+		keys[f.Key] = true
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Encode returns the state of v, without keys of omitempty fields with zero values.
+// Values are validated against rtm2.PresenceStateValueMaxSize, and the state against rtm2.PresenceStateMaxSize.
+// Keys set apart from the binding are not counted.
+func (b *StateBinding[T]) Encode(v T) (map[string]string, error) {
+	rv := reflect.ValueOf(v)
+	state := make(map[string]string, len(b.fields))
+	var errs []error
+	size := 0
+	for _, f := range b.fields {
+		fv := rv.Field(f.Index)
+		if f.OmitEmpty && fv.IsZero() {
+			continue
+		}
+		value, err := tagcodec.Encode(fv)
+		if err == nil && len(value) > rtm2.PresenceStateValueMaxSize {
+			err = rtm2.ERR_PRESENCE_STATE_VALUE_SIZE_OVERFLOW
+		}
+		if err != nil {
+			errs = append(errs, &FieldError{Field: f.Name, Key: f.Key, Err: err})
+			continue
+		}
+		size += len(f.Key) + len(value)
+		state[f.Key] = value
+	}
+	if size > rtm2.PresenceStateMaxSize {
+		errs = append(errs, rtm2.ERR_PRESENCE_STATE_SIZE_OVERFLOW)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Diff returns the minimal changes from old to new: keys with new values to set, and keys of old to remove.
+func (b *StateBinding[T]) Diff(old, new T) (sets map[string]string, removes []string, err error) {
+	from, err := b.Encode(old)
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err := b.Encode(new)
+	if err != nil {
+		return nil, nil, err
+	}
+	sets = make(map[string]string)
+	for k, v := range to {
+		if prev, ok := from[k]; !ok || prev != v {
+			sets[k] = v
+		}
+	}
+	for k := range from {
+		if _, ok := to[k]; !ok {
+			removes = append(removes, k)
+		}
+	}
+	sort.Strings(removes)
+	return sets, removes, nil
+}
+
+// Set sets the full state of v, and removes keys of omitempty fields with zero values.
+func (b *StateBinding[T]) Set(v T) error {
+	state, err := b.Encode(v)
+	if err != nil {
+		return err
+	}
+	var removes []string
+	for _, f := range b.fields {
+		if _, ok := state[f.Key]; !ok {
+			removes = append(removes, f.Key)
+		}
+	}
+	return b.apply(state, removes)
+}
+
+// Update sends only the changes from old to new by SetState and RemoveState, nothing if they are equal.
+func (b *StateBinding[T]) Update(old, new T) error {
+	sets, removes, err := b.Diff(old, new)
+	if err != nil {
+		return err
+	}
+	return b.apply(sets, removes)
+}
+
+func (b *StateBinding[T]) apply(sets map[string]string, removes []string) error {
+	if len(sets) > 0 {
+		if err := b.presence.SetState(b.channel, b.channelType, sets); err != nil {
+			return err
+		}
+	}
+	if len(removes) > 0 {
+		return b.presence.RemoveState(b.channel, b.channelType, removes)
+	}
+	return nil
+}
+
+// Decode reads the state into v. Fields whose keys do not exist are left untouched.
+func (b *StateBinding[T]) Decode(state map[string]string, v *T) error {
+	rv := reflect.ValueOf(v).Elem()
+	var errs []error
+	for _, f := range b.fields {
+		value, ok := state[f.Key]
+		if !ok {
+			continue
+		}
+		if err := tagcodec.Decode(value, rv.Field(f.Index)); err != nil {
+			errs = append(errs, &FieldError{Field: f.Name, Key: f.Key, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DecodeEvent decodes the states carried by e, by user id: Items of UserId for join and state change events,
+// States for snapshot and interval events. Users failing to decode are left out, and their errors joined.
+func (b *StateBinding[T]) DecodeEvent(e *rtm2.PresenceEvent) (map[string]T, error) {
+	states := e.States
+	switch e.Type {
+	case rtm2.PresenceTypeJoinChannel, rtm2.PresenceTypeStateChange:
+		states = map[string]map[string]string{e.UserId: e.Items}
+	case rtm2.PresenceTypeSnapshot, rtm2.PresenceTypeInterval:
+	default:
+		return nil, nil
+	}
+	res := make(map[string]T, len(states))
+	var errs []error
+	for userId, state := range states {
+		var v T
+		if err := b.Decode(state, &v); err != nil {
+			errs = append(errs, fmt.Errorf("user %s: %w", userId, err))
+			continue
+		}
+		res[userId] = v
+	}
+	return res, errors.Join(errs...)
+}
+
+// Get queries the state of userId and decodes it.
+func (b *StateBinding[T]) Get(userId string) (T, error) {
+	var v T
+	state, err := b.presence.GetState(b.channel, b.channelType, userId)
+	if err != nil {
+		return v, err
+	}
+	err = b.Decode(state, &v)
+	return v, err
+}
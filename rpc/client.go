@@ -0,0 +1,190 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/codec"
+	"go.uber.org/zap"
+)
+
+// Response is a successful response frame.
+type Response struct {
+	UserId  string // the server
+	Payload []byte // with the envelope header
+	codec   codec.Codec
+}
+
+// Decode unmarshals the payload into v.
+func (r *Response) Decode(v interface{}) error {
+	return codec.Decode(r.Payload, r.codec, v)
+}
+
+type reply struct {
+	userId string
+	f      *frame
+}
+
+type Client struct {
+	client rtm2.RTMClient
+	opts   *Options
+	lg     *zap.Logger
+	prefix string
+	inbox  string
+	// responders accepted, any user if empty
+	responders map[string]bool
+
+	mu      sync.Mutex
+	nextId  uint64
+	pending map[string]chan *reply
+	stop    chan struct{}
+	closed  bool
+}
+
+// NewClient subscribes the inbox of userId, who must be the user logged in by client.
+func NewClient(client rtm2.RTMClient, userId string, opts ...Option) (*Client, error) {
+	o := newOptions(opts)
+	inbox := o.InboxPrefix + userId
+	messages, err := client.Subscribe(inbox, rtm2.WithMessagePresence(false))
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	c := &Client{
+		client:  client,
+		opts:    o,
+		lg:      o.Logger.With(zap.String("inbox", inbox)),
+		inbox:   inbox,
+		prefix:  userId + "-" + hex.EncodeToString(b) + "-",
+		pending: make(map[string]chan *reply),
+		stop:    make(chan struct{}),
+	}
+	if len(o.Responders) > 0 {
+		c.responders = make(map[string]bool, len(o.Responders))
+		for _, userId := range o.Responders {
+			c.responders[userId] = true
+		}
+	}
+	go c.receive(messages)
+	return c, nil
+}
+
+func (c *Client) receive(messages <-chan *rtm2.Message) {
+	for {
+		select {
+		case m := <-messages:
+			f := &frame{}
+			if err := json.Unmarshal(m.Message, f); err != nil || f.Kind != kindResponse {
+				c.lg.Debug("drop message", zap.String("userId", m.UserId))
+				continue
+			}
+			if c.responders != nil && !c.responders[m.UserId] {
+				c.lg.Warn("drop response of an unexpected user", zap.String("userId", m.UserId), zap.String("id", f.Id))
+				continue
+			}
+			c.mu.Lock()
+			wait, ok := c.pending[f.Id]
+			delete(c.pending, f.Id)
+			c.mu.Unlock()
+			if ok {
+				wait <- &reply{userId: m.UserId, f: f}
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Call publishes a request to the Message Channel and waits for the first response,
+// of any member of the channel unless WithResponders is set.
+// Errors of the server are returned as RTMError wrapped in rtm2.OpError, ErrMethodNotFound or RemoteError.
+func (c *Client) Call(ctx context.Context, channel string, method string, req interface{}) (*Response, error) {
+	return c.call(ctx, method, channel, "", req, func(data []byte) error {
+		return c.client.Publish(channel, data, rtm2.WithMessageType(rtm2.MessageTypeString))
+	})
+}
+
+// CallTopic publishes a request to a joined topic and waits for the response.
+func (c *Client) CallTopic(ctx context.Context, stream rtm2.StreamChannel, topic string, method string, req interface{}) (*Response, error) {
+	return c.call(ctx, method, stream.ChannelName(), topic, req, func(data []byte) error {
+		return stream.PublishTopic(topic, data, rtm2.WithStreamMessageType(rtm2.MessageTypeString))
+	})
+}
+
+// CallTyped calls by c and decodes the response into Resp.
+func CallTyped[Resp any](ctx context.Context, c *Client, channel string, method string, req interface{}) (Resp, error) {
+	var resp Resp
+	r, err := c.Call(ctx, channel, method, req)
+	if err != nil {
+		return resp, err
+	}
+	return codec.DecodeTyped[Resp](&rtm2.Message{UserId: r.UserId, Message: r.Payload}, r.codec)
+}
+
+func (c *Client) call(ctx context.Context, method string, channel string, topic string, req interface{}, publish func(data []byte) error) (*Response, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+	payload, err := codec.Encode(c.opts.Codec, req)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	wait := make(chan *reply, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	c.nextId++
+	id := c.prefix + strconv.FormatUint(c.nextId, 10)
+	c.pending[id] = wait
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	f := &frame{Kind: kindRequest, Id: id, Method: method, Timeout: time.Until(deadline).Milliseconds(), Payload: payload}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := publish(data); err != nil {
+		return nil, rtm2.WrapError(err, "rpc."+method, channel, topic)
+	}
+	select {
+	case r := <-wait:
+		if err := errorOf(r.f, method, channel, topic); err != nil {
+			return nil, err
+		}
+		return &Response{UserId: r.userId, Payload: r.f.Payload, codec: c.opts.Codec}, nil
+	case <-ctx.Done():
+		return nil, rtm2.WrapError(ctx.Err(), "rpc."+method, channel, topic)
+	case <-c.stop:
+		return nil, ErrClosed
+	}
+}
+
+// Close unsubscribes the inbox, pending calls return ErrClosed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.stop)
+	c.mu.Unlock()
+	return c.client.Unsubscribe(c.inbox)
+}
@@ -0,0 +1,136 @@
+// Package rpc implements request / response calls on top of Message Channels and Stream Channel topics.
+//
+// A Server handles requests published to a Message Channel or a topic.
+// Each Client subscribes its own inbox Message Channel, "rpc.inbox.<userId>" by default,
+// and every request carries a correlation id. The Server replies to the inbox of the user publishing the request,
+// never to a channel named by the request, so a caller can not make the Server publish elsewhere.
+// Inboxes are public Message Channels: anyone in the app may subscribe to an inbox and read the responses,
+// so do not return secrets in responses, or encrypt them in the payload.
+// Correlation ids are visible to every member of the request channel, so by default any of them can answer a call,
+// and the first response wins. Set WithResponders to accept responses of trusted servers only.
+//
+// Frames are JSON objects published as MessageTypeString:
+//
+//	{"kind":"req","id":"<correlation id>","method":"echo","timeout":5000,"payload":"<base64>"}
+//	{"kind":"resp","id":"<correlation id>","payload":"<base64>"}
+//	{"kind":"resp","id":"<correlation id>","code":10009,"error":"10009: ERR_NOT_JOIN_CHANNEL"}
+//
+// Payloads are encoded by the codec package, with the envelope header.
+// code is the errno of RTMError, or codeMethodNotFound / codeHandler for errors of the server.
+package rpc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/codec"
+	"go.uber.org/zap"
+)
+
+const (
+	kindRequest  = "req"
+	kindResponse = "resp"
+
+	codeHandler        = -1
+	codeMethodNotFound = -2
+)
+
+var (
+	ErrMethodNotFound = errors.New("rpc method not found")
+	ErrClosed         = errors.New("rpc client closed")
+)
+
+// RemoteError is returned by Call when the handler fails with an error other than RTMError.
+type RemoteError struct {
+	Method string
+	Msg    string
+}
+
+func (e *RemoteError) Error() string {
+	return "rpc " + e.Method + ": " + e.Msg
+}
+
+type frame struct {
+	Kind    string `json:"kind"`
+	Id      string `json:"id"`
+	Method  string `json:"method,omitempty"`
+	Timeout int64  `json:"timeout,omitempty"` // milliseconds
+	Payload []byte `json:"payload,omitempty"`
+	Code    int    `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Options struct {
+	Codec  codec.Codec
+	Logger *zap.Logger
+	// Timeout of Call if the context has no deadline, 10 seconds by default.
+	Timeout time.Duration
+	// InboxPrefix of the Message Channels receiving responses, followed by the user id.
+	// Servers and clients must use the same one.
+	InboxPrefix string
+	// Responders are the user ids whose responses a Client accepts, any user if empty.
+	Responders []string
+}
+
+type Option func(*Options)
+
+// WithCodec sets the codec of payloads, codec.JSON by default.
+func WithCodec(c codec.Codec) Option {
+	return func(o *Options) {
+		o.Codec = c
+	}
+}
+
+func WithLogger(lg *zap.Logger) Option {
+	return func(o *Options) {
+		o.Logger = lg
+	}
+}
+
+// WithTimeout sets the timeout of Call if the context has no deadline.
+func WithTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.Timeout = d
+	}
+}
+
+// WithInboxPrefix sets the prefix of inbox Message Channels, "rpc.inbox." by default.
+func WithInboxPrefix(prefix string) Option {
+	return func(o *Options) {
+		o.InboxPrefix = prefix
+	}
+}
+
+// WithResponders accepts responses of the users only, e.g. the servers of the channel.
+// Responses of other users are dropped, and the call keeps waiting.
+func WithResponders(userIds ...string) Option {
+	return func(o *Options) {
+		o.Responders = userIds
+	}
+}
+
+func newOptions(opts []Option) *Options {
+	o := &Options{Codec: codec.JSON, Timeout: 10 * time.Second, InboxPrefix: "rpc.inbox."}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+	return o
+}
+
+// errorOf converts a response frame to the error returned by Call.
+func errorOf(f *frame, method string, channel string, topic string) error {
+	switch f.Code {
+	case 0:
+		return nil
+	case codeMethodNotFound:
+		return rtm2.WrapError(ErrMethodNotFound, "rpc."+method, channel, topic)
+	case codeHandler:
+		return &RemoteError{Method: method, Msg: f.Error}
+	default:
+		return rtm2.WrapError(rtm2.ErrorFromCode(int32(f.Code)), "rpc."+method, channel, topic)
+	}
+}
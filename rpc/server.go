@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/codec"
+	"go.uber.org/zap"
+)
+
+// Request is a decoded request frame.
+type Request struct {
+	UserId  string // the caller
+	Method  string
+	Payload []byte // with the envelope header
+	codec   codec.Codec
+}
+
+// Decode unmarshals the payload into v.
+func (r *Request) Decode(v interface{}) error {
+	return codec.Decode(r.Payload, r.codec, v)
+}
+
+// Handler returns the response to be encoded by the codec of the Server.
+// Return an RTMError to propagate its errno to the caller.
+type Handler func(ctx context.Context, req *Request) (interface{}, error)
+
+type Server struct {
+	client rtm2.RTMClient
+	opts   *Options
+	lg     *zap.Logger
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// NewServer creates a Server publishing responses by client. The client must be logged in before Serve.
+func NewServer(client rtm2.RTMClient, opts ...Option) *Server {
+	o := newOptions(opts)
+	return &Server{client: client, opts: o, lg: o.Logger, handlers: make(map[string]Handler), stop: make(chan struct{})}
+}
+
+// Handle registers h for method, replacing the previous one.
+func (s *Server) Handle(method string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = h
+}
+
+// HandleTyped registers a handler with decoded request of type Req.
+func HandleTyped[Req, Resp any](s *Server, method string, h func(ctx context.Context, userId string, req Req) (Resp, error)) {
+	s.Handle(method, func(ctx context.Context, r *Request) (interface{}, error) {
+		req, err := codec.DecodeTyped[Req](&rtm2.Message{UserId: r.UserId, Message: r.Payload}, r.codec)
+		if err != nil {
+			return nil, err
+		}
+		return h(ctx, r.UserId, req)
+	})
+}
+
+// Serve subscribes the Message Channel and handles requests published to it.
+func (s *Server) Serve(channel string, opts ...rtm2.MessageOption) error {
+	messages, err := s.client.Subscribe(channel, opts...)
+	if err != nil {
+		return err
+	}
+	go s.serve(messages, channel)
+	return nil
+}
+
+// ServeTopic subscribes the topic on certain users, empty for all, and handles requests published to it.
+func (s *Server) ServeTopic(stream rtm2.StreamChannel, topic string, userIds []string) error {
+	messages, err := stream.SubscribeTopic(topic, userIds)
+	if err != nil {
+		return err
+	}
+	go s.serve(messages, stream.ChannelName()+"/"+topic)
+	return nil
+}
+
+// Close stops serving. Subscriptions are left to the caller.
+func (s *Server) Close() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *Server) serve(messages <-chan *rtm2.Message, name string) {
+	lg := s.lg.With(zap.String("channel", name))
+	for {
+		select {
+		case m := <-messages:
+			f := &frame{}
+			if err := json.Unmarshal(m.Message, f); err != nil || f.Kind != kindRequest {
+				lg.Debug("drop message", zap.String("userId", m.UserId))
+				continue
+			}
+			go s.handle(lg, m.UserId, f)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Server) handle(lg *zap.Logger, userId string, f *frame) {
+	resp := &frame{Kind: kindResponse, Id: f.Id}
+	s.mu.RLock()
+	h, ok := s.handlers[f.Method]
+	s.mu.RUnlock()
+	if !ok {
+		resp.Code = codeMethodNotFound
+		resp.Error = ErrMethodNotFound.Error()
+	} else {
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if f.Timeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), time.Duration(f.Timeout)*time.Millisecond)
+		} else {
+			ctx, cancel = context.WithCancel(context.Background())
+		}
+		v, err := h(ctx, &Request{UserId: userId, Method: f.Method, Payload: f.Payload, codec: s.opts.Codec})
+		cancel()
+		if err == nil {
+			resp.Payload, err = codec.Encode(s.opts.Codec, v)
+		}
+		if err != nil {
+			resp.Code = rtm2.ErrorCode(err)
+			if resp.Code == 0 {
+				resp.Code = codeHandler
+			}
+			resp.Error = err.Error()
+		}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		lg.Warn("marshal response failed", zap.String("method", f.Method), zap.Error(err))
+		return
+	}
+	// reply to the inbox of the caller only, whatever the request claims
+	inbox := s.opts.InboxPrefix + userId
+	if err := s.client.Publish(inbox, data, rtm2.WithMessageType(rtm2.MessageTypeString)); err != nil {
+		lg.Warn("reply failed", zap.String("method", f.Method), zap.String("inbox", inbox), zap.Error(err))
+	}
+}
@@ -0,0 +1,53 @@
+package session
+
+import (
+	"sort"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+type locks struct {
+	rtm2.Lock
+	m *Manager
+}
+
+// GetLockChan returns the same golang chan for the same channel, until Unsubscribe or Leave.
+// After the channel is joined again, a LockTypeSnapshot event is injected.
+func (l *locks) GetLockChan(channel string, channelType rtm2.ChannelType) (map[string]*rtm2.LockDetail, <-chan *rtm2.LockEvent, error) {
+	details, events, err := l.Lock.GetLockChan(channel, channelType)
+	if err != nil {
+		return nil, nil, err
+	}
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	key := chanKey{name: channel, channelType: channelType}
+	r, ok := l.m.locks[key]
+	if !ok {
+		r = newRelay(make(chan *rtm2.LockEvent))
+		l.m.locks[key] = r
+	}
+	r.attach(events)
+	return details, r.ch.(chan *rtm2.LockEvent), nil
+}
+
+func (m *Manager) replayLocks() {
+	for key, r := range m.relays(m.locks) {
+		details, events, err := m.RTMClient.Lock().GetLockChan(key.name, key.channelType)
+		if err != nil {
+			m.lg.Warn("restore lock chan failed", zap.String("channel", key.name), zap.Error(err))
+			continue
+		}
+		if !r.attach(events) {
+			continue
+		}
+		snapshot := make([]*rtm2.LockDetail, 0, len(details))
+		for _, detail := range details {
+			snapshot = append(snapshot, detail)
+		}
+		sort.Slice(snapshot, func(i, j int) bool {
+			return snapshot[i].Name < snapshot[j].Name
+		})
+		r.inject(&rtm2.LockEvent{Type: rtm2.LockTypeSnapshot, Details: snapshot})
+	}
+}
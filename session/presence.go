@@ -0,0 +1,80 @@
+package session
+
+import (
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+// presence records the state set by SetState, which is restored after replay.
+type presence struct {
+	rtm2.Presence
+	m *Manager
+}
+
+// GetPresenceChan returns the same golang chan for the same channel, until Unsubscribe or Leave.
+// After the channel is joined again, a PresenceTypeSnapshot event is injected.
+func (p *presence) GetPresenceChan(channel string, channelType rtm2.ChannelType) (map[string]*rtm2.UserState, <-chan *rtm2.PresenceEvent, error) {
+	users, events, err := p.Presence.GetPresenceChan(channel, channelType)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	key := chanKey{name: channel, channelType: channelType}
+	r, ok := p.m.presences[key]
+	if !ok {
+		r = newRelay(make(chan *rtm2.PresenceEvent))
+		p.m.presences[key] = r
+	}
+	r.attach(events)
+	return users, r.ch.(chan *rtm2.PresenceEvent), nil
+}
+
+func (p *presence) SetState(channel string, channelType rtm2.ChannelType, data map[string]string) error {
+	if err := p.Presence.SetState(channel, channelType, data); err != nil {
+		return err
+	}
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	key := chanKey{name: channel, channelType: channelType}
+	state, ok := p.m.states[key]
+	if !ok {
+		state = make(map[string]string)
+		p.m.states[key] = state
+	}
+	for k, v := range data {
+		state[k] = v
+	}
+	return nil
+}
+
+func (p *presence) RemoveState(channel string, channelType rtm2.ChannelType, keys []string) error {
+	if err := p.Presence.RemoveState(channel, channelType, keys); err != nil {
+		return err
+	}
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	state := p.m.states[chanKey{name: channel, channelType: channelType}]
+	for _, k := range keys {
+		delete(state, k)
+	}
+	return nil
+}
+
+func (m *Manager) replayPresence() {
+	for key, r := range m.relays(m.presences) {
+		users, events, err := m.RTMClient.Presence().GetPresenceChan(key.name, key.channelType)
+		if err != nil {
+			m.lg.Warn("restore presence chan failed", zap.String("channel", key.name), zap.Error(err))
+			continue
+		}
+		if !r.attach(events) {
+			continue
+		}
+		states := make(map[string]map[string]string, len(users))
+		for userId, user := range users {
+			states[userId] = user.State
+		}
+		r.inject(&rtm2.PresenceEvent{Type: rtm2.PresenceTypeSnapshot, States: states})
+	}
+}
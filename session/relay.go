@@ -0,0 +1,77 @@
+package session
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+)
+
+// relay forwards events from the current golang chan of rtm sdk to a stable golang chan of consumers.
+// The sdk chan is swapped by attach after resubscription, while consumers keep reading the same chan.
+type relay struct {
+	ch  interface{}
+	out reflect.Value
+	box *outbox.Outbox
+
+	mu   sync.Mutex
+	in   interface{}
+	stop chan struct{}
+}
+
+// newRelay takes the consumer chan, which must be a bidirectional golang chan.
+func newRelay(out interface{}) *relay {
+	return &relay{ch: out, out: reflect.ValueOf(out), box: outbox.New()}
+}
+
+// attach starts forwarding from in, and stops forwarding from the previous chan.
+// Returns false if in is already attached.
+func (r *relay) attach(in interface{}) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.in == in {
+		return false
+	}
+	if r.stop != nil {
+		close(r.stop)
+	}
+	r.in = in
+	r.stop = make(chan struct{})
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(in)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.stop)},
+	}
+	go func() {
+		for {
+			chosen, v, ok := reflect.Select(cases)
+			if chosen == 1 || !ok {
+				return
+			}
+			r.inject(v.Interface())
+		}
+	}()
+	return true
+}
+
+// inject queues an event to consumers, e.g. a snapshot after resubscription.
+func (r *relay) inject(event interface{}) {
+	v := reflect.ValueOf(event)
+	r.box.Push(func(done <-chan struct{}) {
+		reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: r.out, Send: v},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+		})
+	})
+}
+
+// close stops forwarding. The consumer chan is left open, same as rtm sdk.
+func (r *relay) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+	r.in = nil
+	r.box.Close()
+}
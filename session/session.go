@@ -0,0 +1,341 @@
+// Package session keeps subscriptions of an RTMClient alive across reconnection.
+//
+// Manager records every Subscribe, StreamChannel.Join, JoinTopic, SubscribeTopic and presence state,
+// and replays them after ConnectionChangedReasonRejoinSuccess or a fresh Login.
+// Consumers keep reading the same golang chans: after a channel is joined again,
+// a snapshot event is injected into the corresponding TopicEvent, StorageEvent, LockEvent or PresenceEvent chan.
+package session
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+type Options struct {
+	Logger *zap.Logger
+
+	// TokenProvider generates tokens for UserId, see WithTokenProvider.
+	TokenProvider rtm2.TokenProvider
+	UserId        string
+	// RenewBefore is how long before expiry a token is renewed, 30 seconds by default.
+	RenewBefore time.Duration
+	// MinBackoff and MaxBackoff bound the delay between failed renewals, 1 second and 1 minute by default.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+type Option func(*Options)
+
+// WithLogger sets the logger for replay failures.
+func WithLogger(lg *zap.Logger) Option {
+	return func(o *Options) {
+		o.Logger = lg
+	}
+}
+
+// WithTokenProvider renews tokens of userId by provider:
+//   - Login with empty token takes the RTM token from provider.
+//   - StreamChannel Join without WithStreamToken takes the Stream Channel token from provider.
+//   - Tokens are renewed RenewBefore expiry, or once the token expiry golang chan notifies.
+func WithTokenProvider(userId string, provider rtm2.TokenProvider) Option {
+	return func(o *Options) {
+		o.UserId = userId
+		o.TokenProvider = provider
+	}
+}
+
+// WithRenewBefore sets how long before expiry a token is renewed.
+func WithRenewBefore(d time.Duration) Option {
+	return func(o *Options) {
+		o.RenewBefore = d
+	}
+}
+
+// WithRenewBackoff sets the delay between failed renewals, doubled on each failure from min up to max.
+func WithRenewBackoff(min, max time.Duration) Option {
+	return func(o *Options) {
+		o.MinBackoff = min
+		o.MaxBackoff = max
+	}
+}
+
+type chanKey struct {
+	name        string
+	channelType rtm2.ChannelType
+}
+
+type channelRecord struct {
+	opts     []rtm2.MessageOption
+	messages *relay
+}
+
+// Manager is an RTMClient replaying recorded subscriptions. Records are cleared by Logout.
+type Manager struct {
+	rtm2.RTMClient
+	opts Options
+	lg   *zap.Logger
+
+	mu        sync.Mutex
+	conn      *relay
+	tokens    *relay
+	connStop  chan struct{}
+	renewer   *renewer
+	channels  map[string]*channelRecord
+	streams   map[string]*stream
+	states    map[chanKey]map[string]string
+	metadata  map[chanKey]*relay
+	locks     map[chanKey]*relay
+	presences map[chanKey]*relay
+
+	replaying sync.Mutex
+}
+
+func New(client rtm2.RTMClient, opts ...Option) *Manager {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+	if o.RenewBefore <= 0 {
+		o.RenewBefore = 30 * time.Second
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = time.Minute
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = o.MinBackoff
+	}
+	return &Manager{
+		RTMClient: client,
+		opts:      *o,
+		lg:        o.Logger,
+		conn:      newRelay(make(chan *rtm2.ConnectionEvent)),
+		tokens:    newRelay(make(chan string)),
+		channels:  make(map[string]*channelRecord),
+		streams:   make(map[string]*stream),
+		states:    make(map[chanKey]map[string]string),
+		metadata:  make(map[chanKey]*relay),
+		locks:     make(map[chanKey]*relay),
+		presences: make(map[chanKey]*relay),
+	}
+}
+
+// Login replays recorded subscriptions before returning.
+// The returned golang chans stay the same for every Login of this Manager.
+// With WithTokenProvider, empty token is taken from the provider.
+func (m *Manager) Login(token string) (<-chan *rtm2.ConnectionEvent, <-chan string, error) {
+	var expire time.Time
+	if token == "" && m.opts.TokenProvider != nil {
+		var err error
+		if token, expire, err = m.token(""); err != nil {
+			return nil, nil, err
+		}
+	}
+	events, tokens, err := m.RTMClient.Login(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.mu.Lock()
+	if m.connStop != nil {
+		close(m.connStop)
+	}
+	if m.renewer != nil {
+		m.renewer.close()
+		m.renewer = nil
+	}
+	stop := make(chan struct{})
+	m.connStop = stop
+	if m.opts.TokenProvider != nil {
+		m.renewer = newRenewer(m, tokens)
+		if !expire.IsZero() {
+			m.renewer.schedule("", expire)
+		}
+	} else {
+		m.tokens.attach(tokens)
+	}
+	m.mu.Unlock()
+	go m.watch(events, stop)
+	m.replay()
+	return m.conn.ch.(chan *rtm2.ConnectionEvent), m.tokens.ch.(chan string), nil
+}
+
+func (m *Manager) watch(events <-chan *rtm2.ConnectionEvent, stop chan struct{}) {
+	for {
+		select {
+		case e := <-events:
+			m.conn.inject(e)
+			if e.Reason == rtm2.ConnectionChangedReasonRejoinSuccess {
+				go m.replay()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Logout clears all records.
+func (m *Manager) Logout() error {
+	err := m.RTMClient.Logout()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.connStop != nil {
+		close(m.connStop)
+		m.connStop = nil
+	}
+	if m.renewer != nil {
+		m.renewer.close()
+		m.renewer = nil
+	}
+	for name, rec := range m.channels {
+		rec.messages.close()
+		delete(m.channels, name)
+	}
+	for _, s := range m.streams {
+		s.reset()
+	}
+	for key := range m.states {
+		delete(m.states, key)
+	}
+	m.closeRelays(nil)
+	return err
+}
+
+// closeRelays closes Storage / Lock / Presence relays of certain channel, or all if key is nil.
+// Must be called with m.mu locked.
+func (m *Manager) closeRelays(key *chanKey) {
+	for _, relays := range []map[chanKey]*relay{m.metadata, m.locks, m.presences} {
+		for k, r := range relays {
+			if key == nil || k == *key {
+				r.close()
+				delete(relays, k)
+			}
+		}
+	}
+}
+
+func (m *Manager) Subscribe(channel string, opts ...rtm2.MessageOption) (chan *rtm2.Message, error) {
+	messages, err := m.RTMClient.Subscribe(channel, opts...)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.channels[channel]
+	if !ok {
+		rec = &channelRecord{messages: newRelay(make(chan *rtm2.Message))}
+		m.channels[channel] = rec
+	}
+	rec.opts = opts
+	rec.messages.attach(messages)
+	return rec.messages.ch.(chan *rtm2.Message), nil
+}
+
+func (m *Manager) Unsubscribe(channel string) error {
+	if err := m.RTMClient.Unsubscribe(channel); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rec, ok := m.channels[channel]; ok {
+		rec.messages.close()
+		delete(m.channels, channel)
+	}
+	m.closeRelays(&chanKey{name: channel, channelType: rtm2.ChannelTypeMessage})
+	return nil
+}
+
+func (m *Manager) StreamChannel(channel string) rtm2.StreamChannel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[channel]
+	if !ok {
+		s = &stream{m: m, StreamChannel: m.RTMClient.StreamChannel(channel)}
+		m.streams[channel] = s
+	}
+	return s
+}
+
+func (m *Manager) Storage() rtm2.Storage {
+	return &storage{m: m, Storage: m.RTMClient.Storage()}
+}
+
+func (m *Manager) Lock() rtm2.Lock {
+	return &locks{m: m, Lock: m.RTMClient.Lock()}
+}
+
+func (m *Manager) Presence() rtm2.Presence {
+	return &presence{m: m, Presence: m.RTMClient.Presence()}
+}
+
+// replay resubscribes everything recorded. Already subscribed ones are kept untouched.
+func (m *Manager) replay() {
+	m.replaying.Lock()
+	defer m.replaying.Unlock()
+
+	m.mu.Lock()
+	channels := make(map[string]*channelRecord, len(m.channels))
+	for name, rec := range m.channels {
+		channels[name] = rec
+	}
+	streams := make([]*stream, 0, len(m.streams))
+	for _, s := range m.streams {
+		streams = append(streams, s)
+	}
+	states := make(map[chanKey]map[string]string, len(m.states))
+	for key, state := range m.states {
+		states[key] = copyState(state)
+	}
+	m.mu.Unlock()
+
+	for name, rec := range channels {
+		messages, err := m.RTMClient.Subscribe(name, rec.opts...)
+		switch {
+		case err == nil:
+			rec.messages.attach(messages)
+		case errors.Is(err, rtm2.ERR_ALREADY_SUBSCRIBED):
+		default:
+			m.lg.Warn("resubscribe failed", zap.String("channel", name), zap.Error(err))
+		}
+	}
+	for _, s := range streams {
+		s.replay()
+	}
+	for key, state := range states {
+		if len(state) == 0 {
+			continue
+		}
+		if err := m.RTMClient.Presence().SetState(key.name, key.channelType, state); err != nil {
+			m.lg.Warn("restore presence state failed", zap.String("channel", key.name), zap.Error(err))
+		}
+	}
+	m.replayStorage()
+	m.replayLocks()
+	m.replayPresence()
+}
+
+func (m *Manager) relays(relays map[chanKey]*relay) map[chanKey]*relay {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make(map[chanKey]*relay, len(relays))
+	for key, r := range relays {
+		res[key] = r
+	}
+	return res
+}
+
+func copyState(state map[string]string) map[string]string {
+	res := make(map[string]string, len(state))
+	for k, v := range state {
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,49 @@
+package session
+
+import (
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+type storage struct {
+	rtm2.Storage
+	m *Manager
+}
+
+// GetChannelMetadataChan returns the same golang chan for the same channel, until Unsubscribe or Leave.
+// After the channel is joined again, a StorageEvent with all items is injected.
+func (s *storage) GetChannelMetadataChan(channel string, channelType rtm2.ChannelType) (map[string]*rtm2.MetadataItem, <-chan *rtm2.StorageEvent, error) {
+	items, events, err := s.Storage.GetChannelMetadataChan(channel, channelType)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	key := chanKey{name: channel, channelType: channelType}
+	r, ok := s.m.metadata[key]
+	if !ok {
+		r = newRelay(make(chan *rtm2.StorageEvent))
+		s.m.metadata[key] = r
+	}
+	r.attach(events)
+	return items, r.ch.(chan *rtm2.StorageEvent), nil
+}
+
+func (m *Manager) replayStorage() {
+	for key, r := range m.relays(m.metadata) {
+		_, events, err := m.RTMClient.Storage().GetChannelMetadataChan(key.name, key.channelType)
+		if err != nil {
+			m.lg.Warn("restore metadata chan failed", zap.String("channel", key.name), zap.Error(err))
+			continue
+		}
+		if !r.attach(events) {
+			continue
+		}
+		rev, items, err := m.RTMClient.Storage().GetChannelMetadata(key.name, key.channelType)
+		if err != nil {
+			m.lg.Warn("get metadata failed", zap.String("channel", key.name), zap.Error(err))
+			continue
+		}
+		r.inject(&rtm2.StorageEvent{MajorRevision: rev, Items: items})
+	}
+}
@@ -0,0 +1,221 @@
+package session
+
+import (
+	"errors"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+type topicRecord struct {
+	all      bool // subscribed with empty userIds
+	users    map[string]bool
+	messages *relay
+}
+
+// stream is a StreamChannel recording Join, JoinTopic and SubscribeTopic. Guarded by Manager.mu.
+type stream struct {
+	rtm2.StreamChannel
+	m *Manager
+
+	joined bool
+	opts   []rtm2.StreamOption
+	events *relay
+	tokens *relay
+	topics map[string][]rtm2.StreamOption
+	subs   map[string]*topicRecord
+}
+
+// reset must be called with Manager.mu locked.
+func (s *stream) reset() {
+	s.joined = false
+	s.opts = nil
+	if s.events != nil {
+		s.events.close()
+		s.tokens.close()
+		s.events = nil
+		s.tokens = nil
+	}
+	for _, sub := range s.subs {
+		sub.messages.close()
+	}
+	s.topics = nil
+	s.subs = nil
+}
+
+// Join returns the same TopicEvent chan and token chan after replay.
+// With WithTokenProvider, the token is taken from the provider unless WithStreamToken is set.
+func (s *stream) Join(opts ...rtm2.StreamOption) (map[string][]string, <-chan *rtm2.TopicEvent, <-chan string, error) {
+	joinOpts, expire, err := s.m.streamToken(s.ChannelName(), opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	snapshot, events, tokens, err := s.StreamChannel.Join(joinOpts...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.scheduleRenewal(s.ChannelName(), expire)
+	if s.events == nil {
+		s.events = newRelay(make(chan *rtm2.TopicEvent))
+		s.tokens = newRelay(make(chan string))
+		s.topics = make(map[string][]rtm2.StreamOption)
+		s.subs = make(map[string]*topicRecord)
+	}
+	s.joined = true
+	s.opts = opts
+	s.events.attach(events)
+	s.tokens.attach(tokens)
+	return snapshot, s.events.ch.(chan *rtm2.TopicEvent), s.tokens.ch.(chan string), nil
+}
+
+func (s *stream) Leave() error {
+	if err := s.StreamChannel.Leave(); err != nil {
+		return err
+	}
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.reset()
+	if s.m.renewer != nil {
+		s.m.renewer.cancel(s.ChannelName())
+	}
+	s.m.closeRelays(&chanKey{name: s.ChannelName(), channelType: rtm2.ChannelTypeStream})
+	return nil
+}
+
+func (s *stream) JoinTopic(topic string, opts ...rtm2.StreamOption) error {
+	if err := s.StreamChannel.JoinTopic(topic, opts...); err != nil {
+		return err
+	}
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if s.topics != nil {
+		s.topics[topic] = opts
+	}
+	return nil
+}
+
+func (s *stream) LeaveTopic(topic string) error {
+	if err := s.StreamChannel.LeaveTopic(topic); err != nil {
+		return err
+	}
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	delete(s.topics, topic)
+	return nil
+}
+
+// SubscribeTopic returns the same golang chan for the same topic, until Leave.
+func (s *stream) SubscribeTopic(topic string, userIds []string) (<-chan *rtm2.Message, error) {
+	messages, err := s.StreamChannel.SubscribeTopic(topic, userIds)
+	if err != nil {
+		return nil, err
+	}
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if s.subs == nil {
+		return messages, nil
+	}
+	sub, ok := s.subs[topic]
+	if !ok {
+		sub = &topicRecord{users: make(map[string]bool), messages: newRelay(make(chan *rtm2.Message))}
+		s.subs[topic] = sub
+	}
+	if len(userIds) == 0 {
+		sub.all = true
+	}
+	for _, userId := range userIds {
+		sub.users[userId] = true
+	}
+	sub.messages.attach(messages)
+	return sub.messages.ch.(chan *rtm2.Message), nil
+}
+
+func (s *stream) UnsubscribeTopic(topic string, userIds []string) error {
+	if err := s.StreamChannel.UnsubscribeTopic(topic, userIds); err != nil {
+		return err
+	}
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	sub, ok := s.subs[topic]
+	if !ok {
+		return nil
+	}
+	if len(userIds) == 0 {
+		sub.all = false
+		sub.users = make(map[string]bool)
+	}
+	for _, userId := range userIds {
+		delete(sub.users, userId)
+	}
+	return nil
+}
+
+// replay joins the channel, topics and subscriptions again.
+func (s *stream) replay() {
+	s.m.mu.Lock()
+	if !s.joined {
+		s.m.mu.Unlock()
+		return
+	}
+	opts := s.opts
+	topics := make(map[string][]rtm2.StreamOption, len(s.topics))
+	for topic, topicOpts := range s.topics {
+		topics[topic] = topicOpts
+	}
+	subs := make(map[string][]string, len(s.subs))
+	for topic, sub := range s.subs {
+		if !sub.all && len(sub.users) == 0 {
+			continue
+		}
+		var users []string
+		if !sub.all {
+			for userId := range sub.users {
+				users = append(users, userId)
+			}
+		}
+		subs[topic] = users
+	}
+	events, tokens := s.events, s.tokens
+	s.m.mu.Unlock()
+
+	name := s.ChannelName()
+	lg := s.m.lg.With(zap.String("channel", name))
+	joinOpts, expire, err := s.m.streamToken(name, opts)
+	if err != nil {
+		lg.Warn("get token failed", zap.Error(err))
+		return
+	}
+	snapshot, e, t, err := s.StreamChannel.Join(joinOpts...)
+	switch {
+	case err == nil:
+		s.m.mu.Lock()
+		s.m.scheduleRenewal(name, expire)
+		s.m.mu.Unlock()
+		events.attach(e)
+		tokens.attach(t)
+		events.inject(&rtm2.TopicEvent{Type: rtm2.TopicEventSnapshot, Channel: name, Snapshot: snapshot})
+	case errors.Is(err, rtm2.ERR_ALREADY_JOIN_CHANNEL):
+	default:
+		lg.Warn("rejoin failed", zap.Error(err))
+		return
+	}
+	for topic, topicOpts := range topics {
+		if err := s.StreamChannel.JoinTopic(topic, topicOpts...); err != nil && !errors.Is(err, rtm2.ERR_TOPIC_ALREADY_JOINED) {
+			lg.Warn("rejoin topic failed", zap.String("topic", topic), zap.Error(err))
+		}
+	}
+	for topic, users := range subs {
+		messages, err := s.StreamChannel.SubscribeTopic(topic, users)
+		if err != nil {
+			lg.Warn("resubscribe topic failed", zap.String("topic", topic), zap.Error(err))
+			continue
+		}
+		s.m.mu.Lock()
+		if sub, ok := s.subs[topic]; ok {
+			sub.messages.attach(messages)
+		}
+		s.m.mu.Unlock()
+	}
+}
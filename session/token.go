@@ -0,0 +1,211 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.uber.org/zap"
+)
+
+const tokenTimeout = 10 * time.Second
+
+// token asks the provider for the token of channel, empty for the RTM token.
+func (m *Manager) token(channel string) (string, time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenTimeout)
+	defer cancel()
+	return m.opts.TokenProvider.Token(ctx, m.opts.UserId, channel)
+}
+
+// streamToken appends WithStreamToken from the provider, unless a token is already set in opts.
+// Returns zero expire if no token is taken.
+func (m *Manager) streamToken(channel string, opts []rtm2.StreamOption) ([]rtm2.StreamOption, time.Time, error) {
+	if m.opts.TokenProvider == nil {
+		return opts, time.Time{}, nil
+	}
+	o := &rtm2.StreamOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Token != "" {
+		return opts, time.Time{}, nil
+	}
+	token, expire, err := m.token(channel)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return append(append([]rtm2.StreamOption(nil), opts...), rtm2.WithStreamToken(token)), expire, nil
+}
+
+// scheduleRenewal must be called with m.mu locked.
+func (m *Manager) scheduleRenewal(channel string, expire time.Time) {
+	if m.renewer != nil && !expire.IsZero() {
+		m.renewer.schedule(channel, expire)
+	}
+}
+
+// renewer consumes the token expiry golang chan of Login, and renews tokens before they expire.
+// Notices are still forwarded to the golang chan returned by Manager.Login.
+// Renewals run off the goroutine reading the chan, one at a time per channel.
+type renewer struct {
+	m    *Manager
+	due  chan string
+	stop chan struct{}
+
+	mu      sync.Mutex
+	timers  map[string]*time.Timer
+	backoff map[string]time.Duration
+	// renewing tells the channels renewed in flight, true if requested again meanwhile
+	renewing map[string]bool
+}
+
+func newRenewer(m *Manager, notices <-chan string) *renewer {
+	r := &renewer{
+		m:        m,
+		due:      make(chan string),
+		stop:     make(chan struct{}),
+		timers:   make(map[string]*time.Timer),
+		backoff:  make(map[string]time.Duration),
+		renewing: make(map[string]bool),
+	}
+	go r.run(notices)
+	return r
+}
+
+func (r *renewer) run(notices <-chan string) {
+	for {
+		select {
+		case channel := <-notices:
+			r.m.tokens.inject(channel)
+			r.start(channel)
+		case channel := <-r.due:
+			r.start(channel)
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// start renews the token of channel on its own goroutine, or once more after the renewal in flight.
+func (r *renewer) start(channel string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.renewing[channel]; ok {
+		r.renewing[channel] = true
+		return
+	}
+	r.renewing[channel] = false
+	go func() {
+		for {
+			r.renew(channel)
+			r.mu.Lock()
+			again := r.renewing[channel]
+			if !again {
+				delete(r.renewing, channel)
+			} else {
+				r.renewing[channel] = false
+			}
+			r.mu.Unlock()
+			if !again {
+				return
+			}
+		}
+	}()
+}
+
+// schedule renews the token of channel RenewBefore expire. A token living shorter than that is renewed
+// halfway through, and never sooner than MinBackoff. A zero expire is not scheduled.
+func (r *renewer) schedule(channel string, expire time.Time) {
+	if expire.IsZero() {
+		return
+	}
+	remaining := time.Until(expire)
+	d := remaining - r.m.opts.RenewBefore
+	if d < r.m.opts.MinBackoff {
+		d = max(remaining/2, r.m.opts.MinBackoff)
+	}
+	r.after(channel, d)
+}
+
+func (r *renewer) after(channel string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.stop:
+		return
+	default:
+	}
+	if t, ok := r.timers[channel]; ok {
+		t.Stop()
+	}
+	r.timers[channel] = time.AfterFunc(d, func() {
+		select {
+		case r.due <- channel:
+		case <-r.stop:
+		}
+	})
+}
+
+// cancel stops renewing the token of channel, e.g. after Leave.
+func (r *renewer) cancel(channel string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.timers[channel]; ok {
+		t.Stop()
+		delete(r.timers, channel)
+	}
+	delete(r.backoff, channel)
+}
+
+func (r *renewer) close() {
+	close(r.stop)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.timers {
+		t.Stop()
+	}
+}
+
+func (r *renewer) renew(channel string) {
+	if channel != "" && !r.m.joined(channel) {
+		r.cancel(channel)
+		return
+	}
+	lg := r.m.lg.With(zap.String("channel", channel))
+	token, expire, err := r.m.token(channel)
+	if err == nil {
+		if channel == "" {
+			err = r.m.RTMClient.RenewToken(token)
+		} else {
+			err = r.m.RTMClient.StreamChannel(channel).RenewToken(token)
+		}
+	}
+	if err != nil {
+		r.mu.Lock()
+		backoff := r.backoff[channel] * 2
+		if backoff < r.m.opts.MinBackoff {
+			backoff = r.m.opts.MinBackoff
+		}
+		if backoff > r.m.opts.MaxBackoff {
+			backoff = r.m.opts.MaxBackoff
+		}
+		r.backoff[channel] = backoff
+		r.mu.Unlock()
+		lg.Warn("renew token failed", zap.Duration("backoff", backoff), zap.Error(err))
+		r.after(channel, backoff)
+		return
+	}
+	lg.Debug("token renewed", zap.Time("expire", expire))
+	r.mu.Lock()
+	delete(r.backoff, channel)
+	r.mu.Unlock()
+	r.schedule(channel, expire)
+}
+
+func (m *Manager) joined(channel string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[channel]
+	return ok && s.joined
+}
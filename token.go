@@ -0,0 +1,92 @@
+package rtm2
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TokenProvider generates tokens for Login, RenewToken, StreamChannel Join and StreamChannel RenewToken.
+// Empty channel stands for the RTM token, otherwise the Stream Channel token.
+// Returns the token and when it expires.
+type TokenProvider interface {
+	Token(ctx context.Context, userId string, channel string) (string, time.Time, error)
+}
+
+// TokenProviderFunc adapts a function to TokenProvider, e.g. a call to your token server.
+type TokenProviderFunc func(ctx context.Context, userId string, channel string) (string, time.Time, error)
+
+func (f TokenProviderFunc) Token(ctx context.Context, userId string, channel string) (string, time.Time, error) {
+	return f(ctx, userId, channel)
+}
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+const hmacTokenVersion = "hmac1"
+
+// HMACTokenProvider signs tokens locally with a shared secret, for development and tests without a token server.
+// Tokens are NOT accepted by the Agora service.
+type HMACTokenProvider struct {
+	appid  string
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewHMACTokenProvider creates a provider whose tokens expire after ttl.
+func NewHMACTokenProvider(appid string, secret []byte, ttl time.Duration) *HMACTokenProvider {
+	return &HMACTokenProvider{appid: appid, secret: secret, ttl: ttl}
+}
+
+func (p *HMACTokenProvider) Token(ctx context.Context, userId string, channel string) (string, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return "", time.Time{}, err
+	}
+	expire := time.Now().Add(p.ttl).Truncate(time.Second)
+	payload := strings.Join([]string{p.appid, userId, channel, strconv.FormatInt(expire.Unix(), 10)}, "\n")
+	token := hmacTokenVersion + "." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + p.sign(payload)
+	return token, expire, nil
+}
+
+// Verify checks the signature and expiry of token generated for userId and channel.
+// Returns when the token expires.
+func (p *HMACTokenProvider) Verify(token string, userId string, channel string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != hmacTokenVersion {
+		return time.Time{}, ErrInvalidToken
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, ErrInvalidToken
+	}
+	payload := string(raw)
+	if !hmac.Equal([]byte(p.sign(payload)), []byte(parts[2])) {
+		return time.Time{}, ErrInvalidToken
+	}
+	fields := strings.Split(payload, "\n")
+	if len(fields) != 4 || fields[0] != p.appid || fields[1] != userId || fields[2] != channel {
+		return time.Time{}, ErrInvalidToken
+	}
+	ts, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidToken
+	}
+	expire := time.Unix(ts, 0)
+	if !time.Now().Before(expire) {
+		return expire, ErrTokenExpired
+	}
+	return expire, nil
+}
+
+func (p *HMACTokenProvider) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
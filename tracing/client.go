@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedClient struct {
+	rtm2.ContextClient
+	t *tracer
+
+	mu      sync.Mutex
+	streams map[string]*tracedStream
+}
+
+func (c *tracedClient) Login(ctx context.Context, token string) (<-chan *rtm2.ConnectionEvent, <-chan string, error) {
+	ctx, span := c.t.start(ctx, "Login", trace.SpanKindClient)
+	events, tokens, err := c.ContextClient.Login(ctx, token)
+	return events, tokens, end(span, err)
+}
+
+func (c *tracedClient) Logout(ctx context.Context) error {
+	ctx, span := c.t.start(ctx, "Logout", trace.SpanKindClient)
+	return end(span, c.ContextClient.Logout(ctx))
+}
+
+func (c *tracedClient) SetParameters(ctx context.Context, params map[string]interface{}) error {
+	ctx, span := c.t.start(ctx, "SetParameters", trace.SpanKindClient)
+	return end(span, c.ContextClient.SetParameters(ctx, params))
+}
+
+func (c *tracedClient) GetParameters(ctx context.Context) (map[string]interface{}, error) {
+	ctx, span := c.t.start(ctx, "GetParameters", trace.SpanKindClient)
+	params, err := c.ContextClient.GetParameters(ctx)
+	return params, end(span, err)
+}
+
+func (c *tracedClient) RenewToken(ctx context.Context, token string) error {
+	ctx, span := c.t.start(ctx, "RenewToken", trace.SpanKindClient)
+	return end(span, c.ContextClient.RenewToken(ctx, token))
+}
+
+func (c *tracedClient) Storage() rtm2.ContextStorage {
+	return &tracedStorage{ContextStorage: c.ContextClient.Storage(), t: c.t}
+}
+
+func (c *tracedClient) Lock() rtm2.ContextLock {
+	return &tracedLock{ContextLock: c.ContextClient.Lock(), t: c.t}
+}
+
+func (c *tracedClient) Presence() rtm2.ContextPresence {
+	return &tracedPresence{ContextPresence: c.ContextClient.Presence(), t: c.t}
+}
+
+func (c *tracedClient) Publish(ctx context.Context, channel string, message []byte, opts ...rtm2.MessageOption) error {
+	ctx, span := c.t.start(ctx, "Publish", trace.SpanKindProducer, append(channelAttrs(channel, rtm2.ChannelTypeMessage), attribute.Int("messaging.message.body.size", len(message)))...)
+	return end(span, c.ContextClient.Publish(ctx, channel, c.t.wrap(ctx, message), opts...))
+}
+
+func (c *tracedClient) Subscribe(ctx context.Context, channel string, opts ...rtm2.MessageOption) (chan *rtm2.Message, error) {
+	attrs := channelAttrs(channel, rtm2.ChannelTypeMessage)
+	ctx, span := c.t.start(ctx, "Subscribe", trace.SpanKindClient, attrs...)
+	messages, err := c.ContextClient.Subscribe(ctx, channel, opts...)
+	if end(span, err) != nil {
+		return nil, err
+	}
+	return c.t.receive(messages, "receive", attrs...), nil
+}
+
+func (c *tracedClient) Unsubscribe(ctx context.Context, channel string) error {
+	ctx, span := c.t.start(ctx, "Unsubscribe", trace.SpanKindClient, channelAttrs(channel, rtm2.ChannelTypeMessage)...)
+	return end(span, c.ContextClient.Unsubscribe(ctx, channel))
+}
+
+func (c *tracedClient) StreamChannel(channel string) rtm2.ContextStreamChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.streams[channel]
+	if !ok {
+		s = &tracedStream{ContextStreamChannel: c.ContextClient.StreamChannel(channel), t: c.t, topics: make(map[<-chan *rtm2.Message]chan *rtm2.Message)}
+		c.streams[channel] = s
+	}
+	return s
+}
@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/internal/outbox"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The envelope is envelopePrefix, the carrier in JSON, a newline, and then the payload.
+// It is plain text, so string messages stay valid UTF-8.
+const envelopePrefix = "\x1frtm2-trace:"
+
+func (t *tracer) wrap(ctx context.Context, message []byte) []byte {
+	if !t.opts.Envelope {
+		return message
+	}
+	carrier := propagation.MapCarrier{}
+	t.opts.Propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return message
+	}
+	// JSON escapes newlines in strings, so the header ends at the first one
+	header, _ := json.Marshal(carrier)
+	data := make([]byte, 0, len(envelopePrefix)+len(header)+1+len(message))
+	data = append(data, envelopePrefix...)
+	data = append(data, header...)
+	data = append(data, '\n')
+	return append(data, message...)
+}
+
+// unwrap returns the producer context and the payload, or ctx and message as is without an envelope.
+// A message is only unwrapped if its header is a JSON object carrying a field of the propagator.
+func (t *tracer) unwrap(ctx context.Context, message []byte) (context.Context, []byte) {
+	rest, ok := bytes.CutPrefix(message, []byte(envelopePrefix))
+	if !ok {
+		return ctx, message
+	}
+	header, payload, ok := bytes.Cut(rest, []byte{'\n'})
+	if !ok {
+		return ctx, message
+	}
+	carrier := propagation.MapCarrier{}
+	if err := json.Unmarshal(header, &carrier); err != nil {
+		return ctx, message
+	}
+	for _, field := range t.opts.Propagator.Fields() {
+		if _, ok := carrier[field]; ok {
+			return t.opts.Propagator.Extract(ctx, carrier), payload
+		}
+	}
+	return ctx, message
+}
+
+const trackedMessages = 1024
+
+// tracked keeps the context of the latest received messages for MessageContext.
+var tracked = struct {
+	sync.Mutex
+	contexts map[*rtm2.Message]context.Context
+	ring     [trackedMessages]*rtm2.Message
+	next     int
+}{contexts: make(map[*rtm2.Message]context.Context)}
+
+func track(m *rtm2.Message, ctx context.Context) {
+	tracked.Lock()
+	defer tracked.Unlock()
+	if old := tracked.ring[tracked.next]; old != nil {
+		delete(tracked.contexts, old)
+	}
+	tracked.ring[tracked.next] = m
+	tracked.next = (tracked.next + 1) % trackedMessages
+	tracked.contexts[m] = ctx
+}
+
+// MessageContext returns a context carrying the consumer span of m, so that processing spans join the trace.
+// Only the latest 1024 received messages are kept, context.Background() is returned for others.
+func MessageContext(m *rtm2.Message) context.Context {
+	tracked.Lock()
+	defer tracked.Unlock()
+	if ctx, ok := tracked.contexts[m]; ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// receive starts a consumer span for each message, and delivers unwrapped messages to the returned golang chan.
+// The span ends once the message is read.
+func (t *tracer) receive(in <-chan *rtm2.Message, op string, attrs ...attribute.KeyValue) chan *rtm2.Message {
+	out := make(chan *rtm2.Message)
+	box := outbox.New()
+	go func() {
+		for m := range in {
+			producer, payload := t.unwrap(context.Background(), m.Message)
+			link := trace.LinkFromContext(producer)
+			_, span := t.start(producer, op, trace.SpanKindConsumer, append(attrs[:len(attrs):len(attrs)], attribute.String("rtm.user_id", m.UserId))...)
+			if link.SpanContext.IsValid() {
+				span.AddLink(link)
+			}
+			msg := &rtm2.Message{UserId: m.UserId, Type: m.Type, Message: payload}
+			track(msg, trace.ContextWithSpanContext(context.Background(), span.SpanContext()))
+			box.Push(func(done <-chan struct{}) {
+				select {
+				case out <- msg:
+				case <-done:
+				}
+				span.End()
+			})
+		}
+	}()
+	return out
+}
@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedLock struct {
+	rtm2.ContextLock
+	t *tracer
+}
+
+func (l *tracedLock) start(ctx context.Context, op string, channel string, channelType rtm2.ChannelType, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return l.t.start(ctx, "Lock."+op, trace.SpanKindClient, append(channelAttrs(channel, channelType), attrs...)...)
+}
+
+func lockAttr(name string) attribute.KeyValue {
+	return attribute.String("rtm.lock", name)
+}
+
+func (l *tracedLock) GetLockChan(ctx context.Context, channel string, channelType rtm2.ChannelType) (map[string]*rtm2.LockDetail, <-chan *rtm2.LockEvent, error) {
+	ctx, span := l.start(ctx, "GetLockChan", channel, channelType)
+	details, events, err := l.ContextLock.GetLockChan(ctx, channel, channelType)
+	return details, events, end(span, err)
+}
+
+func (l *tracedLock) Set(ctx context.Context, channel string, channelType rtm2.ChannelType, name string, ttl uint32) error {
+	ctx, span := l.start(ctx, "Set", channel, channelType, lockAttr(name), attribute.Int("rtm.lock_ttl", int(ttl)))
+	return end(span, l.ContextLock.Set(ctx, channel, channelType, name, ttl))
+}
+
+func (l *tracedLock) Get(ctx context.Context, channel string, channelType rtm2.ChannelType) (map[string]*rtm2.LockDetail, error) {
+	ctx, span := l.start(ctx, "Get", channel, channelType)
+	details, err := l.ContextLock.Get(ctx, channel, channelType)
+	return details, end(span, err)
+}
+
+func (l *tracedLock) Remove(ctx context.Context, channel string, channelType rtm2.ChannelType, name string) error {
+	ctx, span := l.start(ctx, "Remove", channel, channelType, lockAttr(name))
+	return end(span, l.ContextLock.Remove(ctx, channel, channelType, name))
+}
+
+func (l *tracedLock) Acquire(ctx context.Context, channel string, channelType rtm2.ChannelType, name string, retry bool) error {
+	ctx, span := l.start(ctx, "Acquire", channel, channelType, lockAttr(name), attribute.Bool("rtm.lock_retry", retry))
+	return end(span, l.ContextLock.Acquire(ctx, channel, channelType, name, retry))
+}
+
+func (l *tracedLock) Release(ctx context.Context, channel string, channelType rtm2.ChannelType, name string) error {
+	ctx, span := l.start(ctx, "Release", channel, channelType, lockAttr(name))
+	return end(span, l.ContextLock.Release(ctx, channel, channelType, name))
+}
+
+func (l *tracedLock) Revoke(ctx context.Context, channel string, channelType rtm2.ChannelType, name string, owner string) error {
+	ctx, span := l.start(ctx, "Revoke", channel, channelType, lockAttr(name), attribute.String("rtm.lock_owner", owner))
+	return end(span, l.ContextLock.Revoke(ctx, channel, channelType, name, owner))
+}
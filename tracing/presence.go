@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedPresence struct {
+	rtm2.ContextPresence
+	t *tracer
+}
+
+func (p *tracedPresence) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return p.t.start(ctx, "Presence."+op, trace.SpanKindClient, attrs...)
+}
+
+func (p *tracedPresence) GetPresenceChan(ctx context.Context, channel string, channelType rtm2.ChannelType) (map[string]*rtm2.UserState, <-chan *rtm2.PresenceEvent, error) {
+	ctx, span := p.start(ctx, "GetPresenceChan", channelAttrs(channel, channelType)...)
+	users, events, err := p.ContextPresence.GetPresenceChan(ctx, channel, channelType)
+	return users, events, end(span, err)
+}
+
+func (p *tracedPresence) WhoNow(ctx context.Context, channel string, channelType rtm2.ChannelType, opts ...rtm2.PresenceOption) (map[string]*rtm2.UserState, string, error) {
+	ctx, span := p.start(ctx, "WhoNow", channelAttrs(channel, channelType)...)
+	users, next, err := p.ContextPresence.WhoNow(ctx, channel, channelType, opts...)
+	return users, next, end(span, err)
+}
+
+func (p *tracedPresence) WhereNow(ctx context.Context, userId string) ([]*rtm2.ChannelInfo, error) {
+	ctx, span := p.start(ctx, "WhereNow", userAttr(userId))
+	channels, err := p.ContextPresence.WhereNow(ctx, userId)
+	return channels, end(span, err)
+}
+
+func (p *tracedPresence) SetState(ctx context.Context, channel string, channelType rtm2.ChannelType, data map[string]string) error {
+	ctx, span := p.start(ctx, "SetState", channelAttrs(channel, channelType)...)
+	return end(span, p.ContextPresence.SetState(ctx, channel, channelType, data))
+}
+
+func (p *tracedPresence) RemoveState(ctx context.Context, channel string, channelType rtm2.ChannelType, keys []string) error {
+	ctx, span := p.start(ctx, "RemoveState", channelAttrs(channel, channelType)...)
+	return end(span, p.ContextPresence.RemoveState(ctx, channel, channelType, keys))
+}
+
+func (p *tracedPresence) GetState(ctx context.Context, channel string, channelType rtm2.ChannelType, userId string) (map[string]string, error) {
+	ctx, span := p.start(ctx, "GetState", append(channelAttrs(channel, channelType), userAttr(userId))...)
+	state, err := p.ContextPresence.GetState(ctx, channel, channelType, userId)
+	return state, end(span, err)
+}
@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedStorage struct {
+	rtm2.ContextStorage
+	t *tracer
+}
+
+func (s *tracedStorage) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.t.start(ctx, "Storage."+op, trace.SpanKindClient, attrs...)
+}
+
+func userAttr(userId string) attribute.KeyValue {
+	return attribute.String("rtm.user_id", userId)
+}
+
+func (s *tracedStorage) GetChannelMetadataChan(ctx context.Context, channel string, channelType rtm2.ChannelType) (map[string]*rtm2.MetadataItem, <-chan *rtm2.StorageEvent, error) {
+	ctx, span := s.start(ctx, "GetChannelMetadataChan", channelAttrs(channel, channelType)...)
+	items, events, err := s.ContextStorage.GetChannelMetadataChan(ctx, channel, channelType)
+	return items, events, end(span, err)
+}
+
+func (s *tracedStorage) SetChannelMetadata(ctx context.Context, channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	ctx, span := s.start(ctx, "SetChannelMetadata", channelAttrs(channel, channelType)...)
+	return end(span, s.ContextStorage.SetChannelMetadata(ctx, channel, channelType, data, opts...))
+}
+
+func (s *tracedStorage) UpdateChannelMetadata(ctx context.Context, channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	ctx, span := s.start(ctx, "UpdateChannelMetadata", channelAttrs(channel, channelType)...)
+	return end(span, s.ContextStorage.UpdateChannelMetadata(ctx, channel, channelType, data, opts...))
+}
+
+func (s *tracedStorage) RemoveChannelMetadata(ctx context.Context, channel string, channelType rtm2.ChannelType, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	ctx, span := s.start(ctx, "RemoveChannelMetadata", channelAttrs(channel, channelType)...)
+	return end(span, s.ContextStorage.RemoveChannelMetadata(ctx, channel, channelType, data, opts...))
+}
+
+func (s *tracedStorage) GetChannelMetadata(ctx context.Context, channel string, channelType rtm2.ChannelType) (int64, map[string]*rtm2.MetadataItem, error) {
+	ctx, span := s.start(ctx, "GetChannelMetadata", channelAttrs(channel, channelType)...)
+	rev, items, err := s.ContextStorage.GetChannelMetadata(ctx, channel, channelType)
+	return rev, items, end(span, err)
+}
+
+func (s *tracedStorage) SetUserMetadata(ctx context.Context, userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	ctx, span := s.start(ctx, "SetUserMetadata", userAttr(userId))
+	return end(span, s.ContextStorage.SetUserMetadata(ctx, userId, data, opts...))
+}
+
+func (s *tracedStorage) UpdateUserMetadata(ctx context.Context, userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	ctx, span := s.start(ctx, "UpdateUserMetadata", userAttr(userId))
+	return end(span, s.ContextStorage.UpdateUserMetadata(ctx, userId, data, opts...))
+}
+
+func (s *tracedStorage) RemoveUserMetadata(ctx context.Context, userId string, data map[string]*rtm2.MetadataItem, opts ...rtm2.StorageOption) error {
+	ctx, span := s.start(ctx, "RemoveUserMetadata", userAttr(userId))
+	return end(span, s.ContextStorage.RemoveUserMetadata(ctx, userId, data, opts...))
+}
+
+func (s *tracedStorage) GetUserMetadata(ctx context.Context, userId string) (int64, map[string]*rtm2.MetadataItem, error) {
+	ctx, span := s.start(ctx, "GetUserMetadata", userAttr(userId))
+	rev, items, err := s.ContextStorage.GetUserMetadata(ctx, userId)
+	return rev, items, end(span, err)
+}
+
+func (s *tracedStorage) SubscribeUserMetadata(ctx context.Context, userId string) (map[string]*rtm2.MetadataItem, <-chan *rtm2.StorageEvent, error) {
+	ctx, span := s.start(ctx, "SubscribeUserMetadata", userAttr(userId))
+	items, events, err := s.ContextStorage.SubscribeUserMetadata(ctx, userId)
+	return items, events, end(span, err)
+}
+
+func (s *tracedStorage) UnsubscribeUserMetadata(ctx context.Context, userId string) error {
+	ctx, span := s.start(ctx, "UnsubscribeUserMetadata", userAttr(userId))
+	return end(span, s.ContextStorage.UnsubscribeUserMetadata(ctx, userId))
+}
@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedStream struct {
+	rtm2.ContextStreamChannel
+	t *tracer
+
+	mu     sync.Mutex
+	topics map[<-chan *rtm2.Message]chan *rtm2.Message // by the sdk golang chan
+}
+
+func (s *tracedStream) start(ctx context.Context, op string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if len(attrs) == 0 {
+		attrs = channelAttrs(s.ChannelName(), rtm2.ChannelTypeStream)
+	}
+	return s.t.start(ctx, "StreamChannel."+op, kind, attrs...)
+}
+
+func (s *tracedStream) Join(ctx context.Context, opts ...rtm2.StreamOption) (map[string][]string, <-chan *rtm2.TopicEvent, <-chan string, error) {
+	ctx, span := s.start(ctx, "Join", trace.SpanKindClient)
+	snapshot, events, tokens, err := s.ContextStreamChannel.Join(ctx, opts...)
+	return snapshot, events, tokens, end(span, err)
+}
+
+func (s *tracedStream) Leave(ctx context.Context) error {
+	ctx, span := s.start(ctx, "Leave", trace.SpanKindClient)
+	err := end(span, s.ContextStreamChannel.Leave(ctx))
+	if err == nil {
+		s.mu.Lock()
+		s.topics = make(map[<-chan *rtm2.Message]chan *rtm2.Message)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *tracedStream) JoinTopic(ctx context.Context, topic string, opts ...rtm2.StreamOption) error {
+	ctx, span := s.start(ctx, "JoinTopic", trace.SpanKindClient, topicAttrs(s.ChannelName(), topic)...)
+	return end(span, s.ContextStreamChannel.JoinTopic(ctx, topic, opts...))
+}
+
+func (s *tracedStream) PublishTopic(ctx context.Context, topic string, message []byte, opts ...rtm2.StreamOption) error {
+	ctx, span := s.start(ctx, "PublishTopic", trace.SpanKindProducer, append(topicAttrs(s.ChannelName(), topic), attribute.Int("messaging.message.body.size", len(message)))...)
+	return end(span, s.ContextStreamChannel.PublishTopic(ctx, topic, s.t.wrap(ctx, message), opts...))
+}
+
+func (s *tracedStream) LeaveTopic(ctx context.Context, topic string) error {
+	ctx, span := s.start(ctx, "LeaveTopic", trace.SpanKindClient, topicAttrs(s.ChannelName(), topic)...)
+	return end(span, s.ContextStreamChannel.LeaveTopic(ctx, topic))
+}
+
+// SubscribeTopic returns the same golang chan for the same topic, as long as the sdk does.
+func (s *tracedStream) SubscribeTopic(ctx context.Context, topic string, userIds []string) (<-chan *rtm2.Message, error) {
+	attrs := topicAttrs(s.ChannelName(), topic)
+	ctx, span := s.start(ctx, "SubscribeTopic", trace.SpanKindClient, attrs...)
+	messages, err := s.ContextStreamChannel.SubscribeTopic(ctx, topic, userIds)
+	if end(span, err) != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out, ok := s.topics[messages]
+	if !ok {
+		out = s.t.receive(messages, "StreamChannel.receive", attrs...)
+		s.topics[messages] = out
+	}
+	return out, nil
+}
+
+func (s *tracedStream) UnsubscribeTopic(ctx context.Context, topic string, userIds []string) error {
+	ctx, span := s.start(ctx, "UnsubscribeTopic", trace.SpanKindClient, topicAttrs(s.ChannelName(), topic)...)
+	return end(span, s.ContextStreamChannel.UnsubscribeTopic(ctx, topic, userIds))
+}
+
+func (s *tracedStream) GetSubscribedUsers(ctx context.Context, topic string) ([]string, error) {
+	ctx, span := s.start(ctx, "GetSubscribedUsers", trace.SpanKindClient, topicAttrs(s.ChannelName(), topic)...)
+	users, err := s.ContextStreamChannel.GetSubscribedUsers(ctx, topic)
+	return users, end(span, err)
+}
+
+func (s *tracedStream) RenewToken(ctx context.Context, token string) error {
+	ctx, span := s.start(ctx, "RenewToken", trace.SpanKindClient)
+	return end(span, s.ContextStreamChannel.RenewToken(ctx, token))
+}
@@ -0,0 +1,109 @@
+// Package tracing adds OpenTelemetry spans to every call of a ContextClient.
+//
+//	client := tracing.Instrument(rtm2.WithContext(rtm2.CreateRTMClient(config)), tracing.WithEnvelope(true))
+//
+// With WithEnvelope, Publish and PublishTopic wrap the payload in an envelope carrying the W3C trace context.
+// Received messages are unwrapped whether the option is set or not, and a consumer span is started
+// for each of them, as a child of and linked to the producer span. Use MessageContext to continue the trace.
+// Clients without this package receive the envelope as part of the payload, so enable it on publishers only
+// once all subscribers are instrumented.
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tomasliu-agora/rtm2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/tomasliu-agora/rtm2/tracing"
+
+type Options struct {
+	TracerProvider trace.TracerProvider
+	// Propagator injects and extracts the trace context of messages, W3C trace context by default.
+	Propagator propagation.TextMapPropagator
+	// Envelope enables injecting the trace context into published messages.
+	Envelope bool
+}
+
+type Option func(*Options)
+
+// WithTracerProvider sets the provider, otel.GetTracerProvider() by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *Options) {
+		o.TracerProvider = tp
+	}
+}
+
+// WithPropagator sets the propagator of message trace context.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(o *Options) {
+		o.Propagator = p
+	}
+}
+
+// WithEnvelope whether to inject the trace context into published messages.
+func WithEnvelope(enabled bool) Option {
+	return func(o *Options) {
+		o.Envelope = enabled
+	}
+}
+
+type tracer struct {
+	trace.Tracer
+	opts *Options
+}
+
+// Instrument returns a ContextClient starting a span for each call.
+// Client() returns the uninstrumented RTMClient.
+func Instrument(client rtm2.ContextClient, opts ...Option) rtm2.ContextClient {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.TracerProvider == nil {
+		o.TracerProvider = otel.GetTracerProvider()
+	}
+	if o.Propagator == nil {
+		o.Propagator = propagation.TraceContext{}
+	}
+	t := &tracer{Tracer: o.TracerProvider.Tracer(instrumentationName), opts: o}
+	return &tracedClient{ContextClient: client, t: t, streams: make(map[string]*tracedStream)}
+}
+
+func (t *tracer) start(ctx context.Context, op string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs[:len(attrs):len(attrs)], attribute.String("messaging.system", "agora_rtm"))
+	return t.Start(ctx, "rtm2."+op, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// end records err on span and ends it. Returns err as is.
+func end(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if code := rtm2.ErrorCode(err); code != 0 {
+			span.SetAttributes(attribute.Int("rtm.error_code", code))
+		}
+		if errors.Is(err, rtm2.ErrCanceled) {
+			span.SetAttributes(attribute.Bool("rtm.canceled", true))
+		}
+	}
+	span.End()
+	return err
+}
+
+func channelAttrs(channel string, channelType rtm2.ChannelType) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.destination.name", channel),
+		attribute.Int("rtm.channel_type", int(channelType)),
+	}
+}
+
+func topicAttrs(channel string, topic string) []attribute.KeyValue {
+	return append(channelAttrs(channel, rtm2.ChannelTypeStream), attribute.String("rtm.topic", topic))
+}
@@ -0,0 +1,190 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tomasliu-agora/rtm2"
+	"github.com/tomasliu-agora/rtm2/memrtm"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func login(t *testing.T, hub *memrtm.Hub, userId string, opts ...Option) (rtm2.ContextClient, *tracetest.InMemoryExporter) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	client := Instrument(rtm2.WithContext(hub.CreateRTMClient(&rtm2.RTMConfig{Appid: "app", UserId: userId})), append(opts, WithTracerProvider(tp))...)
+	if _, _, err := client.Login(context.Background(), ""); err != nil {
+		t.Fatalf("login %s: %v", userId, err)
+	}
+	return client, exporter
+}
+
+func receive(t *testing.T, messages <-chan *rtm2.Message) *rtm2.Message {
+	t.Helper()
+	select {
+	case m := <-messages:
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func findSpan(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+// waitSpan polls the exporter until the span named name is ended.
+func waitSpan(t *testing.T, exporter *tracetest.InMemoryExporter, name string) *tracetest.SpanStub {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if span := findSpan(exporter.GetSpans(), name); span != nil {
+			return span
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no %s span", name)
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPublishPropagatesTraceContext(t *testing.T) {
+	hub := memrtm.NewHub()
+	pub, pubSpans := login(t, hub, "pub", WithEnvelope(true))
+	sub, subSpans := login(t, hub, "sub")
+	ctx := context.Background()
+	messages, err := sub.Subscribe(ctx, "ch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pub.Publish(ctx, "ch", []byte("hello"), rtm2.WithMessageType(rtm2.MessageTypeString)); err != nil {
+		t.Fatal(err)
+	}
+	m := receive(t, messages)
+	if string(m.Message) != "hello" {
+		t.Fatalf("payload = %q, want %q", m.Message, "hello")
+	}
+	consumer := trace.SpanContextFromContext(MessageContext(m))
+	if !consumer.IsValid() {
+		t.Fatal("MessageContext has no span")
+	}
+
+	producer := findSpan(pubSpans.GetSpans(), "rtm2.Publish")
+	if producer == nil {
+		t.Fatal("no producer span")
+	}
+	// the consumer span ends once the message is read
+	span := waitSpan(t, subSpans, "rtm2.receive")
+	if span.SpanKind != trace.SpanKindConsumer {
+		t.Errorf("consumer span kind = %v", span.SpanKind)
+	}
+	if span.SpanContext.TraceID() != producer.SpanContext.TraceID() || span.Parent.SpanID() != producer.SpanContext.SpanID() {
+		t.Errorf("consumer span is not a child of the producer span")
+	}
+	if len(span.Links) != 1 || span.Links[0].SpanContext.SpanID() != producer.SpanContext.SpanID() {
+		t.Errorf("consumer span is not linked to the producer span: %v", span.Links)
+	}
+}
+
+func TestEnvelopeIsText(t *testing.T) {
+	hub := memrtm.NewHub()
+	pub, _ := login(t, hub, "pub", WithEnvelope(true))
+	// a plain client sees the envelope as is
+	raw := hub.CreateRTMClient(&rtm2.RTMConfig{Appid: "app", UserId: "raw"})
+	if _, _, err := raw.Login(""); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := raw.Subscribe("ch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Publish(context.Background(), "ch", []byte("héllo"), rtm2.WithMessageType(rtm2.MessageTypeString)); err != nil {
+		t.Fatal(err)
+	}
+	m := receive(t, messages)
+	if !bytes.HasPrefix(m.Message, []byte(envelopePrefix)) || !bytes.HasSuffix(m.Message, []byte("\nhéllo")) {
+		t.Fatalf("message = %q, want an envelope", m.Message)
+	}
+	if !utf8.Valid(m.Message) {
+		t.Fatalf("envelope of a string message is not valid UTF-8: %q", m.Message)
+	}
+}
+
+func TestRawMessagesAreNotUnwrapped(t *testing.T) {
+	hub := memrtm.NewHub()
+	raw := hub.CreateRTMClient(&rtm2.RTMConfig{Appid: "app", UserId: "raw"})
+	if _, _, err := raw.Login(""); err != nil {
+		t.Fatal(err)
+	}
+	sub, _ := login(t, hub, "sub")
+	messages, err := sub.Subscribe(context.Background(), "ch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloads := [][]byte{
+		{0x1f, 0x03, 'a', 'b', 'c', 'd'},
+		[]byte(envelopePrefix),
+		[]byte(envelopePrefix + "not json\npayload"),
+		[]byte(envelopePrefix + `{"other":"x"}` + "\npayload"),
+	}
+	for _, p := range payloads {
+		if err := raw.Publish("ch", p); err != nil {
+			t.Fatal(err)
+		}
+		if m := receive(t, messages); !bytes.Equal(m.Message, p) {
+			t.Errorf("message = %q, want %q as is", m.Message, p)
+		}
+	}
+}
+
+func TestCallSpans(t *testing.T) {
+	hub := memrtm.NewHub()
+	client, spans := login(t, hub, "u1")
+	ctx := context.Background()
+	if _, err := client.Subscribe(ctx, "ch"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Subscribe(ctx, "ch"); err == nil {
+		t.Fatal("second Subscribe succeeded")
+	}
+	var subscribes []tracetest.SpanStub
+	for _, s := range spans.GetSpans() {
+		if s.Name == "rtm2.Subscribe" {
+			subscribes = append(subscribes, s)
+		}
+	}
+	if len(subscribes) != 2 {
+		t.Fatalf("got %d Subscribe spans, want 2", len(subscribes))
+	}
+	if subscribes[0].Status.Code != 0 {
+		t.Errorf("status of the successful Subscribe = %v", subscribes[0].Status)
+	}
+	failed := subscribes[1]
+	if failed.Status.Description == "" || len(failed.Events) == 0 {
+		t.Errorf("failed Subscribe does not record the error: %+v", failed.Status)
+	}
+	found := false
+	for _, a := range failed.Attributes {
+		if a.Key == "rtm.error_code" && a.Value.AsInt64() == int64(rtm2.ErrorCode(rtm2.ERR_ALREADY_SUBSCRIBED)) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("failed Subscribe has no rtm.error_code attribute: %v", failed.Attributes)
+	}
+}